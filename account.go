@@ -0,0 +1,430 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lighthoof/Chirpy/internal/auth"
+	"github.com/lighthoof/Chirpy/internal/database"
+	"github.com/lighthoof/Chirpy/internal/export"
+	"github.com/lighthoof/Chirpy/internal/oauth"
+)
+
+// Accounts with more chirps than this are exported in the background.
+const syncExportChirpLimit = 1000
+
+// A running export whose worker has not finished it after this long is
+// assumed lost, for instance to a restart, and is claimed again.
+const exportStaleAfter = 15 * time.Minute
+
+// deleteAccountHandler schedules the account for deletion. The user is purged
+// by runAccountPurger once the grace period has passed, unless they log in
+// again before that.
+func (cfg *apiConfig) deleteAccountHandler(w http.ResponseWriter, req *http.Request) {
+	reqBody := Auth{}
+
+	_ = unmarshalType(req, &reqBody)
+
+	userDb, ok := cfg.authenticateAccountOwner(w, req)
+	if !ok {
+		return
+	}
+
+	err := auth.CheckPasswordHash(userDb.HashedPassword, reqBody.Password)
+	if err != nil {
+		log.Printf("Incorrect password: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusForbidden, "Incorrect password")
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(req.Context(), nil)
+	if err != nil {
+		log.Printf("Unable to begin transaction: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	err = qtx.RequestUserDeletion(req.Context(), userDb.ID)
+	if err != nil {
+		log.Printf("Unable to schedule user deletion: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	err = qtx.RevokeUserRefreshTokens(req.Context(), userDb.ID)
+	if err != nil {
+		log.Printf("Unable to revoke sessions: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("Unable to commit transaction: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusAccepted, AccountDeletion{
+		PurgeAfter: time.Now().UTC().Add(cfg.deletionGracePeriod),
+	})
+}
+
+// exportAccountHandler streams the archive right away for small accounts and
+// queues a background export for large ones, which runDataExporter writes and
+// getDataExportHandler hands out once it is ready.
+func (cfg *apiConfig) exportAccountHandler(w http.ResponseWriter, req *http.Request) {
+	userDb, ok := cfg.authenticateAccountOwner(w, req)
+	if !ok {
+		return
+	}
+
+	chirpCount, err := cfg.dbQueries.CountChirpsByAuthor(req.Context(), userDb.ID)
+	if err != nil {
+		log.Printf("Unable to count chirps: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	if chirpCount <= syncExportChirpLimit {
+		archive, err := cfg.buildArchive(req.Context(), userDb.ID)
+		if err != nil {
+			log.Printf("Unable to build export: %s %s [%s]", req.Method, req.URL.Path, err)
+			respondWithError(w, http.StatusInternalServerError, "")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", `attachment; filename="chirpy-export.zip"`)
+		w.WriteHeader(http.StatusOK)
+		err = export.Write(w, archive)
+		if err != nil {
+			log.Printf("Unable to write export: %s %s [%s]", req.Method, req.URL.Path, err)
+		}
+		return
+	}
+
+	exportDb, err := cfg.dbQueries.CreateDataExport(req.Context(), userDb.ID)
+	if err != nil {
+		log.Printf("Unable to create export: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusAccepted, dataExportFromDb(exportDb))
+}
+
+func (cfg *apiConfig) getDataExportHandler(w http.ResponseWriter, req *http.Request) {
+	userDb, ok := cfg.authenticateAccountOwner(w, req)
+	if !ok {
+		return
+	}
+
+	exportID, err := uuid.Parse(req.PathValue("exportID"))
+	if err != nil {
+		log.Printf("Unable to parse exportID: %s", req.PathValue("exportID"))
+		respondWithError(w, http.StatusBadRequest, "")
+		return
+	}
+
+	exportDb, err := cfg.dbQueries.GetDataExport(req.Context(),
+		database.GetDataExportParams{ID: exportID, UserID: userDb.ID})
+	if err == sql.ErrNoRows {
+		log.Printf("Export not found")
+		respondWithError(w, http.StatusNotFound, "")
+		return
+	} else if err != nil {
+		log.Printf("Unable to retrieve export: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	if exportDb.Status != "done" {
+		respondWithJSON(w, http.StatusAccepted, dataExportFromDb(exportDb))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="chirpy-export.zip"`)
+	http.ServeFile(w, req, exportDb.FilePath)
+}
+
+// runDataExporter writes the queued exports. The queue lives in the
+// database, so exports requested before a restart are written afterwards, and
+// exports claimed by a worker that died are claimed again once they are
+// exportStaleAfter old. Several instances share the work through
+// FOR UPDATE SKIP LOCKED.
+func (cfg *apiConfig) runDataExporter(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			exportDb, err := cfg.dbQueries.ClaimDataExport(ctx, int32(exportStaleAfter.Seconds()))
+			if err == sql.ErrNoRows {
+				break
+			} else if err != nil {
+				log.Printf("Unable to claim data export: %s", err)
+				break
+			}
+			cfg.runDataExport(ctx, exportDb)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (cfg *apiConfig) runDataExport(ctx context.Context, exportDb database.DataExport) {
+	finish := database.FinishDataExportParams{ID: exportDb.ID, Status: "done"}
+	err := cfg.writeDataExport(ctx, exportDb, &finish)
+	if err != nil {
+		log.Printf("Unable to export data for user %s: %s", exportDb.UserID, err)
+		finish.Status = "failed"
+		finish.Error = err.Error()
+	}
+
+	err = cfg.dbQueries.FinishDataExport(ctx, finish)
+	if err != nil {
+		log.Printf("Unable to record export %s: %s", exportDb.ID, err)
+	}
+}
+
+func (cfg *apiConfig) writeDataExport(ctx context.Context, exportDb database.DataExport, finish *database.FinishDataExportParams) error {
+	archive, err := cfg.buildArchive(ctx, exportDb.UserID)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join(cfg.exportDir, exportDb.UserID.String())
+	err = os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return err
+	}
+
+	finish.FilePath = filepath.Join(dir, exportDb.ID.String()+".zip")
+	file, err := os.OpenFile(finish.FilePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	err = export.Write(file, archive)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func (cfg *apiConfig) buildArchive(ctx context.Context, userID uuid.UUID) (export.Archive, error) {
+	userDb, err := cfg.dbQueries.GetUserById(ctx, userID)
+	if err != nil {
+		return export.Archive{}, fmt.Errorf("unable to retrieve user: %w", err)
+	}
+
+	archive := export.Archive{
+		Profile: export.Profile{
+			ID:          userDb.ID.String(),
+			CreatedAt:   userDb.CreatedAt,
+			UpdatedAt:   userDb.UpdatedAt,
+			Email:       userDb.Email,
+			Username:    userDb.Username.String,
+			DisplayName: userDb.DisplayName,
+			Bio:         userDb.Bio,
+			AvatarURL:   userDb.AvatarUrl,
+			IsChirpyRed: userDb.IsChirpyRed,
+		},
+		Chirps:        []export.Chirp{},
+		Drafts:        []export.Draft{},
+		Sessions:      []export.Session{},
+		BillingEvents: []export.BillingEvent{},
+		Messages:      []export.Message{},
+	}
+
+	// Unlike the profile, the export includes scheduled and deleted chirps.
+	chirpsDb, err := cfg.dbQueries.GetAllChirpsByAuthor(ctx, userID)
+	if err != nil {
+		return export.Archive{}, fmt.Errorf("unable to retrieve chirps: %w", err)
+	}
+	for _, chirpDb := range chirpsDb {
+		archive.Chirps = append(archive.Chirps, export.Chirp{
+			ID:          chirpDb.ID.String(),
+			CreatedAt:   chirpDb.CreatedAt,
+			UpdatedAt:   chirpDb.UpdatedAt,
+			Body:        chirpDb.Body,
+			PublishAt:   optionalTime(chirpDb.PublishAt),
+			PublishedAt: optionalTime(chirpDb.PublishedAt),
+			EditedAt:    optionalTime(chirpDb.EditedAt),
+			DeletedAt:   optionalTime(chirpDb.DeletedAt),
+		})
+	}
+
+	draftsDb, err := cfg.dbQueries.GetDrafts(ctx, userID)
+	if err != nil {
+		return export.Archive{}, fmt.Errorf("unable to retrieve drafts: %w", err)
+	}
+	for _, draftDb := range draftsDb {
+		archive.Drafts = append(archive.Drafts, export.Draft{
+			ID:        draftDb.ID.String(),
+			CreatedAt: draftDb.CreatedAt,
+			UpdatedAt: draftDb.UpdatedAt,
+			Body:      draftDb.Body,
+		})
+	}
+
+	sessionsDb, err := cfg.dbQueries.GetUserSessions(ctx, userID)
+	if err != nil {
+		return export.Archive{}, fmt.Errorf("unable to retrieve sessions: %w", err)
+	}
+	for _, sessionDb := range sessionsDb {
+		archive.Sessions = append(archive.Sessions, export.Session{
+			CreatedAt: sessionDb.CreatedAt,
+			ExpiresAt: sessionDb.ExpiresAt,
+			RevokedAt: optionalTime(sessionDb.RevokedAt),
+			ClientID:  sessionDb.ClientID.String,
+		})
+	}
+
+	eventsDb, err := cfg.dbQueries.GetUserBillingEvents(ctx, uuid.NullUUID{UUID: userID, Valid: true})
+	if err != nil {
+		return export.Archive{}, fmt.Errorf("unable to retrieve billing events: %w", err)
+	}
+	for _, eventDb := range eventsDb {
+		archive.BillingEvents = append(archive.BillingEvents, export.BillingEvent{
+			CreatedAt: eventDb.CreatedAt,
+			Event:     eventDb.EventType,
+			Details:   eventDb.Status,
+		})
+	}
+
+	messagesDb, err := cfg.dbQueries.GetUserMessages(ctx, userID)
+	if err != nil {
+		return export.Archive{}, fmt.Errorf("unable to retrieve messages: %w", err)
+	}
+	for _, messageDb := range messagesDb {
+		archive.Messages = append(archive.Messages, export.Message{
+			ID:             messageDb.ID.String(),
+			CreatedAt:      messageDb.CreatedAt,
+			ConversationID: messageDb.ConversationID.String(),
+			SenderID:       messageDb.SenderID.String(),
+			Body:           messageDb.Body,
+		})
+	}
+
+	return archive, nil
+}
+
+// optionalTime converts a nullable time for the export.
+func optionalTime(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+// runAccountPurger hard-deletes accounts whose grace period has passed. Their
+// chirps, media, sessions and exports go with them through ON DELETE CASCADE;
+// the media blobs and export files are removed here.
+func (cfg *apiConfig) runAccountPurger(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := cfg.purgeDeletedUsers(ctx)
+		if err != nil {
+			log.Printf("Unable to purge deleted users: %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purgeDeletedUsers collects the media of the users before deleting them.
+// NOW() is fixed for a transaction, so both queries see the same users.
+func (cfg *apiConfig) purgeDeletedUsers(ctx context.Context) error {
+	graceSeconds := durationSeconds(cfg.deletionGracePeriod)
+
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	mediaDb, err := qtx.GetMediaOfPurgeableUsers(ctx, graceSeconds)
+	if err != nil {
+		return err
+	}
+
+	purgedIDs, err := qtx.PurgeDeletedUsers(ctx, graceSeconds)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	deleteMediaBlobs(ctx, cfg.blobStore, mediaDb)
+	for _, userID := range purgedIDs {
+		err = os.RemoveAll(filepath.Join(cfg.exportDir, userID.String()))
+		if err != nil {
+			log.Printf("Unable to remove exports of user %s: %s", userID, err)
+		}
+	}
+	if len(purgedIDs) > 0 {
+		log.Printf("Purged %d deleted users", len(purgedIDs))
+	}
+	return nil
+}
+
+func (cfg *apiConfig) authenticateAccountOwner(w http.ResponseWriter, req *http.Request) (database.User, bool) {
+	stringToken, err := auth.GetBearerToken(req.Header)
+	if err != nil {
+		log.Printf("Unable to get the token from request header: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusUnauthorized, "")
+		return database.User{}, false
+	}
+
+	userID, err := auth.ValidateJWTScope(stringToken, cfg.secret, oauth.ScopeProfile)
+	if err != nil {
+		log.Printf("Unable to validate the token: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusUnauthorized, "")
+		return database.User{}, false
+	}
+
+	userDb, err := cfg.dbQueries.GetUserById(req.Context(), userID)
+	if err != nil {
+		log.Printf("Unable to retrieve user: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusUnauthorized, "")
+		return database.User{}, false
+	}
+
+	return userDb, true
+}
+
+func dataExportFromDb(exportDb database.DataExport) DataExport {
+	return DataExport{
+		ID:        exportDb.ID,
+		CreatedAt: exportDb.CreatedAt,
+		Status:    exportDb.Status,
+		Error:     exportDb.Error,
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/lighthoof/Chirpy/internal/database"
+)
+
+var errBlocked = errors.New("one of the users has blocked the other")
+
+// blockHandler blocks the user of the request path. Follows in both
+// directions are removed, and checkInteraction keeps them from coming back.
+func (cfg *apiConfig) blockHandler(w http.ResponseWriter, req *http.Request) {
+	blockerID, blockedID, ok := cfg.parseRelationRequest(w, req)
+	if !ok {
+		return
+	}
+
+	if blockerID == blockedID {
+		respondWithError(w, http.StatusBadRequest, "Unable to block yourself")
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(req.Context(), nil)
+	if err != nil {
+		log.Printf("Unable to begin transaction: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	err = qtx.BlockUser(req.Context(),
+		database.BlockUserParams{BlockerID: blockerID, BlockedID: blockedID})
+	if isForeignKeyViolation(err) {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	} else if err != nil {
+		log.Printf("Unable to block user: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	err = qtx.RemoveFollowsBetween(req.Context(),
+		database.RemoveFollowsBetweenParams{FollowerID: blockerID, FolloweeID: blockedID})
+	if err != nil {
+		log.Printf("Unable to remove follows: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("Unable to commit transaction: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
+
+func (cfg *apiConfig) unblockHandler(w http.ResponseWriter, req *http.Request) {
+	blockerID, blockedID, ok := cfg.parseRelationRequest(w, req)
+	if !ok {
+		return
+	}
+
+	err := cfg.dbQueries.UnblockUser(req.Context(),
+		database.UnblockUserParams{BlockerID: blockerID, BlockedID: blockedID})
+	if err != nil {
+		log.Printf("Unable to unblock user: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
+
+func (cfg *apiConfig) muteHandler(w http.ResponseWriter, req *http.Request) {
+	muterID, mutedID, ok := cfg.parseRelationRequest(w, req)
+	if !ok {
+		return
+	}
+
+	if muterID == mutedID {
+		respondWithError(w, http.StatusBadRequest, "Unable to mute yourself")
+		return
+	}
+
+	err := cfg.dbQueries.MuteUser(req.Context(),
+		database.MuteUserParams{MuterID: muterID, MutedID: mutedID})
+	if isForeignKeyViolation(err) {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	} else if err != nil {
+		log.Printf("Unable to mute user: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
+
+func (cfg *apiConfig) unmuteHandler(w http.ResponseWriter, req *http.Request) {
+	muterID, mutedID, ok := cfg.parseRelationRequest(w, req)
+	if !ok {
+		return
+	}
+
+	err := cfg.dbQueries.UnmuteUser(req.Context(),
+		database.UnmuteUserParams{MuterID: muterID, MutedID: mutedID})
+	if err != nil {
+		log.Printf("Unable to unmute user: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
+
+// checkInteraction is the single check every handler that lets one user act
+// on another user or their content (following, voting and mentions) must
+// run. It returns errBlocked if either user has blocked the other.
+func (cfg *apiConfig) checkInteraction(ctx context.Context, actorID, targetID uuid.UUID) error {
+	if actorID == targetID {
+		return nil
+	}
+
+	blocked, err := cfg.dbQueries.IsBlockedBetween(ctx,
+		database.IsBlockedBetweenParams{BlockerID: actorID, BlockedID: targetID})
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return errBlocked
+	}
+	return nil
+}
+
+// checkMentions runs checkInteraction for every user mentioned in the body of
+// a chirp, so nobody can mention a user they have a block with. It is called
+// wherever a chirp body is stored, before it is. Mentions of unknown users are
+// ignored.
+func (cfg *apiConfig) checkMentions(ctx context.Context, authorID uuid.UUID, body string) error {
+	usernames := mentionedUsernames(body)
+	if len(usernames) == 0 {
+		return nil
+	}
+
+	usersDb, err := cfg.dbQueries.GetUsersByUsernames(ctx, usernames)
+	if err != nil {
+		return err
+	}
+
+	for _, userDb := range usersDb {
+		err = cfg.checkInteraction(ctx, authorID, userDb.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hiddenFrom reports whether the viewer should not hear from the author:
+// either of them blocked the other or, with includeMuted, the viewer muted the
+// author. It is used for things pushed to the viewer, like notifications.
+func (cfg *apiConfig) hiddenFrom(ctx context.Context, viewerID, authorID uuid.UUID, includeMuted bool) (bool, error) {
+	return cfg.dbQueries.IsHiddenFrom(ctx, database.IsHiddenFromParams{
+		ViewerID:     viewerID,
+		AuthorID:     authorID,
+		IncludeMuted: includeMuted,
+	})
+}
+
+// hiddenUserSet returns the users whose chirps the feed of the viewer leaves
+// out, for filtering events pushed to the viewer. Queries that read chirps
+// apply the same rule through the hidden_from SQL function instead.
+func (cfg *apiConfig) hiddenUserSet(ctx context.Context, viewerID uuid.UUID) (map[uuid.UUID]bool, error) {
+	hiddenIDs, err := cfg.dbQueries.GetHiddenUsers(ctx,
+		database.GetHiddenUsersParams{ViewerID: viewerID, IncludeMuted: true})
+	if err != nil {
+		return nil, err
+	}
+
+	hidden := map[uuid.UUID]bool{}
+	for _, hiddenID := range hiddenIDs {
+		hidden[hiddenID] = true
+	}
+	return hidden, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestCheckMentions(t *testing.T) {
+	fake := newFakeDB()
+	cfg := fake.config()
+
+	authorID, blockerID, blockedID, otherID := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	users := map[string]uuid.UUID{"blocker": blockerID, "blocked": blockedID, "other": otherID}
+	fake.blocks[[2]uuid.UUID{blockerID, authorID}] = true
+	fake.blocks[[2]uuid.UUID{authorID, blockedID}] = true
+	fake.handle("GetUsersByUsernames", func(args []driver.Value) (*fakeRows, error) {
+		values := [][]driver.Value{}
+		for _, username := range fakeTextArray(args[0]) {
+			if userID, ok := users[username]; ok {
+				values = append(values, []driver.Value{userID.String(), username})
+			}
+		}
+		return fakeResult(values...), nil
+	})
+
+	cases := []struct {
+		name string
+		body string
+		want error
+	}{
+		{name: "no mentions", body: "hello"},
+		{name: "mention", body: "hello @other"},
+		{name: "unknown user", body: "hello @nobody"},
+		{name: "mention of a blocker", body: "hello @other and @blocker", want: errBlocked},
+		{name: "mention of a blocked user", body: "hello @blocked", want: errBlocked},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := cfg.checkMentions(context.Background(), authorID, c.body)
+			if !errors.Is(err, c.want) {
+				t.Errorf("Expected %v, got %v", c.want, err)
+			}
+		})
+	}
+}
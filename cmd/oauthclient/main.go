@@ -0,0 +1,118 @@
+// Command oauthclient is a local third-party client used to exercise the
+// Chirpy OAuth2 authorization code flow with PKCE end to end.
+//
+// Register a client with redirect_uri http://localhost:9999/callback first,
+// then run the client and open the printed URL in a browser.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/lighthoof/Chirpy/internal/oauth"
+)
+
+func main() {
+	server := flag.String("server", "http://localhost:8080", "Chirpy base URL")
+	clientID := flag.String("client-id", "", "registered client ID")
+	clientSecret := flag.String("client-secret", "", "client secret for confidential clients")
+	scope := flag.String("scope", "", "requested scope, defaults to everything the client is allowed")
+	port := flag.String("port", "9999", "port of the local redirect listener")
+	flag.Parse()
+
+	if *clientID == "" {
+		log.Fatalf("client-id is required")
+	}
+
+	redirectURI := "http://localhost:" + *port + "/callback"
+	verifier, err := oauth.MakeRandomString(32)
+	if err != nil {
+		log.Fatalf("Unable to create code verifier: %v", err)
+	}
+	state, err := oauth.MakeRandomString(16)
+	if err != nil {
+		log.Fatalf("Unable to create state: %v", err)
+	}
+
+	authorizeURL := *server + "/api/oauth/authorize?" + url.Values{
+		"response_type":         {"code"},
+		"client_id":             {*clientID},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {*scope},
+		"state":                 {state},
+		"code_challenge":        {oauth.MakeCodeChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}.Encode()
+	fmt.Printf("Open this URL in your browser:\n\n%s\n\n", authorizeURL)
+
+	codes := make(chan string, 1)
+	serveMux := http.NewServeMux()
+	serveMux.HandleFunc("GET /callback", func(w http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		if query.Get("state") != state {
+			http.Error(w, "state mismatch", http.StatusBadRequest)
+			return
+		}
+		if query.Get("error") != "" {
+			http.Error(w, query.Get("error"), http.StatusBadRequest)
+			log.Fatalf("Authorization failed: %s", query.Get("error"))
+		}
+		fmt.Fprintln(w, "Authorization complete, you can close this window.")
+		codes <- query.Get("code")
+	})
+	go func() {
+		log.Fatal(http.ListenAndServe("localhost:"+*port, serveMux))
+	}()
+
+	code := <-codes
+
+	token := map[string]interface{}{}
+	err = postForm(*server+"/api/oauth/token", *clientID, *clientSecret, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+	}, &token)
+	if err != nil {
+		log.Fatalf("Token exchange failed: %v", err)
+	}
+	fmt.Printf("Token response: %v\n", token)
+
+	introspection := map[string]interface{}{}
+	err = postForm(*server+"/api/oauth/introspect", *clientID, *clientSecret, url.Values{
+		"token": {fmt.Sprint(token["access_token"])},
+	}, &introspection)
+	if err != nil {
+		log.Fatalf("Introspection failed: %v", err)
+	}
+	fmt.Printf("Introspection: %v\n", introspection)
+}
+
+func postForm(target, clientID, clientSecret string, form url.Values, respBody *map[string]interface{}) error {
+	form.Set("client_id", clientID)
+	if clientSecret != "" {
+		form.Set("client_secret", clientSecret)
+	}
+
+	resp, err := http.Post(target, "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, data)
+	}
+
+	return json.Unmarshal(data, respBody)
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/lighthoof/Chirpy/internal/database"
+	"github.com/lighthoof/Chirpy/internal/oauth"
+)
+
+const (
+	maxCollectionNameLength = 50
+	defaultPageSize         = 20
+	maxPageSize             = 100
+)
+
+func (cfg *apiConfig) createCollectionHandler(w http.ResponseWriter, req *http.Request) {
+	reqBody := Collection{}
+
+	err := unmarshalType(req, &reqBody)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Malformed request body")
+		return
+	}
+
+	userID, ok := cfg.authenticate(w, req, oauth.ScopeProfile)
+	if !ok {
+		return
+	}
+
+	name, err := validateCollectionName(reqBody.Name)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	collectionDb, err := cfg.dbQueries.CreateCollection(req.Context(),
+		database.CreateCollectionParams{UserID: userID, Name: name})
+	if isUniqueViolation(err) {
+		respondWithError(w, http.StatusConflict, "Collection already exists")
+		return
+	} else if err != nil {
+		log.Printf("Unable to create collection: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, collectionFromDb(collectionDb, 0))
+}
+
+func (cfg *apiConfig) getCollectionsHandler(w http.ResponseWriter, req *http.Request) {
+	userID, ok := cfg.authenticate(w, req, oauth.ScopeProfile)
+	if !ok {
+		return
+	}
+
+	collectionsDb, err := cfg.dbQueries.GetCollections(req.Context(), userID)
+	if err != nil {
+		log.Printf("Unable to retrieve collections: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respBody := []Collection{}
+	for _, collectionDb := range collectionsDb {
+		respBody = append(respBody, Collection{
+			ID:            collectionDb.ID,
+			CreatedAt:     collectionDb.CreatedAt,
+			UpdatedAt:     collectionDb.UpdatedAt,
+			Name:          collectionDb.Name,
+			BookmarkCount: collectionDb.BookmarkCount,
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, respBody)
+}
+
+func (cfg *apiConfig) renameCollectionHandler(w http.ResponseWriter, req *http.Request) {
+	reqBody := Collection{}
+
+	err := unmarshalType(req, &reqBody)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Malformed request body")
+		return
+	}
+
+	userID, collectionID, ok := cfg.parseCollectionRequest(w, req)
+	if !ok {
+		return
+	}
+
+	name, err := validateCollectionName(reqBody.Name)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	collectionDb, err := cfg.dbQueries.RenameCollection(req.Context(),
+		database.RenameCollectionParams{ID: collectionID, UserID: userID, Name: name})
+	if err == sql.ErrNoRows {
+		log.Printf("Collection not found")
+		respondWithError(w, http.StatusNotFound, "")
+		return
+	} else if isUniqueViolation(err) {
+		respondWithError(w, http.StatusConflict, "Collection already exists")
+		return
+	} else if err != nil {
+		log.Printf("Unable to rename collection: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	bookmarkCount, err := cfg.dbQueries.CountBookmarks(req.Context(), collectionDb.ID)
+	if err != nil {
+		log.Printf("Unable to count bookmarks: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, collectionFromDb(collectionDb, bookmarkCount))
+}
+
+func (cfg *apiConfig) deleteCollectionHandler(w http.ResponseWriter, req *http.Request) {
+	userID, collectionID, ok := cfg.parseCollectionRequest(w, req)
+	if !ok {
+		return
+	}
+
+	deleted, err := cfg.dbQueries.DeleteCollection(req.Context(),
+		database.DeleteCollectionParams{ID: collectionID, UserID: userID})
+	if err != nil {
+		log.Printf("Unable to delete collection: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+	if deleted == 0 {
+		log.Printf("Collection not found")
+		respondWithError(w, http.StatusNotFound, "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
+
+// getBookmarksHandler lists the chirps of a collection, most recently
+// bookmarked first, a page at a time with the limit and offset query
+// parameters.
+func (cfg *apiConfig) getBookmarksHandler(w http.ResponseWriter, req *http.Request) {
+	collectionDb, ok := cfg.getOwnCollection(w, req)
+	if !ok {
+		return
+	}
+
+	limit, offset, err := parsePagination(req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	chirpsDb, err := cfg.dbQueries.GetBookmarkedChirps(req.Context(), database.GetBookmarkedChirpsParams{
+		CollectionID: collectionDb.ID,
+		ViewerID:     collectionDb.UserID,
+		Limit:        limit,
+		Offset:       offset,
+	})
+	if err != nil {
+		log.Printf("Unable to retrieve bookmarks: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	viewer := uuid.NullUUID{UUID: collectionDb.UserID, Valid: true}
+	respBody := []Chirp{}
+	for _, chirpDb := range chirpsDb {
+		respBody = append(respBody, chirpFromDb(chirpDb))
+	}
+
+	chirps := []*Chirp{}
+	for i := range respBody {
+		chirps = append(chirps, &respBody[i])
+	}
+	err = cfg.loadChirpMedia(req.Context(), cfg.dbQueries, chirps)
+	if err != nil {
+		log.Printf("Unable to retrieve chirp media: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+	err = cfg.loadChirpPolls(req.Context(), cfg.dbQueries, chirps, viewer)
+	if err != nil {
+		log.Printf("Unable to retrieve chirp polls: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, respBody)
+}
+
+func (cfg *apiConfig) addBookmarkHandler(w http.ResponseWriter, req *http.Request) {
+	reqBody := Bookmark{}
+
+	err := unmarshalType(req, &reqBody)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Malformed request body")
+		return
+	}
+
+	collectionDb, ok := cfg.getOwnCollection(w, req)
+	if !ok {
+		return
+	}
+
+	chirpDb, err := cfg.dbQueries.GetChirpById(req.Context(), database.GetChirpByIdParams{
+		ID:       reqBody.ChirpID,
+		ViewerID: uuid.NullUUID{UUID: collectionDb.UserID, Valid: true},
+	})
+	if err == sql.ErrNoRows || (err == nil && !chirpDb.PublishedAt.Valid) {
+		log.Printf("Chirp not found")
+		respondWithError(w, http.StatusNotFound, "")
+		return
+	} else if err != nil {
+		log.Printf("Unable to retrieve chirp: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	err = cfg.dbQueries.AddBookmark(req.Context(),
+		database.AddBookmarkParams{CollectionID: collectionDb.ID, ChirpID: chirpDb.ID})
+	if err != nil {
+		log.Printf("Unable to add bookmark: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
+
+func (cfg *apiConfig) removeBookmarkHandler(w http.ResponseWriter, req *http.Request) {
+	collectionDb, ok := cfg.getOwnCollection(w, req)
+	if !ok {
+		return
+	}
+
+	chirpID, err := uuid.Parse(req.PathValue("chirpID"))
+	if err != nil {
+		log.Printf("Unable to parse chirpID: %s", req.PathValue("chirpID"))
+		respondWithError(w, http.StatusBadRequest, "")
+		return
+	}
+
+	removed, err := cfg.dbQueries.RemoveBookmark(req.Context(),
+		database.RemoveBookmarkParams{CollectionID: collectionDb.ID, ChirpID: chirpID})
+	if err != nil {
+		log.Printf("Unable to remove bookmark: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+	if removed == 0 {
+		log.Printf("Bookmark not found")
+		respondWithError(w, http.StatusNotFound, "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
+
+func (cfg *apiConfig) parseCollectionRequest(w http.ResponseWriter, req *http.Request) (uuid.UUID, uuid.UUID, bool) {
+	userID, ok := cfg.authenticate(w, req, oauth.ScopeProfile)
+	if !ok {
+		return uuid.UUID{}, uuid.UUID{}, false
+	}
+
+	collectionID, err := uuid.Parse(req.PathValue("collectionID"))
+	if err != nil {
+		log.Printf("Unable to parse collectionID: %s", req.PathValue("collectionID"))
+		respondWithError(w, http.StatusBadRequest, "")
+		return uuid.UUID{}, uuid.UUID{}, false
+	}
+
+	return userID, collectionID, true
+}
+
+// getOwnCollection loads the collection of the request path. Collections of
+// other users are reported as not found, as they are private.
+func (cfg *apiConfig) getOwnCollection(w http.ResponseWriter, req *http.Request) (database.Collection, bool) {
+	userID, collectionID, ok := cfg.parseCollectionRequest(w, req)
+	if !ok {
+		return database.Collection{}, false
+	}
+
+	collectionDb, err := cfg.dbQueries.GetCollection(req.Context(),
+		database.GetCollectionParams{ID: collectionID, UserID: userID})
+	if err == sql.ErrNoRows {
+		log.Printf("Collection not found")
+		respondWithError(w, http.StatusNotFound, "")
+		return database.Collection{}, false
+	} else if err != nil {
+		log.Printf("Unable to retrieve collection: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return database.Collection{}, false
+	}
+
+	return collectionDb, true
+}
+
+func validateCollectionName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("Collection name cannot be empty")
+	}
+	if len([]rune(name)) > maxCollectionNameLength {
+		return "", fmt.Errorf("Collection name is too long")
+	}
+	return name, nil
+}
+
+// parsePagination reads the limit and offset query parameters.
+func parsePagination(req *http.Request) (int32, int32, error) {
+	limit, offset := defaultPageSize, 0
+
+	if value := req.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxPageSize {
+			return 0, 0, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+		limit = parsed
+	}
+
+	if value := req.URL.Query().Get("offset"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			return 0, 0, fmt.Errorf("offset must be a non-negative number")
+		}
+		offset = parsed
+	}
+
+	return int32(limit), int32(offset), nil
+}
+
+func collectionFromDb(collectionDb database.Collection, bookmarkCount int64) Collection {
+	return Collection{
+		ID:            collectionDb.ID,
+		CreatedAt:     collectionDb.CreatedAt,
+		UpdatedAt:     collectionDb.UpdatedAt,
+		Name:          collectionDb.Name,
+		BookmarkCount: bookmarkCount,
+	}
+}
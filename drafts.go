@@ -0,0 +1,252 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/lighthoof/Chirpy/internal/database"
+	"github.com/lighthoof/Chirpy/internal/entitlements"
+	"github.com/lighthoof/Chirpy/internal/oauth"
+)
+
+// Drafts may grow past the chirp limit of the user's plan while being edited;
+// that limit is enforced once they are published.
+const maxDraftLength = entitlements.MaxChirpLength
+
+func (cfg *apiConfig) createDraftHandler(w http.ResponseWriter, req *http.Request) {
+	reqBody := Draft{}
+
+	err := unmarshalType(req, &reqBody)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Malformed request body")
+		return
+	}
+
+	userID, ok := cfg.authenticate(w, req, oauth.ScopeChirpsWrite)
+	if !ok {
+		return
+	}
+
+	if len(reqBody.Body) > maxDraftLength {
+		respondWithError(w, http.StatusBadRequest, "Draft is too long")
+		return
+	}
+
+	draftDb, err := cfg.dbQueries.CreateDraft(req.Context(),
+		database.CreateDraftParams{UserID: userID, Body: reqBody.Body})
+	if err != nil {
+		log.Printf("Unable to create draft: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, draftFromDb(draftDb))
+}
+
+func (cfg *apiConfig) getDraftsHandler(w http.ResponseWriter, req *http.Request) {
+	userID, ok := cfg.authenticate(w, req, oauth.ScopeChirpsWrite)
+	if !ok {
+		return
+	}
+
+	draftsDb, err := cfg.dbQueries.GetDrafts(req.Context(), userID)
+	if err != nil {
+		log.Printf("Unable to retrieve drafts: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respBody := []Draft{}
+	for _, draftDb := range draftsDb {
+		respBody = append(respBody, draftFromDb(draftDb))
+	}
+
+	respondWithJSON(w, http.StatusOK, respBody)
+}
+
+func (cfg *apiConfig) getDraftHandler(w http.ResponseWriter, req *http.Request) {
+	userID, draftID, ok := cfg.parseDraftRequest(w, req)
+	if !ok {
+		return
+	}
+
+	draftDb, err := cfg.dbQueries.GetDraft(req.Context(),
+		database.GetDraftParams{ID: draftID, UserID: userID})
+	if err == sql.ErrNoRows {
+		log.Printf("Draft not found")
+		respondWithError(w, http.StatusNotFound, "")
+		return
+	} else if err != nil {
+		log.Printf("Unable to retrieve draft: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, draftFromDb(draftDb))
+}
+
+func (cfg *apiConfig) updateDraftHandler(w http.ResponseWriter, req *http.Request) {
+	reqBody := Draft{}
+
+	err := unmarshalType(req, &reqBody)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Malformed request body")
+		return
+	}
+
+	userID, draftID, ok := cfg.parseDraftRequest(w, req)
+	if !ok {
+		return
+	}
+
+	if len(reqBody.Body) > maxDraftLength {
+		respondWithError(w, http.StatusBadRequest, "Draft is too long")
+		return
+	}
+
+	draftDb, err := cfg.dbQueries.UpdateDraft(req.Context(),
+		database.UpdateDraftParams{ID: draftID, UserID: userID, Body: reqBody.Body})
+	if err == sql.ErrNoRows {
+		log.Printf("Draft not found")
+		respondWithError(w, http.StatusNotFound, "")
+		return
+	} else if err != nil {
+		log.Printf("Unable to update draft: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, draftFromDb(draftDb))
+}
+
+func (cfg *apiConfig) deleteDraftHandler(w http.ResponseWriter, req *http.Request) {
+	userID, draftID, ok := cfg.parseDraftRequest(w, req)
+	if !ok {
+		return
+	}
+
+	deleted, err := cfg.dbQueries.DeleteDraft(req.Context(),
+		database.DeleteDraftParams{ID: draftID, UserID: userID})
+	if err != nil {
+		log.Printf("Unable to delete draft: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+	if deleted == 0 {
+		log.Printf("Draft not found")
+		respondWithError(w, http.StatusNotFound, "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
+
+// publishDraftHandler turns the draft into a chirp. The draft is locked, the
+// chirp is created through the same path as createChirpHandler and the draft
+// is removed in one transaction, so a draft is never published twice.
+func (cfg *apiConfig) publishDraftHandler(w http.ResponseWriter, req *http.Request) {
+	userID, draftID, ok := cfg.parseDraftRequest(w, req)
+	if !ok {
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(req.Context(), nil)
+	if err != nil {
+		log.Printf("Unable to begin transaction: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	draftDb, err := qtx.LockDraft(req.Context(),
+		database.LockDraftParams{ID: draftID, UserID: userID})
+	if err == sql.ErrNoRows {
+		log.Printf("Draft not found")
+		respondWithError(w, http.StatusNotFound, "")
+		return
+	} else if err != nil {
+		log.Printf("Unable to retrieve draft: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	ent, err := cfg.userEntitlements(req.Context(), userID)
+	if err != nil {
+		log.Printf("Unable to retrieve entitlements: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	chirp := Chirp{Body: draftDb.Body, UserID: userID}
+	err = validateChirp(chirp, ent)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err = checkChirpRate(req.Context(), qtx, userID, ent)
+	if errors.Is(err, errRateLimited) {
+		respondWithError(w, http.StatusTooManyRequests, err.Error())
+		return
+	} else if err != nil {
+		log.Printf("Unable to check chirp rate: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respBody, err := cfg.createChirp(req.Context(), qtx, chirp)
+	if errors.Is(err, errBlocked) {
+		respondWithError(w, http.StatusForbidden, "Unable to mention one of these users")
+		return
+	} else if err != nil {
+		log.Printf("Unable to create chirp: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	_, err = qtx.DeleteDraft(req.Context(),
+		database.DeleteDraftParams{ID: draftID, UserID: userID})
+	if err != nil {
+		log.Printf("Unable to delete draft: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("Unable to commit transaction: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, respBody)
+}
+
+func (cfg *apiConfig) parseDraftRequest(w http.ResponseWriter, req *http.Request) (uuid.UUID, uuid.UUID, bool) {
+	userID, ok := cfg.authenticate(w, req, oauth.ScopeChirpsWrite)
+	if !ok {
+		return uuid.UUID{}, uuid.UUID{}, false
+	}
+
+	draftID, err := uuid.Parse(req.PathValue("draftID"))
+	if err != nil {
+		log.Printf("Unable to parse draftID: %s", req.PathValue("draftID"))
+		respondWithError(w, http.StatusBadRequest, "")
+		return uuid.UUID{}, uuid.UUID{}, false
+	}
+
+	return userID, draftID, true
+}
+
+func draftFromDb(draftDb database.Draft) Draft {
+	return Draft{
+		ID:        draftDb.ID,
+		CreatedAt: draftDb.CreatedAt,
+		UpdatedAt: draftDb.UpdatedAt,
+		Body:      draftDb.Body,
+	}
+}
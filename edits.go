@@ -0,0 +1,146 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/lighthoof/Chirpy/internal/database"
+	"github.com/lighthoof/Chirpy/internal/oauth"
+)
+
+// editChirpHandler replaces the body of one of the user's chirps. Editing is
+// limited to plans with an edit window, and only within that window after the
+// chirp was published; scheduled chirps can be edited until they go out.
+// Media and polls cannot be changed.
+func (cfg *apiConfig) editChirpHandler(w http.ResponseWriter, req *http.Request) {
+	reqBody := Chirp{}
+
+	err := unmarshalType(req, &reqBody)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Malformed request body")
+		return
+	}
+
+	userID, ok := cfg.authenticate(w, req, oauth.ScopeChirpsWrite)
+	if !ok {
+		return
+	}
+
+	chirpID, err := uuid.Parse(req.PathValue("chirpID"))
+	if err != nil {
+		log.Printf("Unable to parse chirpID: %s", req.PathValue("chirpID"))
+		respondWithError(w, http.StatusBadRequest, "")
+		return
+	}
+
+	ent, err := cfg.userEntitlements(req.Context(), userID)
+	if err != nil {
+		log.Printf("Unable to retrieve entitlements: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+	if ent.EditWindow <= 0 {
+		respondWithError(w, http.StatusForbidden, "Editing chirps requires Chirpy Red")
+		return
+	}
+
+	if strings.TrimSpace(reqBody.Body) == "" {
+		respondWithError(w, http.StatusBadRequest, "Chirp cannot be empty")
+		return
+	}
+	if len(reqBody.Body) > ent.MaxChirpLength {
+		respondWithError(w, http.StatusBadRequest, "Chirp is too long")
+		return
+	}
+
+	chirpDb, err := cfg.dbQueries.GetChirpById(req.Context(),
+		database.GetChirpByIdParams{ID: chirpID, ViewerID: uuid.NullUUID{UUID: userID, Valid: true}})
+	if err == sql.ErrNoRows {
+		log.Printf("Chirp not found")
+		respondWithError(w, http.StatusNotFound, "")
+		return
+	} else if err != nil {
+		log.Printf("Unable to retrieve chirp: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	if chirpDb.UserID != userID {
+		log.Printf("Unable to validate user")
+		respondWithError(w, http.StatusForbidden, "")
+		return
+	}
+
+	body := wordFilter(reqBody.Body)
+	err = cfg.checkMentions(req.Context(), userID, body)
+	if errors.Is(err, errBlocked) {
+		respondWithError(w, http.StatusForbidden, "Unable to mention one of these users")
+		return
+	} else if err != nil {
+		log.Printf("Unable to check mentions: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(req.Context(), nil)
+	if err != nil {
+		log.Printf("Unable to begin transaction: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	chirpDb, err = qtx.EditChirp(req.Context(), database.EditChirpParams{
+		Body:          body,
+		ID:            chirpID,
+		WindowSeconds: int32(ent.EditWindow.Seconds()),
+	})
+	if err == sql.ErrNoRows {
+		respondWithError(w, http.StatusForbidden, "Edit window has passed")
+		return
+	} else if err != nil {
+		log.Printf("Unable to edit chirp: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respBody := chirpFromDb(chirpDb)
+
+	err = cfg.loadChirpMedia(req.Context(), qtx, []*Chirp{&respBody})
+	if err != nil {
+		log.Printf("Unable to retrieve chirp media: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+	err = cfg.loadChirpPolls(req.Context(), qtx, []*Chirp{&respBody}, uuid.NullUUID{UUID: userID, Valid: true})
+	if err != nil {
+		log.Printf("Unable to retrieve chirp polls: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	// Scheduled chirps have not been announced yet; chirp.created will carry
+	// the edited body.
+	if chirpDb.PublishedAt.Valid {
+		err = recordEvent(req.Context(), qtx, eventChirpUpdated, chirpDb.UserID, respBody)
+		if err != nil {
+			log.Printf("Unable to record event: %s %s [%s]", req.Method, req.URL.Path, err)
+			respondWithError(w, http.StatusInternalServerError, "")
+			return
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("Unable to commit transaction: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, respBody)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lighthoof/Chirpy/internal/database"
+	"github.com/lighthoof/Chirpy/internal/entitlements"
+	"github.com/lighthoof/Chirpy/internal/oauth"
+)
+
+const chirpRateWindow = time.Hour
+
+var errRateLimited = errors.New("Too many chirps, try again later")
+
+// userEntitlements returns the entitlements of the user's active plan. Users
+// without an active subscription are on the free plan.
+func (cfg *apiConfig) userEntitlements(ctx context.Context, userID uuid.UUID) (entitlements.Entitlements, error) {
+	plan, err := cfg.dbQueries.GetActivePlan(ctx, userID)
+	if err == sql.ErrNoRows {
+		return entitlements.ForPlan(entitlements.PlanFree), nil
+	} else if err != nil {
+		return entitlements.Entitlements{}, err
+	}
+	return entitlements.ForPlan(plan), nil
+}
+
+// checkChirpRate returns errRateLimited once the user has created as many
+// chirps in the last hour as their plan allows. Deleted chirps still count.
+// It must run in the transaction that creates the chirp: it holds a lock per
+// user until the transaction ends, so concurrent requests cannot all pass the
+// check before any of them has inserted.
+func checkChirpRate(ctx context.Context, queries *database.Queries, userID uuid.UUID, ent entitlements.Entitlements) error {
+	err := queries.LockChirpRate(ctx, userID.String())
+	if err != nil {
+		return err
+	}
+
+	count, err := queries.CountRecentChirps(ctx, database.CountRecentChirpsParams{
+		UserID:        userID,
+		WindowSeconds: int32(chirpRateWindow.Seconds()),
+	})
+	if err != nil {
+		return err
+	}
+	if count >= int64(ent.ChirpsPerHour) {
+		return errRateLimited
+	}
+	return nil
+}
+
+func (cfg *apiConfig) getEntitlementsHandler(w http.ResponseWriter, req *http.Request) {
+	userID, ok := cfg.authenticate(w, req, oauth.ScopeProfile)
+	if !ok {
+		return
+	}
+
+	ent, err := cfg.userEntitlements(req.Context(), userID)
+	if err != nil {
+		log.Printf("Unable to retrieve entitlements: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Entitlements{
+		Plan:              ent.Plan,
+		MaxChirpLength:    ent.MaxChirpLength,
+		MaxChirpMedia:     ent.MaxChirpMedia,
+		ChirpsPerHour:     ent.ChirpsPerHour,
+		ScheduledChirps:   ent.ScheduledChirps,
+		PinChirps:         ent.PinChirps,
+		EditWindowSeconds: int(ent.EditWindow.Seconds()),
+	})
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/lighthoof/Chirpy/internal/entitlements"
+)
+
+func TestCheckChirpRate(t *testing.T) {
+	ent := entitlements.ForPlan(entitlements.PlanFree)
+
+	cases := []struct {
+		name  string
+		count int
+		want  error
+	}{
+		{name: "below the limit", count: ent.ChirpsPerHour - 1},
+		{name: "at the limit", count: ent.ChirpsPerHour, want: errRateLimited},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fake := newFakeDB()
+			cfg := fake.config()
+			queries := []string{}
+			fake.handle("LockChirpRate", func(args []driver.Value) (*fakeRows, error) {
+				queries = append(queries, "LockChirpRate")
+				return nil, nil
+			})
+			fake.handle("CountRecentChirps", func(args []driver.Value) (*fakeRows, error) {
+				queries = append(queries, "CountRecentChirps")
+				return fakeResult([]driver.Value{int64(c.count)}), nil
+			})
+
+			err := checkChirpRate(context.Background(), cfg.dbQueries, uuid.New(), ent)
+			if !errors.Is(err, c.want) {
+				t.Errorf("Expected %v, got %v", c.want, err)
+			}
+			if len(queries) != 2 || queries[0] != "LockChirpRate" {
+				t.Errorf("Expected the lock before the count, got %v", queries)
+			}
+		})
+	}
+}
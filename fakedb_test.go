@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lighthoof/Chirpy/internal/database"
+)
+
+// fakeDB is a database/sql driver for tests of code that needs a database,
+// transactions included, without Postgres. Queries are answered by the
+// handlers a test registers under their sqlc name, so each test only fakes
+// the queries it runs; any other query fails. Outbox writes and the block
+// checks are handled for every test: events records the written event types,
+// and blocks and mutes hold {blocker, blocked} and {muter, muted} pairs.
+type fakeDB struct {
+	queries map[string]fakeQuery
+	events  []string
+	blocks  map[[2]uuid.UUID]bool
+	mutes   map[[2]uuid.UUID]bool
+}
+
+// fakeQuery answers a query. The rows also give the affected row count of
+// :exec and :execrows queries.
+type fakeQuery func(args []driver.Value) (*fakeRows, error)
+
+func newFakeDB() *fakeDB {
+	f := &fakeDB{
+		queries: map[string]fakeQuery{},
+		blocks:  map[[2]uuid.UUID]bool{},
+		mutes:   map[[2]uuid.UUID]bool{},
+	}
+	f.handle("WriteOutboxEvent", func(args []driver.Value) (*fakeRows, error) {
+		f.events = append(f.events, args[0].(string))
+		now := time.Now()
+		return fakeResult([]driver.Value{
+			uuid.NewString(), int64(len(f.events)), now, args[0], args[1], args[2], int64(0), "", now, nil, "pending", nil,
+		}), nil
+	})
+	f.handle("IsBlockedBetween", func(args []driver.Value) (*fakeRows, error) {
+		return fakeExists(f.blockedBetween(fakeUUID(args[0]), fakeUUID(args[1]))), nil
+	})
+	f.handle("IsHiddenFrom", func(args []driver.Value) (*fakeRows, error) {
+		viewerID, authorID := fakeUUID(args[0]), fakeUUID(args[1])
+		hidden := f.blockedBetween(viewerID, authorID) || (args[2].(bool) && f.mutes[[2]uuid.UUID{viewerID, authorID}])
+		return fakeResult([]driver.Value{hidden}), nil
+	})
+	f.handle("HasBlocksAmong", func(args []driver.Value) (*fakeRows, error) {
+		userIDs := fakeUUIDArray(args[0])
+		blocked := false
+		for _, blockerID := range userIDs {
+			for _, blockedID := range userIDs {
+				blocked = blocked || f.blocks[[2]uuid.UUID{blockerID, blockedID}]
+			}
+		}
+		return fakeExists(blocked), nil
+	})
+	return f
+}
+
+func (f *fakeDB) handle(name string, query fakeQuery) {
+	f.queries[name] = query
+}
+
+func (f *fakeDB) blockedBetween(userID, otherID uuid.UUID) bool {
+	return f.blocks[[2]uuid.UUID{userID, otherID}] || f.blocks[[2]uuid.UUID{otherID, userID}]
+}
+
+// config opens the fake as the database of a new apiConfig.
+func (f *fakeDB) config() *apiConfig {
+	db := sql.OpenDB(f)
+	return &apiConfig{db: db, dbQueries: database.New(db), secret: "secret"}
+}
+
+func (f *fakeDB) Open(name string) (driver.Conn, error) { return f, nil }
+
+func (f *fakeDB) Connect(ctx context.Context) (driver.Conn, error) { return f, nil }
+
+func (f *fakeDB) Driver() driver.Driver { return f }
+
+func (f *fakeDB) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("prepared statements are not supported")
+}
+
+func (f *fakeDB) Close() error { return nil }
+
+func (f *fakeDB) Begin() (driver.Tx, error) { return fakeTx{}, nil }
+
+func (f *fakeDB) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return f.run(query, args)
+}
+
+func (f *fakeDB) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	rows, err := f.run(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(len(rows.values)), nil
+}
+
+func (f *fakeDB) run(query string, args []driver.NamedValue) (*fakeRows, error) {
+	name := strings.Fields(strings.TrimPrefix(query, "-- name: "))[0]
+	handler, ok := f.queries[name]
+	if !ok {
+		return nil, errors.New("unexpected query " + name)
+	}
+
+	values := []driver.Value{}
+	for _, arg := range args {
+		values = append(values, arg.Value)
+	}
+	rows, err := handler(values)
+	if rows == nil {
+		rows = fakeResult()
+	}
+	return rows, err
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+// fakeResult returns the rows in the column order of the query. Column names
+// do not matter, as sqlc scans by position.
+func fakeResult(values ...[]driver.Value) *fakeRows {
+	rows := &fakeRows{values: values}
+	if len(values) > 0 {
+		for i := range values[0] {
+			rows.columns = append(rows.columns, fmt.Sprint("column", i))
+		}
+	}
+	return rows
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+// fakeUUID reads a uuid argument; NULL gives the zero UUID.
+func fakeUUID(value driver.Value) uuid.UUID {
+	if value == nil {
+		return uuid.UUID{}
+	}
+	return uuid.MustParse(value.(string))
+}
+
+// fakeUUIDArray reads a uuid[] argument as sent by pq.Array.
+func fakeUUIDArray(value driver.Value) []uuid.UUID {
+	ids := []uuid.UUID{}
+	for _, id := range strings.Split(strings.Trim(value.(string), "{}"), ",") {
+		if id != "" {
+			ids = append(ids, uuid.MustParse(strings.Trim(id, `"`)))
+		}
+	}
+	return ids
+}
+
+// fakeTextArray reads a text[] argument as sent by pq.Array.
+func fakeTextArray(value driver.Value) []string {
+	texts := []string{}
+	for _, text := range strings.Split(strings.Trim(value.(string), "{}"), ",") {
+		if text != "" {
+			texts = append(texts, strings.Trim(text, `"`))
+		}
+	}
+	return texts
+}
+
+// fakeExists is the result of a SELECT EXISTS (...) query.
+func fakeExists(exists bool) *fakeRows {
+	return fakeResult([]driver.Value{exists})
+}
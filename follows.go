@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/lighthoof/Chirpy/internal/auth"
+	"github.com/lighthoof/Chirpy/internal/database"
+	"github.com/lighthoof/Chirpy/internal/oauth"
+)
+
+func (cfg *apiConfig) followHandler(w http.ResponseWriter, req *http.Request) {
+	followerID, followeeID, ok := cfg.parseRelationRequest(w, req)
+	if !ok {
+		return
+	}
+
+	if followerID == followeeID {
+		respondWithError(w, http.StatusBadRequest, "Unable to follow yourself")
+		return
+	}
+
+	err := cfg.checkInteraction(req.Context(), followerID, followeeID)
+	if errors.Is(err, errBlocked) {
+		respondWithError(w, http.StatusForbidden, "Unable to follow this user")
+		return
+	} else if err != nil {
+		log.Printf("Unable to check blocks: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(req.Context(), nil)
+	if err != nil {
+		log.Printf("Unable to begin transaction: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	followed, err := qtx.FollowUser(req.Context(),
+		database.FollowUserParams{FollowerID: followerID, FolloweeID: followeeID})
+	if isForeignKeyViolation(err) {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	} else if err != nil {
+		log.Printf("Unable to follow user: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	// Following again is a no-op and does not notify twice.
+	if followed > 0 {
+		err = recordEvent(req.Context(), qtx, eventUserFollowed, followeeID,
+			Follow{FollowerID: followerID, FolloweeID: followeeID})
+		if err != nil {
+			log.Printf("Unable to record event: %s %s [%s]", req.Method, req.URL.Path, err)
+			respondWithError(w, http.StatusInternalServerError, "")
+			return
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("Unable to commit transaction: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
+
+func (cfg *apiConfig) unfollowHandler(w http.ResponseWriter, req *http.Request) {
+	followerID, followeeID, ok := cfg.parseRelationRequest(w, req)
+	if !ok {
+		return
+	}
+
+	err := cfg.dbQueries.UnfollowUser(req.Context(),
+		database.UnfollowUserParams{FollowerID: followerID, FolloweeID: followeeID})
+	if err != nil {
+		log.Printf("Unable to unfollow user: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
+
+// parseRelationRequest returns the authenticated user and the user of the
+// request path for follow, block and mute requests.
+func (cfg *apiConfig) parseRelationRequest(w http.ResponseWriter, req *http.Request) (uuid.UUID, uuid.UUID, bool) {
+	stringToken, err := auth.GetBearerToken(req.Header)
+	if err != nil {
+		log.Printf("Unable to get the token from request header: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusUnauthorized, "")
+		return uuid.UUID{}, uuid.UUID{}, false
+	}
+
+	actorID, err := auth.ValidateJWTScope(stringToken, cfg.secret, oauth.ScopeProfile)
+	if err != nil {
+		log.Printf("Unable to validate the token: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusUnauthorized, "")
+		return uuid.UUID{}, uuid.UUID{}, false
+	}
+
+	targetID, err := uuid.Parse(req.PathValue("userID"))
+	if err != nil {
+		log.Printf("Unable to parse userID: %s", req.PathValue("userID"))
+		respondWithError(w, http.StatusBadRequest, "")
+		return uuid.UUID{}, uuid.UUID{}, false
+	}
+
+	return actorID, targetID, true
+}
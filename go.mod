@@ -10,3 +10,5 @@ require (
 	github.com/joho/godotenv v1.5.1
 	github.com/lib/pq v1.10.9
 )
+
+require golang.org/x/sys v0.33.0 // indirect
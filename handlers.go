@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
+	"crypto/subtle"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"sort"
 	"strings"
@@ -13,15 +17,46 @@ import (
 	"github.com/google/uuid"
 	"github.com/lighthoof/Chirpy/internal/auth"
 	"github.com/lighthoof/Chirpy/internal/database"
+	"github.com/lighthoof/Chirpy/internal/entitlements"
+	"github.com/lighthoof/Chirpy/internal/events"
+	"github.com/lighthoof/Chirpy/internal/mailer"
+	"github.com/lighthoof/Chirpy/internal/media"
+	"github.com/lighthoof/Chirpy/internal/oauth"
+	"github.com/lighthoof/Chirpy/internal/oidc"
+	"github.com/lighthoof/Chirpy/internal/stream"
+	"github.com/lighthoof/Chirpy/internal/webhooks"
 )
 
+// Webhook payloads larger than this are rejected before verification.
+const maxWebhookSize = 1 << 20
+
 type apiConfig struct {
 	fileserverHits atomic.Int32
+	db             *sql.DB
 	dbQueries      *database.Queries
 	platform       string
 	secret         string
 	authExpiry     time.Duration
 	polkaAPIKey    string
+	polkaVerifier  auth.WebhookVerifier
+	polkaAllowKey  bool
+	adminAPIKey    string
+	oidcProvider   *oidc.Provider
+	publicURL      string
+	mailer         mailer.Mailer
+	hasher         auth.PasswordHasher
+	passwordPolicy auth.PasswordPolicy
+
+	deletionGracePeriod time.Duration
+	exportDir           string
+	blobStore           media.BlobStore
+	chirpRestoreWindow  time.Duration
+	chirpRetention      time.Duration
+	webhookSender       webhooks.Sender
+	adminWebhookSender  webhooks.Sender
+	events              *events.Bus
+	streamHub           *stream.Hub
+	sockets             *socketRegistry
 }
 
 func (cfg *apiConfig) counterHandler(w http.ResponseWriter, req *http.Request) {
@@ -60,9 +95,17 @@ func (cfg *apiConfig) createUserHandler(w http.ResponseWriter, req *http.Request
 		return
 	}
 
-	reqBody.Password, err = auth.HashPassword(reqBody.Password)
+	err = cfg.passwordPolicy.Check(reqBody.Password)
+	if err != nil {
+		log.Printf("Password rejected by policy: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	reqBody.Password, err = cfg.hasher.Hash(reqBody.Password)
 	if err != nil {
 		log.Printf("Unable to hash the password: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "")
 		return
 	}
 
@@ -73,13 +116,7 @@ func (cfg *apiConfig) createUserHandler(w http.ResponseWriter, req *http.Request
 		return
 	}
 
-	user := User{
-		ID:          userDb.ID,
-		CreatedAt:   userDb.CreatedAt,
-		UpdatedAt:   userDb.UpdatedAt,
-		Email:       userDb.Email,
-		IsChirpyRed: userDb.IsChirpyRed,
-	}
+	user := userFromDb(userDb)
 
 	respondWithJSON(w, http.StatusCreated, user)
 }
@@ -96,7 +133,7 @@ func (cfg *apiConfig) updateUserHandler(w http.ResponseWriter, req *http.Request
 		return
 	}
 
-	UserID, err := auth.ValidateJWT(stringToken, cfg.secret)
+	UserID, err := auth.ValidateJWTScope(stringToken, cfg.secret, oauth.ScopeProfile)
 	if err != nil {
 		log.Printf("Unable to validate the token: %s %s [%s]", req.Method, req.URL.Path, err)
 		respondWithError(w, http.StatusUnauthorized, "")
@@ -108,9 +145,17 @@ func (cfg *apiConfig) updateUserHandler(w http.ResponseWriter, req *http.Request
 		return
 	}
 
-	reqBody.Password, err = auth.HashPassword(reqBody.Password)
+	err = cfg.passwordPolicy.Check(reqBody.Password)
+	if err != nil {
+		log.Printf("Password rejected by policy: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	reqBody.Password, err = cfg.hasher.Hash(reqBody.Password)
 	if err != nil {
 		log.Printf("Unable to hash the password: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "")
 		return
 	}
 
@@ -126,102 +171,132 @@ func (cfg *apiConfig) updateUserHandler(w http.ResponseWriter, req *http.Request
 		return
 	}
 
-	user := User{
-		ID:          userDb.ID,
-		CreatedAt:   userDb.CreatedAt,
-		UpdatedAt:   userDb.UpdatedAt,
-		Email:       userDb.Email,
-		IsChirpyRed: userDb.IsChirpyRed,
-	}
+	user := userFromDb(userDb)
 
 	respondWithJSON(w, http.StatusOK, user)
 }
 
-func (cfg *apiConfig) userUpgradeHandler(w http.ResponseWriter, req *http.Request) {
-	reqBody := Event{}
+func (cfg *apiConfig) loginHandler(w http.ResponseWriter, req *http.Request) {
+	reqBody := Auth{}
 
-	reqAPIKey, err := auth.GetAPIKey(req.Header)
+	_ = unmarshalType(req, &reqBody)
+
+	userDb, err := cfg.dbQueries.GetUserByEmail(req.Context(), reqBody.Email)
 	if err != nil {
-		log.Printf("Unable to parse Polka API Key: %s", req.Header["Authorization"])
-		respondWithError(w, http.StatusUnauthorized, "")
+		log.Printf("Unable to retrieve user with the e-mail: %s %s [%s]", req.Method, req.URL.Path, reqBody.Email)
 		return
 	}
-	if reqAPIKey != cfg.polkaAPIKey {
-		log.Printf("Inkorrect Polka API Key: %s", req.Header["Authorization"])
-		respondWithError(w, http.StatusUnauthorized, "")
+
+	err = auth.CheckPasswordHash(userDb.HashedPassword, reqBody.Password)
+	if err != nil {
+		log.Printf("Incorrect email or password: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password")
 		return
 	}
 
-	_ = unmarshalType(req, &reqBody)
-	if reqBody.Event != "user.upgraded" {
-		log.Printf("Unknown event: %s %s [%s]", req.Method, req.URL.Path, reqBody.Event)
-		respondWithJSON(w, http.StatusNoContent, "")
+	if cfg.hasher.NeedsRehash(userDb.HashedPassword) {
+		cfg.rehashPassword(req, userDb.ID, reqBody.Password)
 	}
 
-	userID, err := uuid.Parse(reqBody.Data.User_id)
+	user, err := cfg.makeSession(req, userDb)
 	if err != nil {
-		log.Printf("Unable to parse userID: %s", reqBody.Data.User_id)
-		return
-	}
-
-	userDb, err := cfg.dbQueries.UpgradeUser(req.Context(), userID)
-	if err == sql.ErrNoRows {
-		log.Printf("User not found")
-		respondWithError(w, http.StatusNotFound, "")
-		return
-	} else if err != nil {
-		log.Printf("Unable to upgrade user: %s", userDb.ID)
+		log.Printf("Unable to create session: %s %s [%s]", req.Method, req.URL.Path, err)
 		respondWithError(w, http.StatusInternalServerError, "")
 		return
 	}
 
-	respondWithJSON(w, http.StatusNoContent, "")
+	respondWithJSON(w, http.StatusOK, user)
 }
 
-func (cfg *apiConfig) loginHandler(w http.ResponseWriter, req *http.Request) {
-	reqBody := Auth{}
-
-	_ = unmarshalType(req, &reqBody)
-
-	userDb, err := cfg.dbQueries.GetUserByEmail(req.Context(), reqBody.Email)
+// rehashPassword upgrades a legacy or outdated hash while the plain password
+// is at hand. Failures are only logged, the login itself already succeeded.
+func (cfg *apiConfig) rehashPassword(req *http.Request, userID uuid.UUID, password string) {
+	hashedPassword, err := cfg.hasher.Hash(password)
 	if err != nil {
-		log.Printf("Unable to retrieve user with the e-mail: %s %s [%s]", req.Method, req.URL.Path, reqBody.Email)
+		log.Printf("Unable to rehash the password: %s", err)
 		return
 	}
 
-	err = auth.CheckPasswordHash(userDb.HashedPassword, reqBody.Password)
+	err = cfg.dbQueries.UpdateUserPassword(req.Context(),
+		database.UpdateUserPasswordParams{HashedPassword: hashedPassword, ID: userID})
 	if err != nil {
-		log.Printf("Incorrect email or password: %s %s [%s]", req.Method, req.URL.Path, err)
-		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password")
-		return
+		log.Printf("Unable to store rehashed password: %s %s [%s]", req.Method, req.URL.Path, err)
+	}
+}
+
+// makeSession issues the access/refresh token pair returned by every login
+// method and wraps it together with the user's data. Logging in by any method
+// cancels a pending account deletion.
+func (cfg *apiConfig) makeSession(req *http.Request, userDb database.User) (User, error) {
+	if userDb.DeletionRequestedAt.Valid {
+		err := cfg.dbQueries.CancelUserDeletion(req.Context(), userDb.ID)
+		if err != nil {
+			return User{}, fmt.Errorf("unable to cancel account deletion: %w", err)
+		}
+		log.Printf("Account deletion cancelled by login: %s", userDb.ID)
+		userDb.DeletionRequestedAt = sql.NullTime{}
 	}
 
 	token, err := auth.MakeJWT(userDb.ID, cfg.secret, cfg.authExpiry)
 	if err != nil {
-		log.Printf("Unable to create token for user: %s", userDb.ID)
-		return
+		return User{}, err
 	}
 
-	refreshToken, _ := auth.MakeRefreshToken()
+	refreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		return User{}, err
+	}
 	refreshTokenDb, err := cfg.dbQueries.StoreRefreshToken(req.Context(),
 		database.StoreRefreshTokenParams{Token: refreshToken, UserID: userDb.ID},
 	)
 	if err != nil {
-		log.Printf("Unable to store new refresh token %s %s [%s]", req.Method, req.URL.Path, err)
-		return
+		return User{}, err
 	}
 
-	user := User{
-		ID:          userDb.ID,
-		CreatedAt:   userDb.CreatedAt,
-		UpdatedAt:   userDb.UpdatedAt,
-		Email:       userDb.Email,
-		Token:       token,
-		Refresh:     refreshTokenDb.Token,
-		IsChirpyRed: userDb.IsChirpyRed,
+	user := userFromDb(userDb)
+	user.Token = token
+	user.Refresh = refreshTokenDb.Token
+
+	return user, nil
+}
+
+// authenticate checks the bearer token of the request for the given scope and
+// responds with 401 if it is missing or invalid.
+func (cfg *apiConfig) authenticate(w http.ResponseWriter, req *http.Request, scope string) (uuid.UUID, bool) {
+	stringToken, err := auth.GetBearerToken(req.Header)
+	if err != nil {
+		log.Printf("Unable to get the token from request header: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusUnauthorized, "")
+		return uuid.UUID{}, false
 	}
 
-	respondWithJSON(w, http.StatusOK, user)
+	userID, err := auth.ValidateJWTScope(stringToken, cfg.secret, scope)
+	if err != nil {
+		log.Printf("Unable to validate the token: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusUnauthorized, "")
+		return uuid.UUID{}, false
+	}
+
+	return userID, true
+}
+
+// authenticateAdmin checks the ADMIN_API_KEY sent as "Authorization: ApiKey".
+// Admin endpoints are disabled while no key is configured.
+func (cfg *apiConfig) authenticateAdmin(w http.ResponseWriter, req *http.Request) bool {
+	reqAPIKey, err := auth.GetAPIKey(req.Header)
+	if err != nil {
+		log.Printf("Unable to get the API key from request header: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusUnauthorized, "")
+		return false
+	}
+
+	if cfg.adminAPIKey == "" || subtle.ConstantTimeCompare([]byte(reqAPIKey), []byte(cfg.adminAPIKey)) != 1 {
+		log.Printf("Invalid admin API key: %s %s", req.Method, req.URL.Path)
+		respondWithError(w, http.StatusUnauthorized, "")
+		return false
+	}
+
+	return true
 }
 
 func (cfg *apiConfig) createChirpHandler(w http.ResponseWriter, req *http.Request) {
@@ -235,46 +310,198 @@ func (cfg *apiConfig) createChirpHandler(w http.ResponseWriter, req *http.Reques
 		return
 	}
 
-	reqBody.UserID, err = auth.ValidateJWT(stringToken, cfg.secret)
+	reqBody.UserID, err = auth.ValidateJWTScope(stringToken, cfg.secret, oauth.ScopeChirpsWrite)
 	if err != nil {
 		log.Printf("Unable to validate the token: %s %s [%s]", req.Method, req.URL.Path, err)
 		respondWithError(w, http.StatusUnauthorized, "")
 		return
 	}
 
-	if len(reqBody.Body) <= 140 {
-		reqBody.Body = wordFilter(reqBody.Body)
-		chirpDb, err := cfg.dbQueries.CreateChirp(req.Context(),
-			database.CreateChirpParams{Body: reqBody.Body, UserID: reqBody.UserID})
+	ent, err := cfg.userEntitlements(req.Context(), reqBody.UserID)
+	if err != nil {
+		log.Printf("Unable to retrieve entitlements: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	err = validateChirp(reqBody, ent)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(req.Context(), nil)
+	if err != nil {
+		log.Printf("Unable to begin transaction: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	err = checkChirpRate(req.Context(), qtx, reqBody.UserID, ent)
+	if errors.Is(err, errRateLimited) {
+		respondWithError(w, http.StatusTooManyRequests, err.Error())
+		return
+	} else if err != nil {
+		log.Printf("Unable to check chirp rate: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respBody, err := cfg.createChirp(req.Context(), qtx, reqBody)
+	if errors.Is(err, errMediaUnavailable) {
+		log.Printf("Unable to attach media: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	} else if errors.Is(err, errBlocked) {
+		respondWithError(w, http.StatusForbidden, "Unable to mention one of these users")
+		return
+	} else if err != nil {
+		log.Printf("Unable to create chirp: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("Unable to commit transaction: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, respBody)
+}
+
+// validateChirp checks a new chirp against the entitlements of its author
+// before it is stored. The error is meant for the client.
+func validateChirp(chirp Chirp, ent entitlements.Entitlements) error {
+	if len(chirp.Body) > ent.MaxChirpLength {
+		return fmt.Errorf("Chirp is too long")
+	}
+	if len(chirp.Media) > ent.MaxChirpMedia {
+		return fmt.Errorf("A chirp can have at most %d media", ent.MaxChirpMedia)
+	}
+	if chirp.PublishAt != nil && !ent.ScheduledChirps {
+		return fmt.Errorf("Scheduling chirps requires Chirpy Red")
+	}
+	if chirp.PublishAt != nil && !chirp.PublishAt.After(time.Now()) {
+		return fmt.Errorf("publish_at must be in the future")
+	}
+	if chirp.PublishAt != nil && time.Until(*chirp.PublishAt) > maxScheduleAhead {
+		return fmt.Errorf("publish_at cannot be more than a year ahead")
+	}
+	if chirp.Poll != nil {
+		err := validatePoll(chirp.Poll)
 		if err != nil {
-			log.Printf("Unable to create chirp: %s %s [%s]", req.Method, req.URL.Path, err)
-			respondWithError(w, http.StatusBadRequest, err.Error())
-			return
+			return err
 		}
+		if chirp.PublishAt != nil && !chirp.Poll.ClosesAt.After(*chirp.PublishAt) {
+			return fmt.Errorf("Poll must close after the chirp is published")
+		}
+	}
+	return nil
+}
+
+// createChirp filters and stores the chirp, attaches its media and creates its
+// poll using the given queries, so callers decide about the surrounding
+// transaction. It returns errBlocked if the chirp mentions a user who has a
+// block with the author. A chirp with PublishAt stays scheduled until
+// runChirpPublisher publishes it; chirp.created is only sent once the chirp is
+// published.
+func (cfg *apiConfig) createChirp(ctx context.Context, queries *database.Queries, chirp Chirp) (Chirp, error) {
+	params := database.CreateChirpParams{Body: wordFilter(chirp.Body), UserID: chirp.UserID}
+
+	err := cfg.checkMentions(ctx, chirp.UserID, params.Body)
+	if err != nil {
+		return Chirp{}, err
+	}
 
-		respBody := Chirp{
-			ID:        chirpDb.ID,
-			CreatedAt: chirpDb.CreatedAt,
-			UpdatedAt: chirpDb.UpdatedAt,
-			Body:      chirpDb.Body,
-			UserID:    chirpDb.UserID,
+	if chirp.PublishAt != nil {
+		params.DelaySeconds = sql.NullInt32{Int32: int32(math.Ceil(time.Until(*chirp.PublishAt).Seconds())), Valid: true}
+	}
+
+	chirpDb, err := queries.CreateChirp(ctx, params)
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	respBody := chirpFromDb(chirpDb)
+
+	for position, medium := range chirp.Media {
+		attached, err := queries.AttachMedia(ctx, database.AttachMediaParams{
+			ChirpID:  uuid.NullUUID{UUID: chirpDb.ID, Valid: true},
+			Position: int32(position),
+			AltText:  medium.AltText,
+			ID:       medium.ID,
+			UserID:   chirp.UserID,
+		})
+		if err != nil {
+			return Chirp{}, err
+		}
+		if attached == 0 {
+			return Chirp{}, fmt.Errorf("%w: %s", errMediaUnavailable, medium.ID)
 		}
-		respondWithJSON(w, http.StatusCreated, respBody)
+	}
 
-	} else if len(reqBody.Body) > 140 {
-		respondWithError(w, http.StatusBadRequest, "Chirp is too long")
-	} else {
-		respondWithError(w, http.StatusBadRequest, "Something went wrong")
+	if chirp.Poll != nil {
+		err = createPoll(ctx, queries, chirpDb.ID, chirp.Poll)
+		if err != nil {
+			return Chirp{}, err
+		}
+	}
+
+	err = cfg.loadChirpMedia(ctx, queries, []*Chirp{&respBody})
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	err = cfg.loadChirpPolls(ctx, queries, []*Chirp{&respBody}, uuid.NullUUID{UUID: chirp.UserID, Valid: true})
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	if chirp.PublishAt == nil {
+		err = recordEvent(ctx, queries, eventChirpCreated, chirp.UserID, respBody)
+		if err != nil {
+			return Chirp{}, err
+		}
+	}
+
+	return respBody, nil
+}
+
+func chirpFromDb(chirpDb database.Chirp) Chirp {
+	chirp := Chirp{
+		ID:        chirpDb.ID,
+		CreatedAt: chirpDb.CreatedAt,
+		UpdatedAt: chirpDb.UpdatedAt,
+		Body:      chirpDb.Body,
+		UserID:    chirpDb.UserID,
+	}
+	if !chirpDb.PublishedAt.Valid && chirpDb.PublishAt.Valid {
+		chirp.PublishAt = &chirpDb.PublishAt.Time
+	}
+	if chirpDb.DeletedAt.Valid {
+		chirp.DeletedAt = &chirpDb.DeletedAt.Time
+	}
+	if chirpDb.EditedAt.Valid {
+		chirp.EditedAt = &chirpDb.EditedAt.Time
 	}
+	return chirp
 }
 
+// getChirpsHandler lists every chirp, or those of author_id. Chirps of users
+// the viewer blocked, and in the feed also those of muted users, are left out
+// by the queries.
 func (cfg *apiConfig) getChirpsHandler(w http.ResponseWriter, req *http.Request) {
 	var chirpsDb []database.Chirp
 	var err error
 	var userID uuid.UUID
 	respBody := []Chirp{}
+	viewer := cfg.chirpViewer(req)
 	if req.URL.Query().Get("author_id") == "" {
-		chirpsDb, err = cfg.dbQueries.GetChirps(req.Context())
+		chirpsDb, err = cfg.dbQueries.GetChirps(req.Context(), viewer)
 	} else {
 		userID, err = uuid.Parse(req.URL.Query().Get("author_id"))
 		if err != nil {
@@ -282,7 +509,8 @@ func (cfg *apiConfig) getChirpsHandler(w http.ResponseWriter, req *http.Request)
 			respondWithError(w, http.StatusBadRequest, "")
 			return
 		}
-		chirpsDb, err = cfg.dbQueries.GetChirpsByAuthor(req.Context(), userID)
+		chirpsDb, err = cfg.dbQueries.GetChirpsByAuthor(req.Context(),
+			database.GetChirpsByAuthorParams{UserID: userID, ViewerID: viewer})
 	}
 
 	if err == sql.ErrNoRows {
@@ -295,14 +523,7 @@ func (cfg *apiConfig) getChirpsHandler(w http.ResponseWriter, req *http.Request)
 	}
 
 	for _, chirpDb := range chirpsDb {
-		chirp := Chirp{
-			ID:        chirpDb.ID,
-			CreatedAt: chirpDb.CreatedAt,
-			UpdatedAt: chirpDb.UpdatedAt,
-			Body:      chirpDb.Body,
-			UserID:    chirpDb.UserID,
-		}
-		respBody = append(respBody, chirp)
+		respBody = append(respBody, chirpFromDb(chirpDb))
 	}
 	sortType := req.URL.Query().Get("sort")
 	if sortType == "asc" {
@@ -319,6 +540,32 @@ func (cfg *apiConfig) getChirpsHandler(w http.ResponseWriter, req *http.Request)
 		return
 	}
 
+	if req.URL.Query().Get("author_id") != "" {
+		err = cfg.pinFirst(req.Context(), userID, respBody)
+		if err != nil {
+			log.Printf("Unable to retrieve pinned chirp: %s %s [%s]", req.Method, req.URL.Path, err)
+			respondWithError(w, http.StatusInternalServerError, "")
+			return
+		}
+	}
+
+	chirps := []*Chirp{}
+	for i := range respBody {
+		chirps = append(chirps, &respBody[i])
+	}
+	err = cfg.loadChirpMedia(req.Context(), cfg.dbQueries, chirps)
+	if err != nil {
+		log.Printf("Unable to retrieve chirp media: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+	err = cfg.loadChirpPolls(req.Context(), cfg.dbQueries, chirps, viewer)
+	if err != nil {
+		log.Printf("Unable to retrieve chirp polls: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
 	respondWithJSON(w, http.StatusOK, respBody)
 }
 
@@ -329,7 +576,9 @@ func (cfg *apiConfig) getChirpByIdHandler(w http.ResponseWriter, req *http.Reque
 		return
 	}
 
-	chirpDb, err := cfg.dbQueries.GetChirpById(req.Context(), chirpID)
+	viewer := cfg.chirpViewer(req)
+	chirpDb, err := cfg.dbQueries.GetChirpById(req.Context(),
+		database.GetChirpByIdParams{ID: chirpID, ViewerID: viewer})
 	if err == sql.ErrNoRows {
 		log.Printf("Chirp not found")
 		respondWithError(w, http.StatusNotFound, "")
@@ -339,17 +588,32 @@ func (cfg *apiConfig) getChirpByIdHandler(w http.ResponseWriter, req *http.Reque
 		return
 	}
 
-	respBody := Chirp{
-		ID:        chirpDb.ID,
-		CreatedAt: chirpDb.CreatedAt,
-		UpdatedAt: chirpDb.UpdatedAt,
-		Body:      chirpDb.Body,
-		UserID:    chirpDb.UserID,
+	if !chirpDb.PublishedAt.Valid && (!viewer.Valid || viewer.UUID != chirpDb.UserID) {
+		log.Printf("Chirp not published yet")
+		respondWithError(w, http.StatusNotFound, "")
+		return
+	}
+
+	respBody := chirpFromDb(chirpDb)
+
+	err = cfg.loadChirpMedia(req.Context(), cfg.dbQueries, []*Chirp{&respBody})
+	if err != nil {
+		log.Printf("Unable to retrieve chirp media: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+	err = cfg.loadChirpPolls(req.Context(), cfg.dbQueries, []*Chirp{&respBody}, viewer)
+	if err != nil {
+		log.Printf("Unable to retrieve chirp polls: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
 	}
 
 	respondWithJSON(w, http.StatusOK, respBody)
 }
 
+// deleteChirpHandler soft-deletes the chirp. It stays restorable by its author
+// for chirpRestoreWindow and visible to admins until runChirpPurger removes it.
 func (cfg *apiConfig) deleteChirpHandler(w http.ResponseWriter, req *http.Request) {
 	reqBody := Auth{}
 
@@ -362,7 +626,7 @@ func (cfg *apiConfig) deleteChirpHandler(w http.ResponseWriter, req *http.Reques
 		return
 	}
 
-	UserID, err := auth.ValidateJWT(stringToken, cfg.secret)
+	UserID, err := auth.ValidateJWTScope(stringToken, cfg.secret, oauth.ScopeChirpsWrite)
 	if err != nil {
 		log.Printf("Unable to validate the token: %s %s [%s]", req.Method, req.URL.Path, err)
 		respondWithError(w, http.StatusUnauthorized, "")
@@ -375,7 +639,8 @@ func (cfg *apiConfig) deleteChirpHandler(w http.ResponseWriter, req *http.Reques
 		return
 	}
 
-	chirpDb, err := cfg.dbQueries.GetChirpById(req.Context(), chirpID)
+	chirpDb, err := cfg.dbQueries.GetChirpById(req.Context(),
+		database.GetChirpByIdParams{ID: chirpID, ViewerID: uuid.NullUUID{UUID: UserID, Valid: true}})
 	if err == sql.ErrNoRows {
 		log.Printf("Chirp not found")
 		respondWithError(w, http.StatusNotFound, "")
@@ -392,9 +657,53 @@ func (cfg *apiConfig) deleteChirpHandler(w http.ResponseWriter, req *http.Reques
 		return
 	}
 
-	err = cfg.dbQueries.DeleteChirpById(req.Context(), chirpID)
+	tx, err := cfg.db.BeginTx(req.Context(), nil)
+	if err != nil {
+		log.Printf("Unable to begin transaction: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	// The chirp may have been deleted by a concurrent request since it was
+	// read.
+	chirpDb, err = qtx.SoftDeleteChirp(req.Context(), chirpID)
+	if err == sql.ErrNoRows {
+		log.Printf("Chirp not found")
+		respondWithError(w, http.StatusNotFound, "")
+		return
+	} else if err != nil {
+		log.Printf("Unable to delete chirp: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	err = qtx.UnpinChirpEverywhere(req.Context(), chirpID)
+	if err != nil {
+		log.Printf("Unable to unpin chirp: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	err = qtx.RemoveChirpBookmarks(req.Context(), chirpID)
+	if err != nil {
+		log.Printf("Unable to remove bookmarks: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	err = recordEvent(req.Context(), qtx, eventChirpDeleted, chirpDb.UserID, chirpFromDb(chirpDb))
 	if err != nil {
-		log.Printf("Unable to delete chirp")
+		log.Printf("Unable to record event: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("Unable to commit transaction: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
 		return
 	}
 
@@ -2,29 +2,18 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
 )
 
-func HashPassword(password string) (string, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), 1)
-	if err != nil {
-		return "", err
-	}
-	return string(hash), nil
-}
-
-func CheckPasswordHash(hash, password string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-}
-
 func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
 	claims := jwt.RegisteredClaims{
 		Issuer:    "chirpy",
@@ -59,6 +48,81 @@ func ValidateJWT(tokenString, tokenSecret string) (userID uuid.UUID, err error)
 	return userID, nil
 }
 
+// ScopedClaims are the claims of an access token issued to a third-party
+// OAuth client. First-party tokens from MakeJWT carry no client and no scope.
+type ScopedClaims struct {
+	jwt.RegisteredClaims
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+}
+
+func MakeScopedJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration, clientID, scope string) (string, error) {
+	claims := ScopedClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "chirpy",
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiresIn)),
+			Subject:   fmt.Sprintf("%v", userID),
+		},
+		ClientID: clientID,
+		Scope:    scope,
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	signedToken, err := token.SignedString([]byte(tokenSecret))
+	if err != nil {
+		return "", err
+	}
+	return signedToken, nil
+}
+
+func ParseScopedJWT(tokenString, tokenSecret string) (ScopedClaims, error) {
+	claims := ScopedClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, &claims,
+		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil })
+	if err != nil {
+		return ScopedClaims{}, err
+	}
+	return claims, nil
+}
+
+// ValidateJWTScope validates the token like ValidateJWT and additionally
+// requires third-party tokens to have been granted the given scope.
+func ValidateJWTScope(tokenString, tokenSecret, scope string) (userID uuid.UUID, err error) {
+	claims, err := ParseScopedJWT(tokenString, tokenSecret)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+
+	if claims.ClientID != "" && !slices.Contains(strings.Fields(claims.Scope), scope) {
+		return uuid.UUID{}, fmt.Errorf("token lacks required scope: %v", scope)
+	}
+
+	userID, err = uuid.Parse(claims.Subject)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+	return userID, nil
+}
+
+// ValidateFirstPartyJWT validates the token like ValidateJWT but rejects
+// tokens issued to third-party OAuth clients, whatever their scope.
+func ValidateFirstPartyJWT(tokenString, tokenSecret string) (userID uuid.UUID, err error) {
+	claims, err := ParseScopedJWT(tokenString, tokenSecret)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+
+	if claims.ClientID != "" {
+		return uuid.UUID{}, fmt.Errorf("token was issued to client: %v", claims.ClientID)
+	}
+
+	userID, err = uuid.Parse(claims.Subject)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+	return userID, nil
+}
+
 func GetBearerToken(headers http.Header) (string, error) {
 	authHeader := headers["Authorization"]
 	if len(authHeader) == 0 {
@@ -78,6 +142,13 @@ func MakeRefreshToken() (string, error) {
 	return hex.EncodeToString(rawRefreshToken), nil
 }
 
+// HashToken returns the hex encoded SHA-256 of a single-use token so it can
+// be stored and looked up without keeping the token itself.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 func GetAPIKey(headers http.Header) (string, error) {
 	authHeader := headers["Authorization"]
 	if len(authHeader) == 0 {
@@ -100,3 +100,51 @@ func TestGetBearerToken(t *testing.T) {
 	}
 
 }
+
+func TestValidateJWTScope(t *testing.T) {
+	user, _ := uuid.Parse("60a9b112-00f4-46bb-9e33-9b4004349d62")
+	tokenSecret := "JustNot4gain"
+	expiresIn := time.Duration(32154334567657)
+
+	token, err := MakeScopedJWT(user, tokenSecret, expiresIn, "client", "chirps:read")
+	if err != nil {
+		t.Errorf("Token was not created: %v", err)
+		return
+	}
+
+	_, err = ValidateJWTScope(token, tokenSecret, "chirps:read")
+	if err != nil {
+		t.Errorf("Token with granted scope was not validated: %v", err)
+		return
+	}
+
+	_, err = ValidateJWTScope(token, tokenSecret, "chirps:write")
+	if err == nil {
+		t.Fatal("Token without required scope was validated!")
+	}
+
+	firstPartyToken, _ := MakeJWT(user, tokenSecret, expiresIn)
+	_, err = ValidateJWTScope(firstPartyToken, tokenSecret, "chirps:write")
+	if err != nil {
+		t.Errorf("First-party token was not validated: %v", err)
+	}
+}
+
+func TestValidateFirstPartyJWT(t *testing.T) {
+	user, _ := uuid.Parse("60a9b112-00f4-46bb-9e33-9b4004349d62")
+	tokenSecret := "JustNot4gain"
+	expiresIn := time.Duration(32154334567657)
+
+	firstPartyToken, _ := MakeJWT(user, tokenSecret, expiresIn)
+	userID, err := ValidateFirstPartyJWT(firstPartyToken, tokenSecret)
+	if err != nil || userID != user {
+		t.Errorf("First-party token was not validated: %v", err)
+		return
+	}
+
+	clientToken, _ := MakeScopedJWT(user, tokenSecret, expiresIn, "client", "chirps:read chirps:write profile")
+	_, err = ValidateFirstPartyJWT(clientToken, tokenSecret)
+	if err == nil {
+		t.Fatal("Token of a third-party client was validated!")
+	}
+}
@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordHasher produces self-describing password hashes. Argon2id hashes use
+// the PHC string format, bcrypt hashes the modular crypt format, so
+// CheckPasswordHash can verify either regardless of the configured hasher.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	// NeedsRehash reports whether the hash was produced by another algorithm
+	// or with weaker parameters than the hasher is configured for.
+	NeedsRehash(hash string) bool
+}
+
+type Argon2idHasher struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+type BcryptHasher struct {
+	Cost int
+}
+
+// BcryptMaxBytes is the longest password bcrypt accepts, counted in bytes.
+const BcryptMaxBytes = 72
+
+// DefaultHasher follows the OWASP recommendation for argon2id.
+var DefaultHasher PasswordHasher = Argon2idHasher{
+	Memory:      19 * 1024,
+	Iterations:  2,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+const argon2idPrefix = "$argon2id$"
+
+func (h Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix,
+		argon2.Version,
+		h.Memory,
+		h.Iterations,
+		h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (h Argon2idHasher) NeedsRehash(hash string) bool {
+	params, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return true
+	}
+	return params.Memory < h.Memory ||
+		params.Iterations < h.Iterations ||
+		params.Parallelism < h.Parallelism ||
+		uint32(len(salt)) < h.SaltLength ||
+		uint32(len(key)) < h.KeyLength
+}
+
+func (h BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (h BcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return true
+	}
+	return cost < h.Cost
+}
+
+func HashPassword(password string) (string, error) {
+	return DefaultHasher.Hash(password)
+}
+
+func CheckPasswordHash(hash, password string) error {
+	if !strings.HasPrefix(hash, argon2idPrefix) {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	}
+
+	params, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return err
+	}
+
+	candidate := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, candidate) != 1 {
+		return fmt.Errorf("password does not match the hash")
+	}
+	return nil
+}
+
+func decodeArgon2id(hash string) (Argon2idHasher, []byte, []byte, error) {
+	params := Argon2idHasher{}
+
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, fmt.Errorf("malformed argon2id hash")
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2id version: %s", parts[2])
+	}
+
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("malformed argon2id parameters: %w", err)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("malformed argon2id salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("malformed argon2id key: %w", err)
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
+
+// PasswordPolicy limits passwords to MinLength to MaxLength characters and,
+// when MaxBytes is set, to MaxBytes bytes of UTF-8.
+type PasswordPolicy struct {
+	MinLength int
+	MaxLength int
+	MaxBytes  int
+	breached  map[string]bool
+}
+
+// LoadPasswordPolicy builds a policy whose breached-password list is read from
+// path, one password per line. Blank lines and lines starting with # are
+// ignored. An empty path disables the breached-password check.
+func LoadPasswordPolicy(minLength, maxLength int, path string) (PasswordPolicy, error) {
+	policy := PasswordPolicy{
+		MinLength: minLength,
+		MaxLength: maxLength,
+		breached:  map[string]bool{},
+	}
+	if path == "" {
+		return policy, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return PasswordPolicy{}, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		policy.breached[strings.ToLower(line)] = true
+	}
+
+	return policy, scanner.Err()
+}
+
+func (p PasswordPolicy) Check(password string) error {
+	length := len([]rune(password))
+	if length < p.MinLength {
+		return fmt.Errorf("password must be at least %d characters long", p.MinLength)
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		return fmt.Errorf("password must be at most %d characters long", p.MaxLength)
+	}
+	if p.MaxBytes > 0 && len(password) > p.MaxBytes {
+		return fmt.Errorf("password must be at most %d bytes long", p.MaxBytes)
+	}
+	if p.breached[strings.ToLower(password)] {
+		return fmt.Errorf("password appears in a list of breached passwords")
+	}
+	return nil
+}
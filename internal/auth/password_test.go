@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestArgon2idHasher(t *testing.T) {
+	hasher := Argon2idHasher{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	password := "Le4st_usele55"
+
+	hash, err := hasher.Hash(password)
+	if err != nil {
+		t.Errorf("Hash was not created: %v", err)
+		return
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Errorf("Hash is not in PHC format: %s", hash)
+	}
+
+	err = CheckPasswordHash(hash, password)
+	if err != nil {
+		t.Errorf("Password does not match the hash: %v", err)
+	}
+
+	err = CheckPasswordHash(hash, "Wrong_pa55word")
+	if err == nil {
+		t.Fatal("Wrong password matched the hash!")
+	}
+}
+
+func TestBcryptHasher(t *testing.T) {
+	hasher := BcryptHasher{Cost: 4}
+	password := "Le4st_usele55"
+
+	hash, err := hasher.Hash(password)
+	if err != nil {
+		t.Errorf("Hash was not created: %v", err)
+		return
+	}
+
+	err = CheckPasswordHash(hash, password)
+	if err != nil {
+		t.Errorf("Password does not match the hash: %v", err)
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	weak := Argon2idHasher{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	strong := Argon2idHasher{Memory: 2048, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+	legacyHash, _ := BcryptHasher{Cost: 4}.Hash("Le4st_usele55")
+	weakHash, _ := weak.Hash("Le4st_usele55")
+	strongHash, _ := strong.Hash("Le4st_usele55")
+
+	if !strong.NeedsRehash(legacyHash) {
+		t.Error("Bcrypt hash was not flagged for argon2id rehash")
+	}
+	if !strong.NeedsRehash(weakHash) {
+		t.Error("Hash with weaker parameters was not flagged for rehash")
+	}
+	if strong.NeedsRehash(strongHash) {
+		t.Error("Up to date hash was flagged for rehash")
+	}
+	if !(BcryptHasher{Cost: 10}).NeedsRehash(legacyHash) {
+		t.Error("Low cost bcrypt hash was not flagged for rehash")
+	}
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	os.WriteFile(path, []byte("# common passwords\nPassword123\n\nletmein1\n"), 0o600)
+
+	policy, err := LoadPasswordPolicy(8, 64, path)
+	if err != nil {
+		t.Errorf("Policy was not loaded: %v", err)
+		return
+	}
+
+	if policy.Check("Le4st_usele55") != nil {
+		t.Error("Strong password was rejected")
+	}
+	if policy.Check("short") == nil {
+		t.Error("Short password was accepted")
+	}
+	if policy.Check(strings.Repeat("a", 65)) == nil {
+		t.Error("Long password was accepted")
+	}
+	if policy.Check("password123") == nil {
+		t.Error("Breached password was accepted")
+	}
+
+	policy.MaxBytes = BcryptMaxBytes
+	if policy.Check(strings.Repeat("é", 36)) != nil {
+		t.Error("Password of 72 bytes was rejected")
+	}
+	if policy.Check(strings.Repeat("é", 37)) == nil {
+		t.Error("Password over 72 bytes was accepted")
+	}
+}
@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultWebhookTolerance is how far the signed timestamp of a webhook may be
+// from the current time.
+const DefaultWebhookTolerance = 5 * time.Minute
+
+var (
+	ErrWebhookMalformed = errors.New("malformed webhook signature")
+	ErrWebhookTimestamp = errors.New("webhook timestamp outside the tolerance window")
+	ErrWebhookSignature = errors.New("webhook signature does not match")
+)
+
+// WebhookVerifier checks signatures of the form "t=<unix time>,v1=<hex>",
+// where v1 is the HMAC-SHA256 of "<unix time>.<raw body>". Every configured
+// secret is tried, so a secret can be rotated without downtime, and the sender
+// may include several v1 values while it rotates on its side.
+type WebhookVerifier struct {
+	Secrets   []string
+	Tolerance time.Duration
+	// Now returns the current time; it defaults to time.Now.
+	Now func() time.Time
+}
+
+// Verify returns nil if the signature header is valid for the body.
+func (v WebhookVerifier) Verify(signatureHeader string, body []byte) error {
+	timestamp, signatures, err := parseWebhookSignature(signatureHeader)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now
+	if v.Now != nil {
+		now = v.Now
+	}
+	tolerance := v.Tolerance
+	if tolerance == 0 {
+		tolerance = DefaultWebhookTolerance
+	}
+	age := now().Sub(time.Unix(timestamp, 0))
+	if age > tolerance || age < -tolerance {
+		return ErrWebhookTimestamp
+	}
+
+	for _, secret := range v.Secrets {
+		if secret == "" {
+			continue
+		}
+		expected := webhookMAC(secret, timestamp, body)
+		for _, signature := range signatures {
+			if hmac.Equal(signature, expected) {
+				return nil
+			}
+		}
+	}
+	return ErrWebhookSignature
+}
+
+// WebhookTimestamp returns the signed timestamp of a signature header. It
+// does not verify the signature.
+func WebhookTimestamp(signatureHeader string) (time.Time, error) {
+	timestamp, _, err := parseWebhookSignature(signatureHeader)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(timestamp, 0), nil
+}
+
+// SignWebhook returns the signature header for the body, as checked by
+// WebhookVerifier.
+func SignWebhook(secret string, timestamp time.Time, body []byte) string {
+	unix := timestamp.Unix()
+	return fmt.Sprintf("t=%d,v1=%s", unix, hex.EncodeToString(webhookMAC(secret, unix, body)))
+}
+
+func webhookMAC(secret string, timestamp int64, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+func parseWebhookSignature(header string) (int64, [][]byte, error) {
+	var timestamp int64
+	var signatures [][]byte
+
+	for _, part := range strings.Split(header, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			return 0, nil, ErrWebhookMalformed
+		}
+		switch key {
+		case "t":
+			parsed, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return 0, nil, ErrWebhookMalformed
+			}
+			timestamp = parsed
+		case "v1":
+			signature, err := hex.DecodeString(value)
+			if err != nil {
+				return 0, nil, ErrWebhookMalformed
+			}
+			signatures = append(signatures, signature)
+		}
+	}
+
+	if timestamp == 0 || len(signatures) == 0 {
+		return 0, nil, ErrWebhookMalformed
+	}
+	return timestamp, signatures, nil
+}
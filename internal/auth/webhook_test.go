@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWebhookVerifier(t *testing.T) {
+	body := []byte(`{"event":"user.upgraded","data":{"user_id":"60a9b112-00f4-46bb-9e33-9b4004349d62"}}`)
+	now := time.Unix(1700000000, 0)
+	verifier := WebhookVerifier{
+		Secrets:   []string{"new_s3cret", "old_s3cret"},
+		Tolerance: 5 * time.Minute,
+		Now:       func() time.Time { return now },
+	}
+
+	cases := []struct {
+		name   string
+		header string
+		want   error
+	}{
+		{"current secret", SignWebhook("new_s3cret", now, body), nil},
+		{"rotated secret", SignWebhook("old_s3cret", now, body), nil},
+		{"within tolerance", SignWebhook("new_s3cret", now.Add(-4*time.Minute), body), nil},
+		{"unknown secret", SignWebhook("Habarubu!", now, body), ErrWebhookSignature},
+		{"replayed", SignWebhook("new_s3cret", now.Add(-6*time.Minute), body), ErrWebhookTimestamp},
+		{"from the future", SignWebhook("new_s3cret", now.Add(6*time.Minute), body), ErrWebhookTimestamp},
+		{"missing signature", "t=1700000000", ErrWebhookMalformed},
+		{"not hex", "t=1700000000,v1=zz", ErrWebhookMalformed},
+		{"empty", "", ErrWebhookMalformed},
+	}
+
+	for _, c := range cases {
+		err := verifier.Verify(c.header, body)
+		if !errors.Is(err, c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, err, c.want)
+		}
+	}
+}
+
+func TestWebhookVerifierTamperedBody(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	verifier := WebhookVerifier{
+		Secrets: []string{"new_s3cret"},
+		Now:     func() time.Time { return now },
+	}
+
+	header := SignWebhook("new_s3cret", now, []byte(`{"event":"user.upgraded"}`))
+	err := verifier.Verify(header, []byte(`{"event":"user.downgraded"}`))
+	if !errors.Is(err, ErrWebhookSignature) {
+		t.Fatalf("Tampered body was verified: %v", err)
+	}
+}
+
+func TestWebhookVerifierMultipleSignatures(t *testing.T) {
+	body := []byte(`{}`)
+	now := time.Unix(1700000000, 0)
+	verifier := WebhookVerifier{
+		Secrets: []string{"new_s3cret"},
+		Now:     func() time.Time { return now },
+	}
+
+	header := SignWebhook("old_s3cret", now, body)
+	header += "," + SignWebhook("new_s3cret", now, body)[len("t=1700000000,"):]
+
+	err := verifier.Verify(header, body)
+	if err != nil {
+		t.Fatalf("Header with one valid signature was rejected: %v", err)
+	}
+}
+
+func TestWebhookTimestamp(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	timestamp, err := WebhookTimestamp(SignWebhook("s3cret", now, []byte(`{}`)))
+	if err != nil {
+		t.Fatalf("Timestamp of a valid header was rejected: %v", err)
+	}
+	if !timestamp.Equal(now) {
+		t.Errorf("Expected timestamp %v, got %v", now, timestamp)
+	}
+
+	_, err = WebhookTimestamp("v1=abcd")
+	if !errors.Is(err, ErrWebhookMalformed) {
+		t.Errorf("Expected ErrWebhookMalformed, got %v", err)
+	}
+}
@@ -7,6 +7,8 @@ package database
 
 import (
 	"context"
+	"database/sql"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -17,6 +19,7 @@ FROM refresh_token
 WHERE token = $1
 AND expires_at > NOW()
 AND revoked_at IS NULL
+AND client_id IS NULL
 `
 
 func (q *Queries) GetUserFromRefreshToken(ctx context.Context, token string) (uuid.UUID, error) {
@@ -26,6 +29,48 @@ func (q *Queries) GetUserFromRefreshToken(ctx context.Context, token string) (uu
 	return user_id, err
 }
 
+const getUserSessions = `-- name: GetUserSessions :many
+SELECT created_at, expires_at, revoked_at, client_id
+FROM refresh_token
+WHERE user_id = $1
+ORDER BY created_at
+`
+
+type GetUserSessionsRow struct {
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	RevokedAt sql.NullTime
+	ClientID  sql.NullString
+}
+
+func (q *Queries) GetUserSessions(ctx context.Context, userID uuid.UUID) ([]GetUserSessionsRow, error) {
+	rows, err := q.db.QueryContext(ctx, getUserSessions, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetUserSessionsRow
+	for rows.Next() {
+		var i GetUserSessionsRow
+		if err := rows.Scan(
+			&i.CreatedAt,
+			&i.ExpiresAt,
+			&i.RevokedAt,
+			&i.ClientID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const revokeRefershToken = `-- name: RevokeRefershToken :exec
 UPDATE refresh_token
 SET updated_at = NOW(),
@@ -38,6 +83,19 @@ func (q *Queries) RevokeRefershToken(ctx context.Context, token string) error {
 	return err
 }
 
+const revokeUserRefreshTokens = `-- name: RevokeUserRefreshTokens :exec
+UPDATE refresh_token
+SET updated_at = NOW(),
+    revoked_at = NOW()
+WHERE user_id = $1
+AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeUserRefreshTokens(ctx context.Context, userID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, revokeUserRefreshTokens, userID)
+	return err
+}
+
 const storeRefreshToken = `-- name: StoreRefreshToken :one
 INSERT INTO refresh_token (token, created_at, updated_at, user_id, expires_at, revoked_at)
 VALUES (
@@ -48,7 +106,7 @@ VALUES (
     NOW() + interval '60 days',
     NULL
     )
-RETURNING token, created_at, updated_at, user_id, expires_at, revoked_at
+RETURNING token, created_at, updated_at, user_id, expires_at, revoked_at, client_id, scope
 `
 
 type StoreRefreshTokenParams struct {
@@ -66,6 +124,8 @@ func (q *Queries) StoreRefreshToken(ctx context.Context, arg StoreRefreshTokenPa
 		&i.UserID,
 		&i.ExpiresAt,
 		&i.RevokedAt,
+		&i.ClientID,
+		&i.Scope,
 	)
 	return i, err
 }
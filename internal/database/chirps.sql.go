@@ -7,29 +7,70 @@ package database
 
 import (
 	"context"
+	"database/sql"
 
 	"github.com/google/uuid"
 )
 
+const countChirpsByAuthor = `-- name: CountChirpsByAuthor :one
+SELECT COUNT(*)
+FROM chirps
+WHERE user_id = $1
+AND deleted_at IS NULL
+`
+
+func (q *Queries) CountChirpsByAuthor(ctx context.Context, userID uuid.UUID) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countChirpsByAuthor, userID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countRecentChirps = `-- name: CountRecentChirps :one
+SELECT COUNT(*)
+FROM chirps
+WHERE user_id = $1
+AND created_at > NOW() - ($2::int * interval '1 second')
+`
+
+type CountRecentChirpsParams struct {
+	UserID        uuid.UUID
+	WindowSeconds int32
+}
+
+func (q *Queries) CountRecentChirps(ctx context.Context, arg CountRecentChirpsParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countRecentChirps, arg.UserID, arg.WindowSeconds)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const createChirp = `-- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, publish_at, published_at)
 VALUES (
     gen_random_uuid(),
     NOW(),
     NOW(),
     $1,
-    $2
+    $2,
+    NOW() + ($3::int * interval '1 second'),
+    CASE WHEN $3::int IS NULL THEN NOW() END
 )
-RETURNING id, created_at, updated_at, body, user_id
+RETURNING id, created_at, updated_at, body, user_id, publish_at, published_at, deleted_at, edited_at
 `
 
 type CreateChirpParams struct {
-	Body   string
-	UserID uuid.UUID
+	Body         string
+	UserID       uuid.UUID
+	DelaySeconds sql.NullInt32
 }
 
 func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp, error) {
-	row := q.db.QueryRowContext(ctx, createChirp, arg.Body, arg.UserID)
+	row := q.db.QueryRowContext(ctx, createChirp,
+		arg.Body,
+		arg.UserID,
+		arg.DelaySeconds,
+	)
 	var i Chirp
 	err := row.Scan(
 		&i.ID,
@@ -37,19 +78,88 @@ func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.PublishAt,
+		&i.PublishedAt,
+		&i.DeletedAt,
+		&i.EditedAt,
 	)
 	return i, err
 }
 
-const deleteChirpById = `-- name: DeleteChirpById :exec
-DELETE
+const editChirp = `-- name: EditChirp :one
+UPDATE chirps
+SET body = $1, edited_at = NOW(), updated_at = NOW()
+WHERE id = $2
+AND deleted_at IS NULL
+AND (published_at IS NULL OR published_at > NOW() - ($3::int * interval '1 second'))
+RETURNING id, created_at, updated_at, body, user_id, publish_at, published_at, deleted_at, edited_at
+`
+
+type EditChirpParams struct {
+	Body          string
+	ID            uuid.UUID
+	WindowSeconds int32
+}
+
+func (q *Queries) EditChirp(ctx context.Context, arg EditChirpParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, editChirp,
+		arg.Body,
+		arg.ID,
+		arg.WindowSeconds,
+	)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.PublishAt,
+		&i.PublishedAt,
+		&i.DeletedAt,
+		&i.EditedAt,
+	)
+	return i, err
+}
+
+const getAllChirpsByAuthor = `-- name: GetAllChirpsByAuthor :many
+SELECT id, created_at, updated_at, body, user_id, publish_at, published_at, deleted_at, edited_at
 FROM chirps
-WHERE id = $1
+WHERE user_id = $1
+ORDER BY created_at, id
 `
 
-func (q *Queries) DeleteChirpById(ctx context.Context, id uuid.UUID) error {
-	_, err := q.db.ExecContext(ctx, deleteChirpById, id)
-	return err
+func (q *Queries) GetAllChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getAllChirpsByAuthor, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.PublishAt,
+			&i.PublishedAt,
+			&i.DeletedAt,
+			&i.EditedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
 const getChirpById = `-- name: GetChirpById :one
@@ -58,13 +168,47 @@ SELECT
     created_at, 
     updated_at, 
     body, 
-    user_id 
+    user_id,
+    publish_at,
+    published_at,
+    deleted_at,
+    edited_at
+FROM chirps
+WHERE id = $1
+AND deleted_at IS NULL
+AND NOT hidden_from($2::uuid, user_id, FALSE)
+`
+
+type GetChirpByIdParams struct {
+	ID       uuid.UUID
+	ViewerID uuid.NullUUID
+}
+
+func (q *Queries) GetChirpById(ctx context.Context, arg GetChirpByIdParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, getChirpById, arg.ID, arg.ViewerID)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.PublishAt,
+		&i.PublishedAt,
+		&i.DeletedAt,
+		&i.EditedAt,
+	)
+	return i, err
+}
+
+const getChirpByIdWithDeleted = `-- name: GetChirpByIdWithDeleted :one
+SELECT id, created_at, updated_at, body, user_id, publish_at, published_at, deleted_at, edited_at
 FROM chirps
 WHERE id = $1
 `
 
-func (q *Queries) GetChirpById(ctx context.Context, id uuid.UUID) (Chirp, error) {
-	row := q.db.QueryRowContext(ctx, getChirpById, id)
+func (q *Queries) GetChirpByIdWithDeleted(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, getChirpByIdWithDeleted, id)
 	var i Chirp
 	err := row.Scan(
 		&i.ID,
@@ -72,6 +216,10 @@ func (q *Queries) GetChirpById(ctx context.Context, id uuid.UUID) (Chirp, error)
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.PublishAt,
+		&i.PublishedAt,
+		&i.DeletedAt,
+		&i.EditedAt,
 	)
 	return i, err
 }
@@ -82,13 +230,20 @@ SELECT
     created_at, 
     updated_at, 
     body, 
-    user_id 
+    user_id,
+    publish_at,
+    published_at,
+    deleted_at,
+    edited_at
 FROM chirps
+WHERE published_at IS NOT NULL
+AND deleted_at IS NULL
+AND NOT hidden_from($1::uuid, user_id, TRUE)
 ORDER BY created_at
 `
 
-func (q *Queries) GetChirps(ctx context.Context) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getChirps)
+func (q *Queries) GetChirps(ctx context.Context, viewerID uuid.NullUUID) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getChirps, viewerID)
 	if err != nil {
 		return nil, err
 	}
@@ -102,6 +257,10 @@ func (q *Queries) GetChirps(ctx context.Context) ([]Chirp, error) {
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.PublishAt,
+			&i.PublishedAt,
+			&i.DeletedAt,
+			&i.EditedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -122,14 +281,131 @@ SELECT
     created_at, 
     updated_at, 
     body, 
-    user_id 
+    user_id,
+    publish_at,
+    published_at,
+    deleted_at,
+    edited_at
 FROM chirps
 WHERE user_id = $1
+AND published_at IS NOT NULL
+AND deleted_at IS NULL
+AND NOT hidden_from($2::uuid, user_id, FALSE)
 ORDER BY created_at
 `
 
-func (q *Queries) GetChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getChirpsByAuthor, userID)
+type GetChirpsByAuthorParams struct {
+	UserID   uuid.UUID
+	ViewerID uuid.NullUUID
+}
+
+func (q *Queries) GetChirpsByAuthor(ctx context.Context, arg GetChirpsByAuthorParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpsByAuthor, arg.UserID, arg.ViewerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.PublishAt,
+			&i.PublishedAt,
+			&i.DeletedAt,
+			&i.EditedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getDeletedChirps = `-- name: GetDeletedChirps :many
+SELECT id, created_at, updated_at, body, user_id, publish_at, published_at, deleted_at, edited_at
+FROM chirps
+WHERE deleted_at IS NOT NULL
+ORDER BY deleted_at DESC
+LIMIT $1
+OFFSET $2
+`
+
+type GetDeletedChirpsParams struct {
+	Limit  int32
+	Offset int32
+}
+
+func (q *Queries) GetDeletedChirps(ctx context.Context, arg GetDeletedChirpsParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getDeletedChirps, arg.Limit, arg.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.PublishAt,
+			&i.PublishedAt,
+			&i.DeletedAt,
+			&i.EditedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const lockChirpRate = `-- name: LockChirpRate :exec
+SELECT pg_advisory_xact_lock(hashtext('chirp_rate'), hashtext($1::text))
+`
+
+func (q *Queries) LockChirpRate(ctx context.Context, userID string) error {
+	_, err := q.db.ExecContext(ctx, lockChirpRate, userID)
+	return err
+}
+
+const publishDueChirps = `-- name: PublishDueChirps :many
+UPDATE chirps
+SET created_at = NOW(), updated_at = NOW(), published_at = NOW()
+WHERE id IN (
+    SELECT id
+    FROM chirps
+    WHERE published_at IS NULL
+    AND deleted_at IS NULL
+    AND publish_at <= NOW()
+    ORDER BY publish_at
+    LIMIT $1
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING id, created_at, updated_at, body, user_id, publish_at, published_at, deleted_at, edited_at
+`
+
+func (q *Queries) PublishDueChirps(ctx context.Context, limit int32) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, publishDueChirps, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -143,6 +419,10 @@ func (q *Queries) GetChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([]Ch
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.PublishAt,
+			&i.PublishedAt,
+			&i.DeletedAt,
+			&i.EditedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -156,3 +436,72 @@ func (q *Queries) GetChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([]Ch
 	}
 	return items, nil
 }
+
+const purgeDeletedChirps = `-- name: PurgeDeletedChirps :execrows
+DELETE
+FROM chirps
+WHERE deleted_at < NOW() - ($1::int * interval '1 second')
+`
+
+func (q *Queries) PurgeDeletedChirps(ctx context.Context, retentionSeconds int32) (int64, error) {
+	result, err := q.db.ExecContext(ctx, purgeDeletedChirps, retentionSeconds)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const restoreChirp = `-- name: RestoreChirp :one
+UPDATE chirps
+SET deleted_at = NULL, updated_at = NOW()
+WHERE id = $1
+AND deleted_at > NOW() - ($2::int * interval '1 second')
+RETURNING id, created_at, updated_at, body, user_id, publish_at, published_at, deleted_at, edited_at
+`
+
+type RestoreChirpParams struct {
+	ID            uuid.UUID
+	WindowSeconds int32
+}
+
+func (q *Queries) RestoreChirp(ctx context.Context, arg RestoreChirpParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, restoreChirp, arg.ID, arg.WindowSeconds)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.PublishAt,
+		&i.PublishedAt,
+		&i.DeletedAt,
+		&i.EditedAt,
+	)
+	return i, err
+}
+
+const softDeleteChirp = `-- name: SoftDeleteChirp :one
+UPDATE chirps
+SET deleted_at = NOW(), updated_at = NOW()
+WHERE id = $1
+AND deleted_at IS NULL
+RETURNING id, created_at, updated_at, body, user_id, publish_at, published_at, deleted_at, edited_at
+`
+
+func (q *Queries) SoftDeleteChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, softDeleteChirp, id)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.PublishAt,
+		&i.PublishedAt,
+		&i.DeletedAt,
+		&i.EditedAt,
+	)
+	return i, err
+}
@@ -0,0 +1,69 @@
+package entitlements
+
+import "time"
+
+const (
+	PlanFree    = "free"
+	PlanRed     = "red"
+	PlanRedPlus = "red_plus"
+)
+
+// Entitlements are the capabilities of a plan. Handlers consult them instead
+// of hardcoding limits.
+type Entitlements struct {
+	Plan            string
+	MaxChirpLength  int
+	MaxChirpMedia   int
+	ChirpsPerHour   int
+	ScheduledChirps bool
+	PinChirps       bool
+	// EditWindow is how long after publishing a chirp can be edited. Zero
+	// means chirps cannot be edited.
+	EditWindow time.Duration
+}
+
+var plans = map[string]Entitlements{
+	PlanFree: {
+		Plan:           PlanFree,
+		MaxChirpLength: 140,
+		MaxChirpMedia:  2,
+		ChirpsPerHour:  30,
+	},
+	PlanRed: {
+		Plan:            PlanRed,
+		MaxChirpLength:  280,
+		MaxChirpMedia:   4,
+		ChirpsPerHour:   100,
+		ScheduledChirps: true,
+		PinChirps:       true,
+		EditWindow:      30 * time.Minute,
+	},
+	PlanRedPlus: {
+		Plan:            PlanRedPlus,
+		MaxChirpLength:  1000,
+		MaxChirpMedia:   4,
+		ChirpsPerHour:   300,
+		ScheduledChirps: true,
+		PinChirps:       true,
+		EditWindow:      time.Hour,
+	},
+}
+
+// MaxChirpLength is the longest chirp any plan allows.
+const MaxChirpLength = 1000
+
+// ForPlan returns the entitlements of the plan. Unknown plans get the free
+// plan, so a misconfigured subscription never grants more than it should.
+func ForPlan(plan string) Entitlements {
+	ent, ok := plans[plan]
+	if !ok {
+		return plans[PlanFree]
+	}
+	return ent
+}
+
+// IsPaidPlan reports whether the plan is one Polka can sell.
+func IsPaidPlan(plan string) bool {
+	_, ok := plans[plan]
+	return ok && plan != PlanFree
+}
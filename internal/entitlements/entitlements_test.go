@@ -0,0 +1,52 @@
+package entitlements
+
+import "testing"
+
+func TestForPlan(t *testing.T) {
+	tests := []struct {
+		plan       string
+		wantLength int
+		wantEdit   bool
+	}{
+		{PlanFree, 140, false},
+		{PlanRed, 280, true},
+		{PlanRedPlus, 1000, true},
+		{"", 140, false},
+		{"platinum", 140, false},
+	}
+
+	for _, tt := range tests {
+		ent := ForPlan(tt.plan)
+		if ent.MaxChirpLength != tt.wantLength {
+			t.Errorf("ForPlan(%q).MaxChirpLength = %d, want %d", tt.plan, ent.MaxChirpLength, tt.wantLength)
+		}
+		if (ent.EditWindow > 0) != tt.wantEdit {
+			t.Errorf("ForPlan(%q).EditWindow = %s, want editing %t", tt.plan, ent.EditWindow, tt.wantEdit)
+		}
+	}
+}
+
+func TestMaxChirpLength(t *testing.T) {
+	for plan, ent := range plans {
+		if ent.MaxChirpLength > MaxChirpLength {
+			t.Errorf("plan %s allows %d characters, more than MaxChirpLength", plan, ent.MaxChirpLength)
+		}
+		if ent.Plan != plan {
+			t.Errorf("plan %s is registered as %s", ent.Plan, plan)
+		}
+	}
+}
+
+func TestIsPaidPlan(t *testing.T) {
+	for plan, want := range map[string]bool{
+		PlanFree:    false,
+		PlanRed:     true,
+		PlanRedPlus: true,
+		"":          false,
+		"platinum":  false,
+	} {
+		if got := IsPaidPlan(plan); got != want {
+			t.Errorf("IsPaidPlan(%q) = %t, want %t", plan, got, want)
+		}
+	}
+}
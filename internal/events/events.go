@@ -0,0 +1,72 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Event is a domain event read from the outbox. Payload is the JSON the
+// event was recorded with.
+type Event struct {
+	ID        uuid.UUID
+	Seq       int64
+	Type      string
+	UserID    uuid.UUID
+	CreatedAt time.Time
+	Payload   []byte
+}
+
+// Handler reacts to an event. Events are delivered at least once, so
+// handlers must be idempotent; a handler error makes the relay deliver the
+// event again later, to every handler.
+type Handler func(ctx context.Context, event Event) error
+
+// Bus dispatches events to in-process subscribers.
+type Bus struct {
+	mu       sync.RWMutex
+	handlers map[string][]Handler
+}
+
+func NewBus() *Bus {
+	return &Bus{handlers: map[string][]Handler{}}
+}
+
+// Subscribe registers the handler for events of the given type. The type
+// "*" receives every event.
+func (b *Bus) Subscribe(eventType string, handler Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[eventType] = append(b.handlers[eventType], handler)
+}
+
+// Publish runs every handler of the event in the order they subscribed and
+// returns their joined errors. A panicking handler is reported as an error.
+func (b *Bus) Publish(ctx context.Context, event Event) error {
+	b.mu.RLock()
+	handlers := append([]Handler{}, b.handlers[event.Type]...)
+	handlers = append(handlers, b.handlers["*"]...)
+	b.mu.RUnlock()
+
+	errs := []error{}
+	for _, handler := range handlers {
+		err := runHandler(ctx, handler, event)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func runHandler(ctx context.Context, handler Handler, event Event) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler panicked on %s: %v", event.Type, r)
+		}
+	}()
+	return handler(ctx, event)
+}
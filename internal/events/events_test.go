@@ -0,0 +1,79 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestPublishDispatchesByType(t *testing.T) {
+	bus := NewBus()
+	calls := []string{}
+	bus.Subscribe("chirp.created", func(ctx context.Context, event Event) error {
+		calls = append(calls, "created")
+		return nil
+	})
+	bus.Subscribe("chirp.deleted", func(ctx context.Context, event Event) error {
+		calls = append(calls, "deleted")
+		return nil
+	})
+	bus.Subscribe("*", func(ctx context.Context, event Event) error {
+		calls = append(calls, "all:"+event.Type)
+		return nil
+	})
+
+	err := bus.Publish(context.Background(), Event{Type: "chirp.created"})
+	if err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+
+	want := []string{"created", "all:chirp.created"}
+	if len(calls) != len(want) {
+		t.Fatalf("calls = %v, want %v", calls, want)
+	}
+	for i := range want {
+		if calls[i] != want[i] {
+			t.Errorf("calls = %v, want %v", calls, want)
+		}
+	}
+}
+
+func TestPublishRunsAllHandlersOnError(t *testing.T) {
+	bus := NewBus()
+	errFirst := errors.New("first failed")
+	ran := false
+	bus.Subscribe("user.upgraded", func(ctx context.Context, event Event) error {
+		return errFirst
+	})
+	bus.Subscribe("user.upgraded", func(ctx context.Context, event Event) error {
+		ran = true
+		return nil
+	})
+
+	err := bus.Publish(context.Background(), Event{Type: "user.upgraded"})
+	if !errors.Is(err, errFirst) {
+		t.Errorf("got %v, want %v", err, errFirst)
+	}
+	if !ran {
+		t.Errorf("second handler did not run")
+	}
+}
+
+func TestPublishRecoversPanics(t *testing.T) {
+	bus := NewBus()
+	bus.Subscribe("chirp.created", func(ctx context.Context, event Event) error {
+		panic("boom")
+	})
+
+	err := bus.Publish(context.Background(), Event{Type: "chirp.created"})
+	if err == nil {
+		t.Errorf("expected the panic to be reported")
+	}
+}
+
+func TestPublishWithoutSubscribers(t *testing.T) {
+	err := NewBus().Publish(context.Background(), Event{Type: "chirp.created"})
+	if err != nil {
+		t.Errorf("got %v, want nil", err)
+	}
+}
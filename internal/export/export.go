@@ -0,0 +1,203 @@
+package export
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// Archive is everything Chirpy stores about a user, as handed out by the
+// data export. Every section is written both to data.json and to its own CSV
+// file so the archive is readable without tooling.
+type Archive struct {
+	Profile       Profile        `json:"profile"`
+	Chirps        []Chirp        `json:"chirps"`
+	Drafts        []Draft        `json:"drafts"`
+	Sessions      []Session      `json:"sessions"`
+	BillingEvents []BillingEvent `json:"billing_events"`
+	Messages      []Message      `json:"messages"`
+}
+
+type Profile struct {
+	ID          string    `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Email       string    `json:"email"`
+	Username    string    `json:"username"`
+	DisplayName string    `json:"display_name"`
+	Bio         string    `json:"bio"`
+	AvatarURL   string    `json:"avatar_url"`
+	IsChirpyRed bool      `json:"is_chirpy_red"`
+}
+
+// Chirp is any chirp of the user: published, scheduled to be published at
+// PublishAt, or deleted at DeletedAt and not yet purged.
+type Chirp struct {
+	ID          string     `json:"id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	Body        string     `json:"body"`
+	PublishAt   *time.Time `json:"publish_at"`
+	PublishedAt *time.Time `json:"published_at"`
+	EditedAt    *time.Time `json:"edited_at"`
+	DeletedAt   *time.Time `json:"deleted_at"`
+}
+
+type Draft struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Body      string    `json:"body"`
+}
+
+type Session struct {
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+	ClientID  string     `json:"client_id"`
+}
+
+type BillingEvent struct {
+	CreatedAt time.Time `json:"created_at"`
+	Event     string    `json:"event"`
+	Details   string    `json:"details"`
+}
+
+// Message is a direct message in one of the user's conversations, sent by
+// them or by another member.
+type Message struct {
+	ID             string    `json:"id"`
+	CreatedAt      time.Time `json:"created_at"`
+	ConversationID string    `json:"conversation_id"`
+	SenderID       string    `json:"sender_id"`
+	Body           string    `json:"body"`
+}
+
+func Write(w io.Writer, archive Archive) error {
+	zipWriter := zip.NewWriter(w)
+
+	file, err := zipWriter.Create("data.json")
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(archive)
+	if err != nil {
+		return err
+	}
+
+	err = writeCSV(zipWriter, "profile.csv",
+		[]string{"id", "created_at", "updated_at", "email", "username", "display_name", "bio", "avatar_url", "is_chirpy_red"},
+		[][]string{{
+			archive.Profile.ID,
+			formatTime(archive.Profile.CreatedAt),
+			formatTime(archive.Profile.UpdatedAt),
+			archive.Profile.Email,
+			archive.Profile.Username,
+			archive.Profile.DisplayName,
+			archive.Profile.Bio,
+			archive.Profile.AvatarURL,
+			formatBool(archive.Profile.IsChirpyRed),
+		}},
+	)
+	if err != nil {
+		return err
+	}
+
+	chirps := [][]string{}
+	for _, chirp := range archive.Chirps {
+		chirps = append(chirps, []string{
+			chirp.ID,
+			formatTime(chirp.CreatedAt),
+			formatTime(chirp.UpdatedAt),
+			chirp.Body,
+			formatOptionalTime(chirp.PublishAt),
+			formatOptionalTime(chirp.PublishedAt),
+			formatOptionalTime(chirp.EditedAt),
+			formatOptionalTime(chirp.DeletedAt),
+		})
+	}
+	err = writeCSV(zipWriter, "chirps.csv",
+		[]string{"id", "created_at", "updated_at", "body", "publish_at", "published_at", "edited_at", "deleted_at"}, chirps)
+	if err != nil {
+		return err
+	}
+
+	drafts := [][]string{}
+	for _, draft := range archive.Drafts {
+		drafts = append(drafts, []string{draft.ID, formatTime(draft.CreatedAt), formatTime(draft.UpdatedAt), draft.Body})
+	}
+	err = writeCSV(zipWriter, "drafts.csv", []string{"id", "created_at", "updated_at", "body"}, drafts)
+	if err != nil {
+		return err
+	}
+
+	sessions := [][]string{}
+	for _, session := range archive.Sessions {
+		sessions = append(sessions, []string{formatTime(session.CreatedAt), formatTime(session.ExpiresAt), formatOptionalTime(session.RevokedAt), session.ClientID})
+	}
+	err = writeCSV(zipWriter, "sessions.csv", []string{"created_at", "expires_at", "revoked_at", "client_id"}, sessions)
+	if err != nil {
+		return err
+	}
+
+	billingEvents := [][]string{}
+	for _, event := range archive.BillingEvents {
+		billingEvents = append(billingEvents, []string{formatTime(event.CreatedAt), event.Event, event.Details})
+	}
+	err = writeCSV(zipWriter, "billing_events.csv", []string{"created_at", "event", "details"}, billingEvents)
+	if err != nil {
+		return err
+	}
+
+	messages := [][]string{}
+	for _, message := range archive.Messages {
+		messages = append(messages, []string{message.ID, formatTime(message.CreatedAt), message.ConversationID, message.SenderID, message.Body})
+	}
+	err = writeCSV(zipWriter, "messages.csv", []string{"id", "created_at", "conversation_id", "sender_id", "body"}, messages)
+	if err != nil {
+		return err
+	}
+
+	return zipWriter.Close()
+}
+
+func writeCSV(zipWriter *zip.Writer, name string, header []string, records [][]string) error {
+	file, err := zipWriter.Create(name)
+	if err != nil {
+		return err
+	}
+
+	csvWriter := csv.NewWriter(file)
+	err = csvWriter.Write(header)
+	if err != nil {
+		return err
+	}
+	err = csvWriter.WriteAll(records)
+	if err != nil {
+		return err
+	}
+	return csvWriter.Error()
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// formatOptionalTime leaves the field empty for a missing time.
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return formatTime(*t)
+}
+
+func formatBool(b bool) string {
+	if b {
+		return "true"
+	}
+	return "false"
+}
@@ -0,0 +1,65 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestWrite(t *testing.T) {
+	archive := Archive{
+		Profile: Profile{ID: "60a9b112-00f4-46bb-9e33-9b4004349d62", Email: "user@example.com"},
+		Chirps: []Chirp{
+			{ID: "1", CreatedAt: time.Now(), Body: "Hello, \"world\""},
+			{ID: "2", CreatedAt: time.Now(), Body: "Second, chirp"},
+		},
+		Drafts:   []Draft{{ID: "6", CreatedAt: time.Now(), Body: "Not yet"}},
+		Sessions: []Session{{CreatedAt: time.Now(), ExpiresAt: time.Now()}},
+		Messages: []Message{{ID: "3", CreatedAt: time.Now(), ConversationID: "4", SenderID: "5", Body: "Hi,\nthere"}},
+	}
+
+	buf := bytes.Buffer{}
+	err := Write(&buf, archive)
+	if err != nil {
+		t.Errorf("Archive was not written: %v", err)
+		return
+	}
+
+	zipReader, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Errorf("Archive is not a valid zip: %v", err)
+		return
+	}
+
+	files := map[string]*zip.File{}
+	for _, file := range zipReader.File {
+		files[file.Name] = file
+	}
+	for _, name := range []string{"data.json", "profile.csv", "chirps.csv", "drafts.csv", "sessions.csv", "billing_events.csv", "messages.csv"} {
+		if files[name] == nil {
+			t.Errorf("Archive is missing %s", name)
+		}
+	}
+
+	data, _ := files["data.json"].Open()
+	decoded := Archive{}
+	err = json.NewDecoder(data).Decode(&decoded)
+	if err != nil || decoded.Profile.Email != "user@example.com" || len(decoded.Chirps) != 2 {
+		t.Errorf("Unexpected data.json content: %+v %v", decoded, err)
+	}
+
+	chirps, _ := files["chirps.csv"].Open()
+	records, err := csv.NewReader(chirps).ReadAll()
+	if err != nil || len(records) != 3 || records[1][3] != "Hello, \"world\"" {
+		t.Errorf("Unexpected chirps.csv content: %v %v", records, err)
+	}
+
+	messages, _ := files["messages.csv"].Open()
+	records, err = csv.NewReader(messages).ReadAll()
+	if err != nil || len(records) != 2 || records[1][4] != "Hi,\nthere" {
+		t.Errorf("Unexpected messages.csv content: %v %v", records, err)
+	}
+}
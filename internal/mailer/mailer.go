@@ -0,0 +1,48 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers e-mails on behalf of Chirpy. Implementations must be safe
+// for concurrent use.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// FileMailer is a local sink that writes every message to its own file in Dir
+// instead of delivering it. It is meant for development and tests.
+type FileMailer struct {
+	Dir string
+}
+
+func (m FileMailer) Send(ctx context.Context, msg Message) error {
+	err := os.MkdirAll(m.Dir, 0o755)
+	if err != nil {
+		return fmt.Errorf("unable to create mail directory: %w", err)
+	}
+
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405"), uuid.NewString())
+	content := strings.Join([]string{
+		"To: " + msg.To,
+		"Subject: " + msg.Subject,
+		"Date: " + time.Now().UTC().Format(time.RFC1123Z),
+		"",
+		msg.Body,
+	}, "\r\n")
+
+	return os.WriteFile(filepath.Join(m.Dir, name), []byte(content), 0o600)
+}
@@ -0,0 +1,30 @@
+package mailer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	m := FileMailer{Dir: filepath.Join(dir, "mail")}
+
+	err := m.Send(context.Background(), Message{To: "user@example.com", Subject: "Hello", Body: "Link"})
+	if err != nil {
+		t.Errorf("Message was not sent: %v", err)
+		return
+	}
+
+	files, _ := os.ReadDir(m.Dir)
+	if len(files) != 1 {
+		t.Fatalf("Expected one message, found %d", len(files))
+	}
+
+	content, _ := os.ReadFile(filepath.Join(m.Dir, files[0].Name()))
+	if !strings.Contains(string(content), "To: user@example.com") || !strings.HasSuffix(string(content), "Link") {
+		t.Errorf("Unexpected message content: %s", content)
+	}
+}
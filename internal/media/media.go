@@ -0,0 +1,258 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+const (
+	MaxUploadSize = 5 << 20
+	MaxDimension  = 8000
+	// MaxPixels bounds the memory a decoded upload takes, about 64 MB as
+	// RGBA, since a small compressed file can decode to a huge image.
+	MaxPixels       = 16_000_000
+	ThumbnailSize   = 320
+	jpegQuality     = 90
+	thumbnailFormat = "image/jpeg"
+)
+
+// Processed is an upload that passed validation. Data and Thumbnail are fresh
+// encodings of the decoded pixels, so no metadata of the original file (EXIF,
+// GPS position, comments) survives.
+type Processed struct {
+	ContentType   string
+	Width         int
+	Height        int
+	Data          []byte
+	Thumbnail     []byte
+	ThumbnailType string
+}
+
+// Process sniffs the content type from the data itself, ignoring whatever the
+// client claimed, and re-encodes the image. GIFs are flattened to their first
+// frame and stored as PNG, and JPEGs are turned upright according to their
+// EXIF orientation.
+func Process(data []byte) (Processed, error) {
+	contentType := http.DetectContentType(data)
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif":
+	default:
+		return Processed{}, fmt.Errorf("unsupported media type: %s", contentType)
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return Processed{}, fmt.Errorf("unable to read image: %w", err)
+	}
+	if config.Width > MaxDimension || config.Height > MaxDimension || config.Width*config.Height > MaxPixels {
+		return Processed{}, fmt.Errorf("image too large: %dx%d", config.Width, config.Height)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Processed{}, fmt.Errorf("unable to decode image: %w", err)
+	}
+	// The EXIF Orientation tag is dropped with the rest of the metadata, so
+	// it is applied to the pixels first.
+	if contentType == "image/jpeg" {
+		img = Orient(img, exifOrientation(data))
+	}
+
+	processed := Processed{
+		ContentType:   contentType,
+		Width:         img.Bounds().Dx(),
+		Height:        img.Bounds().Dy(),
+		ThumbnailType: thumbnailFormat,
+	}
+	if contentType == "image/gif" {
+		processed.ContentType = "image/png"
+	}
+
+	processed.Data, err = encode(img, processed.ContentType)
+	if err != nil {
+		return Processed{}, err
+	}
+
+	processed.Thumbnail, err = encode(Thumbnail(img, ThumbnailSize), thumbnailFormat)
+	if err != nil {
+		return Processed{}, err
+	}
+
+	return processed, nil
+}
+
+func encode(img image.Image, contentType string) ([]byte, error) {
+	buf := bytes.Buffer{}
+	var err error
+	switch contentType {
+	case "image/jpeg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality})
+	case "image/png":
+		err = png.Encode(&buf, img)
+	default:
+		err = fmt.Errorf("unsupported output type: %s", contentType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Thumbnail scales img down so that its longer side is at most maxSize,
+// averaging the source pixels that fall into each target pixel. Transparent
+// areas are flattened onto white since thumbnails are encoded as JPEG.
+func Thumbnail(img image.Image, maxSize int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	scale := 1.0
+	if width > maxSize || height > maxSize {
+		scale = float64(maxSize) / float64(max(width, height))
+	}
+	thumbWidth := max(1, int(float64(width)*scale))
+	thumbHeight := max(1, int(float64(height)*scale))
+
+	thumb := image.NewRGBA(image.Rect(0, 0, thumbWidth, thumbHeight))
+	for y := 0; y < thumbHeight; y++ {
+		y0 := bounds.Min.Y + y*height/thumbHeight
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*height/thumbHeight)
+		for x := 0; x < thumbWidth; x++ {
+			x0 := bounds.Min.X + x*width/thumbWidth
+			x1 := max(x0+1, bounds.Min.X+(x+1)*width/thumbWidth)
+
+			var r, g, b, count uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					// Composite premultiplied colour onto a white background.
+					r += uint64(cr + 0xffff - ca)
+					g += uint64(cg + 0xffff - ca)
+					b += uint64(cb + 0xffff - ca)
+					count++
+				}
+			}
+			thumb.Set(x, y, color.RGBA64{
+				R: uint16(r / count),
+				G: uint16(g / count),
+				B: uint16(b / count),
+				A: 0xffff,
+			})
+		}
+	}
+
+	return thumb
+}
+
+// Orient returns img as it is meant to be displayed given its EXIF
+// orientation (1 to 8). Other values leave the image unchanged.
+func Orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	outWidth, outHeight := width, height
+	if orientation >= 5 {
+		outWidth, outHeight = height, width
+	}
+
+	out := image.NewRGBA(image.Rect(0, 0, outWidth, outHeight))
+	for y := 0; y < outHeight; y++ {
+		for x := 0; x < outWidth; x++ {
+			// Source pixel of each output pixel.
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = width-1-x, y
+			case 3:
+				sx, sy = width-1-x, height-1-y
+			case 4:
+				sx, sy = x, height-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, height-1-x
+			case 7:
+				sx, sy = width-1-y, height-1-x
+			case 8:
+				sx, sy = width-1-y, x
+			}
+			out.Set(x, y, img.At(bounds.Min.X+sx, bounds.Min.Y+sy))
+		}
+	}
+	return out
+}
+
+// exifOrientation reads the Orientation tag from the EXIF segment of a JPEG.
+// It returns 1, the upright orientation, when there is none.
+func exifOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return 1
+	}
+
+	for i := 2; i+4 <= len(data) && data[i] == 0xff; {
+		marker := data[i+1]
+		// The image data starts at SOS; metadata segments come before it.
+		if marker == 0xda || marker == 0xd9 {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			break
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return tiffOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+// tiffOrientation looks up tag 0x0112 in the first IFD of a TIFF header.
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	if order.Uint16(tiff[2:]) != 42 {
+		return 1
+	}
+
+	offset := int(order.Uint32(tiff[4:]))
+	if offset < 8 || offset+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < count; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		// A SHORT value is stored in the first two bytes of the value field.
+		if order.Uint16(tiff[entry:]) == 0x0112 && order.Uint16(tiff[entry+2:]) == 3 {
+			orientation := int(order.Uint16(tiff[entry+8:]))
+			if orientation >= 1 && orientation <= 8 {
+				return orientation
+			}
+			return 1
+		}
+	}
+	return 1
+}
@@ -0,0 +1,195 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+	"testing"
+)
+
+func testImage(width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	return img
+}
+
+func TestProcessStripsExif(t *testing.T) {
+	buf := bytes.Buffer{}
+	jpeg.Encode(&buf, testImage(64, 48), nil)
+	original := buf.Bytes()
+
+	// Insert an APP1 EXIF segment right after the SOI marker.
+	exif := append([]byte{0xff, 0xe1, 0x00, 0x10}, []byte("Exif\x00\x00GPSDATA!!")...)
+	withExif := append(append(append([]byte{}, original[:2]...), exif...), original[2:]...)
+
+	processed, err := Process(withExif)
+	if err != nil {
+		t.Errorf("Image was not processed: %v", err)
+		return
+	}
+
+	if processed.ContentType != "image/jpeg" || processed.Width != 64 || processed.Height != 48 {
+		t.Errorf("Unexpected image metadata: %+v", processed)
+	}
+	if bytes.Contains(processed.Data, []byte("Exif")) || bytes.Contains(processed.Data, []byte("GPSDATA")) {
+		t.Fatal("EXIF metadata survived processing!")
+	}
+}
+
+func TestProcessThumbnail(t *testing.T) {
+	buf := bytes.Buffer{}
+	png.Encode(&buf, testImage(1000, 500))
+
+	processed, err := Process(buf.Bytes())
+	if err != nil {
+		t.Errorf("Image was not processed: %v", err)
+		return
+	}
+
+	thumb, err := jpeg.Decode(bytes.NewReader(processed.Thumbnail))
+	if err != nil {
+		t.Errorf("Thumbnail is not a JPEG: %v", err)
+		return
+	}
+	if thumb.Bounds().Dx() != ThumbnailSize || thumb.Bounds().Dy() != ThumbnailSize/2 {
+		t.Errorf("Unexpected thumbnail size: %v", thumb.Bounds())
+	}
+}
+
+// withOrientation inserts an EXIF segment carrying only the Orientation tag
+// right after the SOI marker of a JPEG.
+func withOrientation(original []byte, orientation uint16) []byte {
+	tiff := []byte("II*\x00\x08\x00\x00\x00\x01\x00")
+	tiff = binary.LittleEndian.AppendUint16(tiff, 0x0112)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 3)
+	tiff = binary.LittleEndian.AppendUint32(tiff, 1)
+	tiff = binary.LittleEndian.AppendUint32(tiff, uint32(orientation))
+	tiff = binary.LittleEndian.AppendUint32(tiff, 0)
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	segment := binary.BigEndian.AppendUint16([]byte{0xff, 0xe1}, uint16(len(payload)+2))
+	segment = append(segment, payload...)
+	return append(append(append([]byte{}, original[:2]...), segment...), original[2:]...)
+}
+
+func TestProcessAppliesOrientation(t *testing.T) {
+	buf := bytes.Buffer{}
+	jpeg.Encode(&buf, testImage(64, 48), nil)
+
+	processed, err := Process(withOrientation(buf.Bytes(), 6))
+	if err != nil {
+		t.Errorf("Image was not processed: %v", err)
+		return
+	}
+	if processed.Width != 48 || processed.Height != 64 {
+		t.Errorf("Expected a 48x64 image, got %dx%d", processed.Width, processed.Height)
+	}
+	if bytes.Contains(processed.Data, []byte("Exif")) {
+		t.Fatal("EXIF metadata survived processing!")
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// A 3x2 image whose pixels are numbered 0 to 5, row by row.
+	img := image.NewGray(image.Rect(0, 0, 3, 2))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+
+	cases := []struct {
+		orientation int
+		want        [][]uint8
+	}{
+		{orientation: 1, want: [][]uint8{{0, 1, 2}, {3, 4, 5}}},
+		{orientation: 2, want: [][]uint8{{2, 1, 0}, {5, 4, 3}}},
+		{orientation: 3, want: [][]uint8{{5, 4, 3}, {2, 1, 0}}},
+		{orientation: 4, want: [][]uint8{{3, 4, 5}, {0, 1, 2}}},
+		{orientation: 5, want: [][]uint8{{0, 3}, {1, 4}, {2, 5}}},
+		{orientation: 6, want: [][]uint8{{3, 0}, {4, 1}, {5, 2}}},
+		{orientation: 7, want: [][]uint8{{5, 2}, {4, 1}, {3, 0}}},
+		{orientation: 8, want: [][]uint8{{2, 5}, {1, 4}, {0, 3}}},
+		{orientation: 9, want: [][]uint8{{0, 1, 2}, {3, 4, 5}}},
+	}
+
+	for _, c := range cases {
+		out := Orient(img, c.orientation)
+		if out.Bounds().Dx() != len(c.want[0]) || out.Bounds().Dy() != len(c.want) {
+			t.Errorf("Orientation %d: unexpected size %v", c.orientation, out.Bounds())
+			continue
+		}
+		for y, row := range c.want {
+			for x, want := range row {
+				got := color.GrayModel.Convert(out.At(x, y)).(color.Gray).Y
+				if got != want {
+					t.Errorf("Orientation %d: expected %d at %d,%d, got %d", c.orientation, want, x, y, got)
+				}
+			}
+		}
+	}
+}
+
+func TestProcessRejectsTooManyPixels(t *testing.T) {
+	buf := bytes.Buffer{}
+	png.Encode(&buf, testImage(1, 1))
+	data := buf.Bytes()
+
+	// Claim 5000x4000 in the IHDR chunk, within MaxDimension on both sides.
+	binary.BigEndian.PutUint32(data[16:], 5000)
+	binary.BigEndian.PutUint32(data[20:], 4000)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+
+	_, err := Process(data)
+	if err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Fatalf("Image over MaxPixels was not rejected as too large: %v", err)
+	}
+}
+
+func TestProcessRejectsNonImages(t *testing.T) {
+	_, err := Process([]byte("<html><body>not an image</body></html>"))
+	if err == nil {
+		t.Fatal("HTML was accepted as an image!")
+	}
+}
+
+func TestLocalBlobStore(t *testing.T) {
+	store := LocalBlobStore{Dir: t.TempDir()}
+	ctx := context.Background()
+
+	err := store.Put(ctx, "user/blob.png", bytes.NewReader([]byte("blob")))
+	if err != nil {
+		t.Errorf("Blob was not stored: %v", err)
+		return
+	}
+
+	reader, err := store.Open(ctx, "user/blob.png")
+	if err != nil {
+		t.Errorf("Blob was not opened: %v", err)
+		return
+	}
+	data, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(data) != "blob" {
+		t.Errorf("Unexpected blob content: %s", data)
+	}
+
+	err = store.Delete(ctx, "user/blob.png")
+	if err != nil {
+		t.Errorf("Blob was not deleted: %v", err)
+	}
+
+	err = store.Put(ctx, "../escape", bytes.NewReader([]byte("blob")))
+	if err == nil {
+		t.Fatal("Blob key escaping the store was accepted!")
+	}
+}
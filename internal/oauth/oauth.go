@@ -0,0 +1,80 @@
+package oauth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	ScopeChirpsRead    = "chirps:read"
+	ScopeChirpsWrite   = "chirps:write"
+	ScopeProfile       = "profile"
+	ScopeMessages      = "messages"
+	ScopeNotifications = "notifications"
+	ScopeWebhooks      = "webhooks"
+)
+
+// SupportedScopes lists every scope a client may register for.
+var SupportedScopes = []string{
+	ScopeChirpsRead,
+	ScopeChirpsWrite,
+	ScopeProfile,
+	ScopeMessages,
+	ScopeNotifications,
+	ScopeWebhooks,
+}
+
+func MakeRandomString(length int) (string, error) {
+	raw := make([]byte, length)
+	_, err := rand.Read(raw)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// MakeCodeChallenge derives the S256 code challenge for a PKCE verifier.
+func MakeCodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func VerifyPKCE(verifier, challenge string) error {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return fmt.Errorf("invalid code verifier length: %d", len(verifier))
+	}
+	expected := MakeCodeChallenge(verifier)
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) != 1 {
+		return fmt.Errorf("code verifier does not match the challenge")
+	}
+	return nil
+}
+
+// NormalizeScope checks every requested scope against the allowed ones and
+// returns them de-duplicated in a stable order. An empty request grants all
+// allowed scopes.
+func NormalizeScope(requested, allowed string) (string, error) {
+	allowedScopes := strings.Fields(allowed)
+	requestedScopes := strings.Fields(requested)
+	if len(requestedScopes) == 0 {
+		requestedScopes = allowedScopes
+	}
+
+	granted := []string{}
+	for _, scope := range requestedScopes {
+		if !slices.Contains(SupportedScopes, scope) || !slices.Contains(allowedScopes, scope) {
+			return "", fmt.Errorf("invalid scope: %s", scope)
+		}
+		if !slices.Contains(granted, scope) {
+			granted = append(granted, scope)
+		}
+	}
+	slices.Sort(granted)
+
+	return strings.Join(granted, " "), nil
+}
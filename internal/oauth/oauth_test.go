@@ -0,0 +1,66 @@
+package oauth
+
+import (
+	"testing"
+)
+
+func TestVerifyPKCE(t *testing.T) {
+	verifier, err := MakeRandomString(32)
+	if err != nil {
+		t.Errorf("Verifier was not created: %v", err)
+		return
+	}
+
+	err = VerifyPKCE(verifier, MakeCodeChallenge(verifier))
+	if err != nil {
+		t.Errorf("Verifier was not accepted: %v", err)
+		return
+	}
+}
+
+func TestRejectWrongVerifier(t *testing.T) {
+	verifier, _ := MakeRandomString(32)
+	otherVerifier, _ := MakeRandomString(32)
+
+	err := VerifyPKCE(otherVerifier, MakeCodeChallenge(verifier))
+	if err == nil {
+		t.Fatal("Wrong verifier was accepted!")
+	}
+}
+
+func TestRejectShortVerifier(t *testing.T) {
+	verifier := "tooShort"
+
+	err := VerifyPKCE(verifier, MakeCodeChallenge(verifier))
+	if err == nil {
+		t.Fatal("Short verifier was accepted!")
+	}
+}
+
+func TestNormalizeScope(t *testing.T) {
+	allowed := "chirps:read chirps:write profile"
+
+	scope, err := NormalizeScope("profile chirps:read profile", allowed)
+	if err != nil {
+		t.Errorf("Scope was not accepted: %v", err)
+		return
+	}
+	if scope != "chirps:read profile" {
+		t.Errorf("Unexpected scope: %v", scope)
+	}
+
+	scope, _ = NormalizeScope("", "chirps:read")
+	if scope != "chirps:read" {
+		t.Errorf("Empty request did not grant allowed scope: %v", scope)
+	}
+
+	_, err = NormalizeScope("chirps:write", "chirps:read")
+	if err == nil {
+		t.Fatal("Scope outside of the allowed set was accepted!")
+	}
+
+	_, err = NormalizeScope("admin", "admin")
+	if err == nil {
+		t.Fatal("Unsupported scope was accepted!")
+	}
+}
@@ -0,0 +1,228 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Provider is an external OpenID Connect identity provider configured through
+// discovery. It implements the authorization code flow for a single client.
+type Provider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+
+	ClientID     string `json:"-"`
+	ClientSecret string `json:"-"`
+	RedirectURL  string `json:"-"`
+
+	httpClient *http.Client
+	keysMu     sync.Mutex
+	keys       map[string]*rsa.PublicKey
+}
+
+type IDTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce         string `json:"nonce"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IDToken     string `json:"id_token"`
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+func Discover(ctx context.Context, issuer, clientID, clientSecret, redirectURL string) (*Provider, error) {
+	provider := &Provider{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		httpClient:   &http.Client{Timeout: 10 * time.Second},
+	}
+
+	wellKnown := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	err := provider.getJSON(ctx, wellKnown, provider)
+	if err != nil {
+		return nil, fmt.Errorf("unable to discover provider: %w", err)
+	}
+
+	if provider.Issuer != issuer {
+		return nil, fmt.Errorf("issuer mismatch: expected %s, got %s", issuer, provider.Issuer)
+	}
+
+	return provider, nil
+}
+
+func (p *Provider) AuthCodeURL(state, nonce string) string {
+	return p.AuthorizationEndpoint + "?" + url.Values{
+		"response_type": {"code"},
+		"client_id":     {p.ClientID},
+		"redirect_uri":  {p.RedirectURL},
+		"scope":         {"openid email"},
+		"state":         {state},
+		"nonce":         {nonce},
+	}.Encode()
+}
+
+// Exchange trades an authorization code for tokens and returns the verified
+// claims of the ID token.
+func (p *Provider) Exchange(ctx context.Context, code, nonce string) (IDTokenClaims, error) {
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {p.RedirectURL},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return IDTokenClaims{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return IDTokenClaims{}, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return IDTokenClaims{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return IDTokenClaims{}, fmt.Errorf("token endpoint returned %d: %s", resp.StatusCode, data)
+	}
+
+	token := tokenResponse{}
+	err = json.Unmarshal(data, &token)
+	if err != nil {
+		return IDTokenClaims{}, err
+	}
+	if token.IDToken == "" {
+		return IDTokenClaims{}, fmt.Errorf("token response has no id_token")
+	}
+
+	return p.VerifyIDToken(ctx, token.IDToken, nonce)
+}
+
+func (p *Provider) VerifyIDToken(ctx context.Context, rawIDToken, nonce string) (IDTokenClaims, error) {
+	claims := IDTokenClaims{}
+	_, err := jwt.ParseWithClaims(rawIDToken, &claims,
+		func(token *jwt.Token) (interface{}, error) {
+			kid, _ := token.Header["kid"].(string)
+			return p.publicKey(ctx, kid)
+		},
+		jwt.WithValidMethods([]string{"RS256"}),
+		jwt.WithIssuer(p.Issuer),
+		jwt.WithAudience(p.ClientID),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return IDTokenClaims{}, err
+	}
+
+	if claims.Nonce != nonce {
+		return IDTokenClaims{}, fmt.Errorf("id token nonce mismatch")
+	}
+	if claims.Subject == "" {
+		return IDTokenClaims{}, fmt.Errorf("id token has no subject")
+	}
+
+	return claims, nil
+}
+
+// publicKey looks the key up in the cached key set and refetches the JWKS
+// once when the key is unknown, which covers provider key rotation.
+func (p *Provider) publicKey(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	p.keysMu.Lock()
+	defer p.keysMu.Unlock()
+
+	key, ok := p.keys[kid]
+	if ok {
+		return key, nil
+	}
+
+	keySet := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	err := p.getJSON(ctx, p.JWKSURI, &keySet)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch JWKS: %w", err)
+	}
+
+	p.keys = map[string]*rsa.PublicKey{}
+	for _, jwk := range keySet.Keys {
+		if jwk.Kty != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		publicKey, err := parseRSAKey(jwk)
+		if err != nil {
+			return nil, err
+		}
+		p.keys[jwk.Kid] = publicKey
+	}
+
+	key, ok = p.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key: %s", kid)
+	}
+	return key, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, target string, respBody interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %d", target, resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(respBody)
+}
+
+func parseRSAKey(jwk jsonWebKey) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key modulus: %w", err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key exponent: %w", err)
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}, nil
+}
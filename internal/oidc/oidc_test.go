@@ -0,0 +1,175 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// fakeProvider is an in-process OpenID Connect provider that issues an ID
+// token for whatever claims the test sets before the code exchange.
+type fakeProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	claims IDTokenClaims
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Key was not created: %v", err)
+	}
+	fake := &fakeProvider{key: key}
+
+	serveMux := http.NewServeMux()
+	serveMux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 fake.server.URL,
+			"authorization_endpoint": fake.server.URL + "/authorize",
+			"token_endpoint":         fake.server.URL + "/token",
+			"jwks_uri":               fake.server.URL + "/jwks",
+		})
+	})
+	serveMux.HandleFunc("GET /jwks", func(w http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test-key",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	serveMux.HandleFunc("POST /token", func(w http.ResponseWriter, req *http.Request) {
+		clientID, clientSecret, ok := req.BasicAuth()
+		if !ok || clientID != "chirpy" || clientSecret != "s3cret" || req.FormValue("code") != "good-code" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"id_token":     fake.sign(t, fake.claims),
+		})
+	})
+	fake.server = httptest.NewServer(serveMux)
+	t.Cleanup(fake.server.Close)
+
+	return fake
+}
+
+func (fake *fakeProvider) sign(t *testing.T, claims IDTokenClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "test-key"
+	signedToken, err := token.SignedString(fake.key)
+	if err != nil {
+		t.Fatalf("ID token was not signed: %v", err)
+	}
+	return signedToken
+}
+
+func (fake *fakeProvider) validClaims(nonce string) IDTokenClaims {
+	return IDTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    fake.server.URL,
+			Subject:   "external-user-1",
+			Audience:  jwt.ClaimStrings{"chirpy"},
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+		Nonce:         nonce,
+		Email:         "user@example.com",
+		EmailVerified: true,
+	}
+}
+
+func TestExchange(t *testing.T) {
+	fake := newFakeProvider(t)
+	provider, err := Discover(context.Background(), fake.server.URL, "chirpy", "s3cret", "http://localhost/callback")
+	if err != nil {
+		t.Fatalf("Provider was not discovered: %v", err)
+	}
+
+	fake.claims = fake.validClaims("nonce-1")
+	claims, err := provider.Exchange(context.Background(), "good-code", "nonce-1")
+	if err != nil {
+		t.Fatalf("Code was not exchanged: %v", err)
+	}
+
+	if claims.Subject != "external-user-1" || claims.Email != "user@example.com" || !claims.EmailVerified {
+		t.Errorf("Unexpected claims: %+v", claims)
+	}
+}
+
+func TestRejectWrongNonce(t *testing.T) {
+	fake := newFakeProvider(t)
+	provider, _ := Discover(context.Background(), fake.server.URL, "chirpy", "s3cret", "http://localhost/callback")
+
+	fake.claims = fake.validClaims("nonce-1")
+	_, err := provider.Exchange(context.Background(), "good-code", "nonce-2")
+	if err == nil {
+		t.Fatal("ID token with wrong nonce was accepted!")
+	}
+}
+
+func TestRejectWrongAudience(t *testing.T) {
+	fake := newFakeProvider(t)
+	provider, _ := Discover(context.Background(), fake.server.URL, "chirpy", "s3cret", "http://localhost/callback")
+
+	claims := fake.validClaims("nonce-1")
+	claims.Audience = jwt.ClaimStrings{"someone-else"}
+
+	_, err := provider.VerifyIDToken(context.Background(), fake.sign(t, claims), "nonce-1")
+	if err == nil {
+		t.Fatal("ID token for another audience was accepted!")
+	}
+}
+
+func TestRejectExpiredToken(t *testing.T) {
+	fake := newFakeProvider(t)
+	provider, _ := Discover(context.Background(), fake.server.URL, "chirpy", "s3cret", "http://localhost/callback")
+
+	claims := fake.validClaims("nonce-1")
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+
+	_, err := provider.VerifyIDToken(context.Background(), fake.sign(t, claims), "nonce-1")
+	if err == nil {
+		t.Fatal("Expired ID token was accepted!")
+	}
+}
+
+func TestRejectForeignKey(t *testing.T) {
+	fake := newFakeProvider(t)
+	provider, _ := Discover(context.Background(), fake.server.URL, "chirpy", "s3cret", "http://localhost/callback")
+
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, fake.validClaims("nonce-1"))
+	token.Header["kid"] = "test-key"
+	signedToken, _ := token.SignedString(otherKey)
+
+	_, err := provider.VerifyIDToken(context.Background(), signedToken, "nonce-1")
+	if err == nil {
+		t.Fatal("ID token signed with a foreign key was accepted!")
+	}
+}
+
+func TestRejectBadCode(t *testing.T) {
+	fake := newFakeProvider(t)
+	provider, _ := Discover(context.Background(), fake.server.URL, "chirpy", "s3cret", "http://localhost/callback")
+
+	fake.claims = fake.validClaims("nonce-1")
+	_, err := provider.Exchange(context.Background(), "bad-code", "nonce-1")
+	if err == nil {
+		t.Fatal("Bad code was exchanged!")
+	}
+}
@@ -0,0 +1,146 @@
+package stream
+
+import (
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// Message is an event pushed to connected clients. ID is the sequence number
+// of the outbox event it came from; clients send the last one they saw back
+// to resume. Topics name the channels the message belongs to, for clients
+// that subscribe to some of them.
+type Message struct {
+	ID     int64
+	Type   string
+	UserID uuid.UUID
+	Topics []string
+	Data   []byte
+}
+
+// Filter decides whether a client receives a message. Filters run while the
+// hub is locked and must not block.
+type Filter func(msg Message) bool
+
+// Client is a single connection subscribed to a hub.
+type Client struct {
+	messages chan Message
+	filter   Filter
+	dropped  chan struct{}
+}
+
+// Messages delivers the messages that passed the filter of the client.
+func (c *Client) Messages() <-chan Message {
+	return c.messages
+}
+
+// Dropped is closed when the hub gave up on the client because its buffer
+// was full, or when the hub was closed.
+func (c *Client) Dropped() <-chan struct{} {
+	return c.dropped
+}
+
+// The hub remembers this many message IDs to ignore messages published
+// again.
+const recentMessages = 1024
+
+// Hub fans messages out to the clients connected to this instance. Publish
+// never blocks: a client that does not keep up is dropped and is expected to
+// reconnect and resume from the last message it received.
+type Hub struct {
+	mu      sync.Mutex
+	clients map[*Client]bool
+	closed  bool
+	seen    map[int64]bool
+	recent  []int64
+	next    int
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		clients: map[*Client]bool{},
+		seen:    map[int64]bool{},
+		recent:  make([]int64, 0, recentMessages),
+	}
+}
+
+// Subscribe registers a client that buffers up to buffer messages. A nil
+// filter accepts every message.
+func (h *Hub) Subscribe(buffer int, filter Filter) *Client {
+	client := &Client{
+		messages: make(chan Message, buffer),
+		filter:   filter,
+		dropped:  make(chan struct{}),
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		close(client.dropped)
+		return client
+	}
+	h.clients[client] = true
+	return client
+}
+
+// Unsubscribe removes the client. It is safe to call for a dropped client.
+func (h *Hub) Unsubscribe(client *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.clients, client)
+}
+
+// Publish hands the message to every client whose filter accepts it. A
+// message whose ID was published recently is ignored, as events can be
+// relayed more than once.
+func (h *Hub) Publish(msg Message) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.seen[msg.ID] {
+		return
+	}
+	h.remember(msg.ID)
+
+	for client := range h.clients {
+		if client.filter != nil && !client.filter(msg) {
+			continue
+		}
+		select {
+		case client.messages <- msg:
+		default:
+			delete(h.clients, client)
+			close(client.dropped)
+		}
+	}
+}
+
+// Close drops every client and refuses new ones.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for client := range h.clients {
+		delete(h.clients, client)
+		close(client.dropped)
+	}
+}
+
+// Len returns the number of connected clients.
+func (h *Hub) Len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.clients)
+}
+
+func (h *Hub) remember(id int64) {
+	if len(h.recent) < recentMessages {
+		h.recent = append(h.recent, id)
+	} else {
+		delete(h.seen, h.recent[h.next])
+		h.recent[h.next] = id
+		h.next = (h.next + 1) % recentMessages
+	}
+	h.seen[id] = true
+}
@@ -0,0 +1,38 @@
+package stream
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// WriteEvent writes the message as a server-sent event with its ID, type and
+// data. Data spanning several lines is sent as several data fields.
+func WriteEvent(w io.Writer, msg Message) error {
+	buf := bytes.Buffer{}
+	fmt.Fprintf(&buf, "id: %d\nevent: %s\n", msg.ID, msg.Type)
+	for _, line := range bytes.Split(msg.Data, []byte("\n")) {
+		buf.WriteString("data: ")
+		buf.Write(bytes.TrimSuffix(line, []byte("\r")))
+		buf.WriteString("\n")
+	}
+	buf.WriteString("\n")
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// WriteComment writes a comment line, which clients ignore. It keeps idle
+// connections from being closed by proxies.
+func WriteComment(w io.Writer, comment string) error {
+	_, err := io.WriteString(w, ": "+strings.ReplaceAll(comment, "\n", " ")+"\n\n")
+	return err
+}
+
+// WriteRetry tells the client how many milliseconds to wait before it
+// reconnects.
+func WriteRetry(w io.Writer, milliseconds int) error {
+	_, err := fmt.Fprintf(w, "retry: %d\n\n", milliseconds)
+	return err
+}
@@ -0,0 +1,118 @@
+package stream
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestPublishAppliesFilter(t *testing.T) {
+	hub := NewHub()
+	userID := uuid.New()
+	mine := hub.Subscribe(4, func(msg Message) bool { return msg.UserID == userID })
+	all := hub.Subscribe(4, nil)
+
+	hub.Publish(Message{ID: 1, Type: "chirp.created", UserID: uuid.New()})
+	hub.Publish(Message{ID: 2, Type: "chirp.created", UserID: userID})
+
+	if len(all.Messages()) != 2 {
+		t.Errorf("unfiltered client got %d messages, want 2", len(all.Messages()))
+	}
+	if len(mine.Messages()) != 1 {
+		t.Fatalf("filtered client got %d messages, want 1", len(mine.Messages()))
+	}
+	if msg := <-mine.Messages(); msg.ID != 2 {
+		t.Errorf("got message %d, want 2", msg.ID)
+	}
+}
+
+func TestPublishDropsSlowClients(t *testing.T) {
+	hub := NewHub()
+	slow := hub.Subscribe(1, nil)
+	fast := hub.Subscribe(4, nil)
+
+	hub.Publish(Message{ID: 1})
+	hub.Publish(Message{ID: 2})
+
+	select {
+	case <-slow.Dropped():
+	default:
+		t.Errorf("slow client was not dropped")
+	}
+	select {
+	case <-fast.Dropped():
+		t.Errorf("fast client was dropped")
+	default:
+	}
+	if hub.Len() != 1 {
+		t.Errorf("hub has %d clients, want 1", hub.Len())
+	}
+
+	// Unsubscribing a dropped client must not panic.
+	hub.Unsubscribe(slow)
+}
+
+func TestPublishIgnoresRepeatedIDs(t *testing.T) {
+	hub := NewHub()
+	client := hub.Subscribe(recentMessages+2, nil)
+
+	hub.Publish(Message{ID: 1})
+	hub.Publish(Message{ID: 1})
+	if len(client.Messages()) != 1 {
+		t.Fatalf("got %d messages, want 1", len(client.Messages()))
+	}
+
+	// Once enough newer messages were published, the ID is forgotten.
+	for id := int64(2); id <= recentMessages+1; id++ {
+		hub.Publish(Message{ID: id})
+	}
+	hub.Publish(Message{ID: 1})
+	if len(client.Messages()) != recentMessages+2 {
+		t.Errorf("got %d messages, want %d", len(client.Messages()), recentMessages+2)
+	}
+}
+
+func TestCloseDropsClients(t *testing.T) {
+	hub := NewHub()
+	client := hub.Subscribe(1, nil)
+	hub.Close()
+
+	select {
+	case <-client.Dropped():
+	default:
+		t.Errorf("client was not dropped")
+	}
+
+	late := hub.Subscribe(1, nil)
+	select {
+	case <-late.Dropped():
+	default:
+		t.Errorf("client subscribed after Close was not dropped")
+	}
+}
+
+func TestWriteEvent(t *testing.T) {
+	buf := bytes.Buffer{}
+	err := WriteEvent(&buf, Message{ID: 42, Type: "chirp.created", Data: []byte("{\"a\":1}\r\n{\"b\":2}")})
+	if err != nil {
+		t.Fatalf("WriteEvent failed: %v", err)
+	}
+
+	want := "id: 42\nevent: chirp.created\ndata: {\"a\":1}\ndata: {\"b\":2}\n\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestWriteComment(t *testing.T) {
+	buf := bytes.Buffer{}
+	err := WriteComment(&buf, "ping\nping")
+	if err != nil {
+		t.Fatalf("WriteComment failed: %v", err)
+	}
+
+	if buf.String() != ": ping ping\n\n" {
+		t.Errorf("got %q", buf.String())
+	}
+}
@@ -0,0 +1,195 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+
+	"github.com/lighthoof/Chirpy/internal/auth"
+)
+
+const (
+	SignatureHeader = "Chirpy-Signature"
+	EventHeader     = "Chirpy-Event"
+	DeliveryHeader  = "Chirpy-Delivery"
+
+	// MaxAttempts is the number of deliveries tried before a delivery is
+	// dead-lettered.
+	MaxAttempts = 8
+
+	baseBackoff     = 30 * time.Second
+	maxBackoff      = 6 * time.Hour
+	sendTimeout     = 10 * time.Second
+	maxResponseRead = 64 << 10
+)
+
+// ErrForbiddenAddress is returned when a receiver resolves to an address
+// deliveries may not be sent to.
+var ErrForbiddenAddress = errors.New("receiver address is not allowed")
+
+// Error categories stored with failed deliveries. Receivers see them in the
+// delivery log, so they never include the underlying error.
+const (
+	ErrorForbiddenAddress = "receiver address is not allowed"
+	ErrorTimeout          = "timed out"
+	ErrorDNS              = "receiver host could not be resolved"
+	ErrorConnection       = "unable to connect to the receiver"
+	ErrorResponse         = "receiver responded with an error status"
+	ErrorDelivery         = "delivery failed"
+)
+
+// Delivery is a single signed POST of an event to a receiver.
+type Delivery struct {
+	ID        string
+	URL       string
+	Secret    string
+	EventType string
+	Payload   []byte
+}
+
+// Sender posts deliveries. The body is signed like Polka signs its webhooks,
+// so receivers can check it with auth.WebhookVerifier.
+type Sender struct {
+	Client *http.Client
+	Now    func() time.Time
+}
+
+// Send posts the delivery and returns the response status, or 0 when no
+// response was received. Any status outside 2xx is an error.
+func (s Sender) Send(ctx context.Context, delivery Delivery) (int, error) {
+	now := time.Now
+	if s.Now != nil {
+		now = s.Now
+	}
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: sendTimeout}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Chirpy-Webhooks/1.0")
+	req.Header.Set(EventHeader, delivery.EventType)
+	req.Header.Set(DeliveryHeader, delivery.ID)
+	req.Header.Set(SignatureHeader, auth.SignWebhook(delivery.Secret, now(), delivery.Payload))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseRead))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("receiver responded with %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// NewClient returns an HTTP client that refuses to connect to loopback,
+// private and link-local addresses. The check runs on the address being
+// dialed, after DNS resolution and for every redirect, so a receiver cannot
+// reach an internal service by pointing its host name at it later.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout, Control: denyInternalAddress}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	// A proxy would be dialed instead of the receiver.
+	transport.Proxy = nil
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+func denyInternalAddress(network, address string, c syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !AllowedAddress(addrPort.Addr()) {
+		return ErrForbiddenAddress
+	}
+	return nil
+}
+
+// deniedPrefixes are special-purpose ranges that IsGlobalUnicast and
+// IsPrivate let through. Shared and reserved space can reach internal hosts,
+// and the IPv6 ranges that embed an IPv4 address (NAT64, 6to4 and Teredo) can
+// reach any IPv4 address, private ones included, through a translator.
+var deniedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("100.64.0.0/10"),   // Shared address space (CGNAT)
+	netip.MustParsePrefix("192.0.0.0/24"),    // IETF protocol assignments
+	netip.MustParsePrefix("192.0.2.0/24"),    // Documentation
+	netip.MustParsePrefix("192.88.99.0/24"),  // 6to4 relay anycast
+	netip.MustParsePrefix("198.18.0.0/15"),   // Benchmarking
+	netip.MustParsePrefix("198.51.100.0/24"), // Documentation
+	netip.MustParsePrefix("203.0.113.0/24"),  // Documentation
+	netip.MustParsePrefix("240.0.0.0/4"),     // Reserved
+	netip.MustParsePrefix("64:ff9b::/96"),    // NAT64
+	netip.MustParsePrefix("64:ff9b:1::/48"),  // Local-use NAT64
+	netip.MustParsePrefix("100::/64"),        // Discard-only
+	netip.MustParsePrefix("2001::/23"),       // IETF protocol assignments, including Teredo
+	netip.MustParsePrefix("2001:db8::/32"),   // Documentation
+	netip.MustParsePrefix("2002::/16"),       // 6to4
+	netip.MustParsePrefix("3fff::/20"),       // Documentation
+	netip.MustParsePrefix("5f00::/16"),       // Segment routing SIDs
+}
+
+// AllowedAddress reports whether deliveries may be sent to the address.
+func AllowedAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range deniedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// ErrorCategory describes a failed Send in terms safe to show the owner of
+// the receiver.
+func ErrorCategory(status int, err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, ErrForbiddenAddress):
+		return ErrorForbiddenAddress
+	case status != 0:
+		return ErrorResponse
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorTimeout
+	case errors.As(err, &dnsErr):
+		return ErrorDNS
+	case errors.As(err, new(*net.OpError)):
+		return ErrorConnection
+	default:
+		return ErrorDelivery
+	}
+}
+
+// Backoff is the delay before the next try after the given number of failed
+// attempts: 30s, 1m, 2m, ... capped at 6h.
+func Backoff(attempts int) time.Duration {
+	if attempts < 1 {
+		return 0
+	}
+	delay := baseBackoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= maxBackoff {
+			return maxBackoff
+		}
+	}
+	return delay
+}
@@ -0,0 +1,187 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/lighthoof/Chirpy/internal/auth"
+)
+
+func TestSendSignsDelivery(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	received := make(chan *http.Request, 1)
+	var body []byte
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ = io.ReadAll(req.Body)
+		received <- req
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	payload := []byte(`{"type":"chirp.created"}`)
+	status, err := Sender{Now: func() time.Time { return now }}.Send(context.Background(), Delivery{
+		ID:        "delivery-1",
+		URL:       receiver.URL,
+		Secret:    "secret",
+		EventType: "chirp.created",
+		Payload:   payload,
+	})
+	if err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if status != http.StatusNoContent {
+		t.Errorf("status = %d, want %d", status, http.StatusNoContent)
+	}
+
+	req := <-received
+	if req.Header.Get(EventHeader) != "chirp.created" || req.Header.Get(DeliveryHeader) != "delivery-1" {
+		t.Errorf("unexpected headers: %v", req.Header)
+	}
+	if string(body) != string(payload) {
+		t.Errorf("body = %s, want %s", body, payload)
+	}
+
+	verifier := auth.WebhookVerifier{
+		Secrets:   []string{"secret"},
+		Tolerance: time.Minute,
+		Now:       func() time.Time { return now },
+	}
+	err = verifier.Verify(req.Header.Get(SignatureHeader), body)
+	if err != nil {
+		t.Errorf("signature was not accepted: %v", err)
+	}
+}
+
+func TestSendReportsFailure(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+
+	status, err := Sender{}.Send(context.Background(), Delivery{URL: receiver.URL, Payload: []byte("{}")})
+	if err == nil {
+		t.Errorf("expected an error for a 503 response")
+	}
+	if status != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", status, http.StatusServiceUnavailable)
+	}
+
+	receiver.Close()
+	status, err = Sender{}.Send(context.Background(), Delivery{URL: receiver.URL, Payload: []byte("{}")})
+	if err == nil || status != 0 {
+		t.Errorf("expected a connection error, got status %d and %v", status, err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	cases := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, 0},
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{10, 4*time.Hour + 16*time.Minute},
+		{11, 6 * time.Hour},
+		{100, 6 * time.Hour},
+	}
+
+	for _, c := range cases {
+		if got := Backoff(c.attempts); got != c.want {
+			t.Errorf("Backoff(%d) = %s, want %s", c.attempts, got, c.want)
+		}
+	}
+}
+
+func TestAllowedAddress(t *testing.T) {
+	cases := []struct {
+		addr string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"0.0.0.0", false},
+		{"100.64.0.1", false},
+		{"100.127.255.254", false},
+		{"100.128.0.1", true},
+		{"192.0.0.8", false},
+		{"192.0.2.1", false},
+		{"192.88.99.1", false},
+		{"198.18.0.1", false},
+		{"198.19.255.254", false},
+		{"198.51.100.1", false},
+		{"203.0.113.1", false},
+		{"240.0.0.1", false},
+		{"255.255.255.255", false},
+		{"::ffff:100.64.0.1", false},
+		{"64:ff9b::a00:1", false},
+		{"64:ff9b:1::a00:1", false},
+		{"100::1", false},
+		{"2001::1", false},
+		{"2001:0:4136:e378:8000:63bf:3fff:fdd2", false},
+		{"2001:db8::1", false},
+		{"2002:a00:1::1", false},
+		{"3fff::1", false},
+		{"5f00::1", false},
+		{"2001:4860:4860::8888", true},
+		{"::ffff:127.0.0.1", false},
+	}
+
+	for _, c := range cases {
+		if got := AllowedAddress(netip.MustParseAddr(c.addr)); got != c.want {
+			t.Errorf("AllowedAddress(%s) = %v, want %v", c.addr, got, c.want)
+		}
+	}
+}
+
+func TestNewClientRefusesLoopback(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		t.Errorf("request reached the loopback receiver")
+	}))
+	defer receiver.Close()
+
+	status, err := Sender{Client: NewClient(time.Second)}.Send(context.Background(), Delivery{URL: receiver.URL, Payload: []byte("{}")})
+	if !errors.Is(err, ErrForbiddenAddress) {
+		t.Errorf("expected ErrForbiddenAddress, got %v", err)
+	}
+	if got := ErrorCategory(status, err); got != ErrorForbiddenAddress {
+		t.Errorf("ErrorCategory = %q, want %q", got, ErrorForbiddenAddress)
+	}
+}
+
+func TestErrorCategory(t *testing.T) {
+	cases := []struct {
+		name   string
+		status int
+		err    error
+		want   string
+	}{
+		{"error status", http.StatusServiceUnavailable, errors.New("receiver responded with 503 Service Unavailable"), ErrorResponse},
+		{"timeout", 0, context.DeadlineExceeded, ErrorTimeout},
+		{"dns", 0, &net.DNSError{Err: "no such host", Name: "receiver.internal"}, ErrorDNS},
+		{"connection", 0, &net.OpError{Op: "dial", Err: errors.New("connection refused")}, ErrorConnection},
+		{"other", 0, errors.New("unsupported protocol scheme"), ErrorDelivery},
+	}
+
+	for _, c := range cases {
+		if got := ErrorCategory(c.status, c.err); got != c.want {
+			t.Errorf("%s: ErrorCategory = %q, want %q", c.name, got, c.want)
+		}
+	}
+}
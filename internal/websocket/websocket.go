@@ -0,0 +1,364 @@
+// Package websocket implements the server side of the WebSocket protocol
+// (RFC 6455): the opening handshake, framing, fragmentation, ping/pong and
+// the closing handshake. Extensions and subprotocols are not supported.
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Frame opcodes.
+const (
+	OpContinuation = 0x0
+	OpText         = 0x1
+	OpBinary       = 0x2
+	OpClose        = 0x8
+	OpPing         = 0x9
+	OpPong         = 0xA
+)
+
+// Close status codes.
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseNoStatus        = 1005
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+	CloseTryAgainLater   = 1013
+)
+
+// acceptGUID is appended to the client key to compute Sec-WebSocket-Accept.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const defaultReadLimit = 64 << 10
+
+// ErrCloseSent is returned when writing after a close frame was sent.
+var ErrCloseSent = errors.New("websocket: close frame already sent")
+
+// CloseError reports that the connection was closed, by the peer or because
+// it broke the protocol.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: closed with %d %s", e.Code, e.Reason)
+}
+
+// Conn is a server-side WebSocket connection. One goroutine may read while
+// others write; writes are serialized.
+type Conn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+
+	readLimit    int64
+	idleTimeout  time.Duration
+	writeTimeout time.Duration
+
+	writeMu   sync.Mutex
+	closeSent bool
+}
+
+// Upgrade performs the opening handshake and takes over the connection. On
+// failure it has already replied with an HTTP error.
+func Upgrade(w http.ResponseWriter, req *http.Request) (*Conn, error) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return nil, errors.New("websocket: method is not GET")
+	}
+	if !headerContains(req.Header, "Connection", "upgrade") || !headerContains(req.Header, "Upgrade", "websocket") {
+		http.Error(w, "Upgrade required", http.StatusBadRequest)
+		return nil, errors.New("websocket: not an upgrade request")
+	}
+	if req.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported WebSocket version", http.StatusUpgradeRequired)
+		return nil, errors.New("websocket: unsupported version")
+	}
+	key := req.Header.Get("Sec-WebSocket-Key")
+	decodedKey, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(decodedKey) != 16 {
+		http.Error(w, "Invalid Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, errors.New("websocket: invalid key")
+	}
+
+	netConn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, "", http.StatusInternalServerError)
+		return nil, err
+	}
+
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + AcceptKey(key) + "\r\n\r\n"
+	netConn.SetDeadline(time.Time{})
+	_, err = netConn.Write([]byte(response))
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	return &Conn{
+		conn:      netConn,
+		reader:    brw.Reader,
+		readLimit: defaultReadLimit,
+	}, nil
+}
+
+// AcceptKey computes the Sec-WebSocket-Accept value for a client key.
+func AcceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func headerContains(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, field := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SetReadLimit sets the largest message, in bytes, ReadMessage accepts.
+// Larger messages close the connection with CloseMessageTooBig.
+func (c *Conn) SetReadLimit(limit int64) {
+	c.readLimit = limit
+}
+
+// SetIdleTimeout makes reads fail when no frame, including pongs, arrives
+// for the given duration. Zero disables the timeout.
+func (c *Conn) SetIdleTimeout(timeout time.Duration) {
+	c.idleTimeout = timeout
+}
+
+// SetWriteTimeout bounds how long each write may take. Zero disables the
+// timeout.
+func (c *Conn) SetWriteTimeout(timeout time.Duration) {
+	c.writeTimeout = timeout
+}
+
+// SetReadDeadline sets an absolute deadline for reads, overriding the idle
+// timeout until the next frame.
+func (c *Conn) SetReadDeadline(deadline time.Time) error {
+	return c.conn.SetReadDeadline(deadline)
+}
+
+// Close closes the underlying connection without a closing handshake.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// ReadMessage returns the next text or binary message, reassembling
+// fragments. Pings are answered and pongs are skipped. When the peer closes
+// the connection, or breaks the protocol, the close is answered and a
+// *CloseError is returned.
+func (c *Conn) ReadMessage() (opcode int, data []byte, err error) {
+	opcode = -1
+	for {
+		if c.idleTimeout > 0 {
+			c.conn.SetReadDeadline(time.Now().Add(c.idleTimeout))
+		}
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch op {
+		case OpPing:
+			err = c.WriteMessage(OpPong, payload)
+			if err != nil && err != ErrCloseSent {
+				return 0, nil, err
+			}
+			continue
+		case OpPong:
+			continue
+		case OpClose:
+			return 0, nil, c.handleClose(payload)
+		case OpText, OpBinary:
+			if opcode != -1 {
+				return 0, nil, c.fail(CloseProtocolError, "expected continuation frame")
+			}
+			opcode = op
+		case OpContinuation:
+			if opcode == -1 {
+				return 0, nil, c.fail(CloseProtocolError, "unexpected continuation frame")
+			}
+		default:
+			return 0, nil, c.fail(CloseProtocolError, "unknown opcode")
+		}
+
+		if int64(len(data))+int64(len(payload)) > c.readLimit {
+			return 0, nil, c.fail(CloseMessageTooBig, "message too big")
+		}
+		data = append(data, payload...)
+
+		if fin {
+			if opcode == OpText && !utf8.Valid(data) {
+				return 0, nil, c.fail(CloseInvalidPayload, "invalid UTF-8")
+			}
+			return opcode, data, nil
+		}
+	}
+}
+
+func (c *Conn) readFrame() (fin bool, opcode int, payload []byte, err error) {
+	header := make([]byte, 2)
+	_, err = io.ReadFull(c.reader, header)
+	if err != nil {
+		return false, 0, nil, err
+	}
+
+	fin = header[0]&0x80 != 0
+	opcode = int(header[0] & 0x0f)
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, c.fail(CloseProtocolError, "reserved bits set")
+	}
+	// Clients must mask every frame they send.
+	if header[1]&0x80 == 0 {
+		return false, 0, nil, c.fail(CloseProtocolError, "unmasked frame")
+	}
+
+	length := int64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		extended := make([]byte, 2)
+		_, err = io.ReadFull(c.reader, extended)
+		length = int64(binary.BigEndian.Uint16(extended))
+	case 127:
+		extended := make([]byte, 8)
+		_, err = io.ReadFull(c.reader, extended)
+		length = int64(binary.BigEndian.Uint64(extended))
+	}
+	if err != nil {
+		return false, 0, nil, err
+	}
+
+	if opcode >= OpClose && (!fin || length > 125) {
+		return false, 0, nil, c.fail(CloseProtocolError, "invalid control frame")
+	}
+	if length < 0 || length > c.readLimit {
+		return false, 0, nil, c.fail(CloseMessageTooBig, "message too big")
+	}
+
+	mask := make([]byte, 4)
+	_, err = io.ReadFull(c.reader, mask)
+	if err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, length)
+	_, err = io.ReadFull(c.reader, payload)
+	if err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// handleClose answers a close frame from the peer with the same code.
+func (c *Conn) handleClose(payload []byte) error {
+	closeErr := &CloseError{Code: CloseNoStatus}
+	switch {
+	case len(payload) == 1:
+		return c.fail(CloseProtocolError, "invalid close frame")
+	case len(payload) >= 2:
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Reason = string(payload[2:])
+		if !validCloseCode(closeErr.Code) || !utf8.Valid(payload[2:]) {
+			return c.fail(CloseProtocolError, "invalid close frame")
+		}
+	}
+
+	code := closeErr.Code
+	if code == CloseNoStatus {
+		code = CloseNormal
+	}
+	err := c.WriteClose(code, "")
+	if err != nil && err != ErrCloseSent {
+		return err
+	}
+	return closeErr
+}
+
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1014:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// fail sends a close frame for a protocol violation and returns it as an
+// error.
+func (c *Conn) fail(code int, reason string) error {
+	c.WriteClose(code, reason)
+	return &CloseError{Code: code, Reason: reason}
+}
+
+// WriteMessage sends data in a single frame.
+func (c *Conn) WriteMessage(opcode int, data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.closeSent {
+		return ErrCloseSent
+	}
+	if opcode == OpClose {
+		c.closeSent = true
+	}
+
+	frame := make([]byte, 0, len(data)+10)
+	frame = append(frame, 0x80|byte(opcode))
+	switch {
+	case len(data) <= 125:
+		frame = append(frame, byte(len(data)))
+	case len(data) <= 0xffff:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(data)))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(data)))
+	}
+	frame = append(frame, data...)
+
+	if c.writeTimeout > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// WriteClose starts the closing handshake. Later writes fail with
+// ErrCloseSent.
+func (c *Conn) WriteClose(code int, reason string) error {
+	// Control frames carry at most 125 bytes.
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	return c.WriteMessage(OpClose, append(payload, reason...))
+}
@@ -0,0 +1,307 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAcceptKey(t *testing.T) {
+	// Example from RFC 6455, section 1.3.
+	got := AcceptKey("dGhlIHNhbXBsZSBub25jZQ==")
+	if got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("got %s", got)
+	}
+}
+
+// echoServer echoes every message and reports how the connection ended.
+func echoServer(t *testing.T, limit int64) (*httptest.Server, chan error) {
+	t.Helper()
+	done := make(chan error, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		conn, err := Upgrade(w, req)
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+		conn.SetReadLimit(limit)
+
+		for {
+			opcode, data, err := conn.ReadMessage()
+			if err != nil {
+				done <- err
+				return
+			}
+			err = conn.WriteMessage(opcode, data)
+			if err != nil {
+				done <- err
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, done
+}
+
+type testClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dial(t *testing.T, server *httptest.Server) *testClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: test\r\n"+
+		"Connection: keep-alive, Upgrade\r\nUpgrade: websocket\r\n"+
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: "+key+"\r\n\r\n")
+	if err != nil {
+		t.Fatalf("Handshake failed: %v", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("Reading handshake response failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("got status %d, want 101", resp.StatusCode)
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != AcceptKey(key) {
+		t.Fatalf("wrong Sec-WebSocket-Accept: %s", resp.Header.Get("Sec-WebSocket-Accept"))
+	}
+	return &testClient{conn: conn, reader: reader}
+}
+
+func (c *testClient) writeFrame(t *testing.T, fin bool, opcode int, payload []byte, masked bool) {
+	t.Helper()
+	first := byte(opcode)
+	if fin {
+		first |= 0x80
+	}
+	frame := []byte{first}
+	maskBit := byte(0)
+	if masked {
+		maskBit = 0x80
+	}
+	switch {
+	case len(payload) <= 125:
+		frame = append(frame, maskBit|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+	if masked {
+		mask := []byte{1, 2, 3, 4}
+		frame = append(frame, mask...)
+		for i, b := range payload {
+			frame = append(frame, b^mask[i%4])
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+
+	_, err := c.conn.Write(frame)
+	if err != nil {
+		t.Fatalf("Writing frame failed: %v", err)
+	}
+}
+
+func (c *testClient) readFrame(t *testing.T) (int, []byte) {
+	t.Helper()
+	header := make([]byte, 2)
+	_, err := io.ReadFull(c.reader, header)
+	if err != nil {
+		t.Fatalf("Reading frame failed: %v", err)
+	}
+	if header[1]&0x80 != 0 {
+		t.Fatalf("server frames must not be masked")
+	}
+	length := int(header[1] & 0x7f)
+	if length == 126 {
+		extended := make([]byte, 2)
+		io.ReadFull(c.reader, extended)
+		length = int(binary.BigEndian.Uint16(extended))
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(c.reader, payload)
+	if err != nil {
+		t.Fatalf("Reading payload failed: %v", err)
+	}
+	return int(header[0] & 0x0f), payload
+}
+
+func TestEcho(t *testing.T) {
+	server, _ := echoServer(t, 1<<20)
+	client := dial(t, server)
+
+	client.writeFrame(t, true, OpText, []byte("hello"), true)
+	opcode, payload := client.readFrame(t)
+	if opcode != OpText || string(payload) != "hello" {
+		t.Errorf("got %d %q", opcode, payload)
+	}
+
+	long := []byte(strings.Repeat("x", 1000))
+	client.writeFrame(t, true, OpBinary, long, true)
+	opcode, payload = client.readFrame(t)
+	if opcode != OpBinary || string(payload) != string(long) {
+		t.Errorf("got %d with %d bytes", opcode, len(payload))
+	}
+}
+
+func TestFragmentsWithInterleavedPing(t *testing.T) {
+	server, _ := echoServer(t, 1<<20)
+	client := dial(t, server)
+
+	client.writeFrame(t, false, OpText, []byte("hel"), true)
+	client.writeFrame(t, true, OpPing, []byte("p"), true)
+	client.writeFrame(t, true, OpContinuation, []byte("lo"), true)
+
+	opcode, payload := client.readFrame(t)
+	if opcode != OpPong || string(payload) != "p" {
+		t.Errorf("got %d %q, want pong", opcode, payload)
+	}
+	opcode, payload = client.readFrame(t)
+	if opcode != OpText || string(payload) != "hello" {
+		t.Errorf("got %d %q", opcode, payload)
+	}
+}
+
+func TestClosingHandshake(t *testing.T) {
+	server, done := echoServer(t, 1<<20)
+	client := dial(t, server)
+
+	client.writeFrame(t, true, OpClose, append(binary.BigEndian.AppendUint16(nil, CloseGoingAway), "bye"...), true)
+	opcode, payload := client.readFrame(t)
+	if opcode != OpClose || binary.BigEndian.Uint16(payload) != CloseGoingAway {
+		t.Errorf("got %d %v, want close 1001", opcode, payload)
+	}
+
+	err := <-done
+	closeErr := &CloseError{}
+	if !errors.As(err, &closeErr) || closeErr.Code != CloseGoingAway || closeErr.Reason != "bye" {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestProtocolViolations(t *testing.T) {
+	cases := []struct {
+		name string
+		send func(t *testing.T, client *testClient)
+		code int
+	}{
+		{
+			name: "unmasked frame",
+			send: func(t *testing.T, client *testClient) {
+				client.writeFrame(t, true, OpText, []byte("hi"), false)
+			},
+			code: CloseProtocolError,
+		},
+		{
+			name: "message too big",
+			send: func(t *testing.T, client *testClient) {
+				client.writeFrame(t, true, OpText, []byte(strings.Repeat("x", 200)), true)
+			},
+			code: CloseMessageTooBig,
+		},
+		{
+			name: "fragments too big",
+			send: func(t *testing.T, client *testClient) {
+				client.writeFrame(t, false, OpText, []byte(strings.Repeat("x", 80)), true)
+				client.writeFrame(t, true, OpContinuation, []byte(strings.Repeat("x", 80)), true)
+			},
+			code: CloseMessageTooBig,
+		},
+		{
+			name: "invalid UTF-8",
+			send: func(t *testing.T, client *testClient) {
+				client.writeFrame(t, true, OpText, []byte{0xff, 0xfe}, true)
+			},
+			code: CloseInvalidPayload,
+		},
+		{
+			name: "unexpected continuation",
+			send: func(t *testing.T, client *testClient) {
+				client.writeFrame(t, true, OpContinuation, []byte("x"), true)
+			},
+			code: CloseProtocolError,
+		},
+		{
+			name: "fragmented control frame",
+			send: func(t *testing.T, client *testClient) {
+				client.writeFrame(t, false, OpPing, nil, true)
+			},
+			code: CloseProtocolError,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			server, done := echoServer(t, 100)
+			client := dial(t, server)
+
+			c.send(t, client)
+			opcode, payload := client.readFrame(t)
+			if opcode != OpClose || len(payload) < 2 || int(binary.BigEndian.Uint16(payload)) != c.code {
+				t.Errorf("got %d %v, want close %d", opcode, payload, c.code)
+			}
+
+			err := <-done
+			closeErr := &CloseError{}
+			if !errors.As(err, &closeErr) || closeErr.Code != c.code {
+				t.Errorf("got %v, want close %d", err, c.code)
+			}
+		})
+	}
+}
+
+func TestUpgradeRejectsPlainRequests(t *testing.T) {
+	server, _ := echoServer(t, 100)
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("got status %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestWriteAfterClose(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		conn, err := Upgrade(w, req)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.WriteClose(CloseGoingAway, "shutting down")
+		if err := conn.WriteMessage(OpText, []byte("late")); err != ErrCloseSent {
+			t.Errorf("got %v, want ErrCloseSent", err)
+		}
+	}))
+	defer server.Close()
+	client := dial(t, server)
+
+	opcode, payload := client.readFrame(t)
+	if opcode != OpClose || string(payload[2:]) != "shutting down" {
+		t.Errorf("got %d %q", opcode, payload)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lighthoof/Chirpy/internal/auth"
+	"github.com/lighthoof/Chirpy/internal/database"
+	"github.com/lighthoof/Chirpy/internal/mailer"
+)
+
+const (
+	magicLinkRequestLimit  = 5
+	magicLinkRequestWindow = time.Hour
+	magicLinkSendTimeout   = 30 * time.Second
+)
+
+// magicLinkHandler e-mails a single-use login link. It answers the same way
+// and in about the same time whether or not the e-mail is registered, so it
+// cannot be used to probe for accounts: the link is created and sent after
+// the response. Every address gets magicLinkRequestLimit links per
+// magicLinkRequestWindow.
+func (cfg *apiConfig) magicLinkHandler(w http.ResponseWriter, req *http.Request) {
+	reqBody := Auth{}
+
+	_ = unmarshalType(req, &reqBody)
+
+	requests, err := cfg.dbQueries.CountMagicLinkRequest(req.Context(), database.CountMagicLinkRequestParams{
+		EmailHash:     auth.HashToken(strings.ToLower(strings.TrimSpace(reqBody.Email))),
+		WindowSeconds: durationSeconds(magicLinkRequestWindow),
+	})
+	if err != nil {
+		log.Printf("Unable to count magic link requests: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+	if requests > magicLinkRequestLimit {
+		respondWithError(w, http.StatusTooManyRequests, "Too many login links requested, try again later")
+		return
+	}
+
+	userDb, err := cfg.dbQueries.GetUserByEmail(req.Context(), reqBody.Email)
+	if err == sql.ErrNoRows {
+		log.Printf("Magic link requested for unknown e-mail: %s %s [%s]", req.Method, req.URL.Path, reqBody.Email)
+		respondWithJSON(w, http.StatusAccepted, "")
+		return
+	} else if err != nil {
+		log.Printf("Unable to retrieve user with the e-mail: %s %s [%s]", req.Method, req.URL.Path, reqBody.Email)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	go cfg.sendMagicLink(context.WithoutCancel(req.Context()), userDb)
+
+	respondWithJSON(w, http.StatusAccepted, "")
+}
+
+// sendMagicLink creates a magic link for the user and e-mails it. It runs
+// after the request is answered, so failures are only logged.
+func (cfg *apiConfig) sendMagicLink(ctx context.Context, userDb database.User) {
+	ctx, cancel := context.WithTimeout(ctx, magicLinkSendTimeout)
+	defer cancel()
+
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		log.Printf("Unable to create magic link token: %s", err)
+		return
+	}
+
+	_, err = cfg.dbQueries.CreateMagicLink(ctx, database.CreateMagicLinkParams{
+		TokenHash: auth.HashToken(token),
+		UserID:    userDb.ID,
+	})
+	if err != nil {
+		log.Printf("Unable to store magic link for user %s: %s", userDb.ID, err)
+		return
+	}
+
+	link := cfg.publicURL + "/app/login/magic.html?" + url.Values{"token": {token}}.Encode()
+	err = cfg.mailer.Send(ctx, mailer.Message{
+		To:      userDb.Email,
+		Subject: "Your Chirpy login link",
+		Body:    fmt.Sprintf("Click the link below to log in to Chirpy. It expires in 15 minutes and works only once.\r\n\r\n%s\r\n", link),
+	})
+	if err != nil {
+		log.Printf("Unable to send magic link to user %s: %s", userDb.ID, err)
+	}
+}
+
+func (cfg *apiConfig) consumeMagicLinkHandler(w http.ResponseWriter, req *http.Request) {
+	reqBody := MagicLink{}
+
+	_ = unmarshalType(req, &reqBody)
+
+	linkDb, err := cfg.dbQueries.ConsumeMagicLink(req.Context(), auth.HashToken(reqBody.Token))
+	if err == sql.ErrNoRows {
+		log.Printf("Magic link expired or does not exist: %s %s", req.Method, req.URL.Path)
+		respondWithError(w, http.StatusUnauthorized, "Login link expired or does not exist")
+		return
+	} else if err != nil {
+		log.Printf("Unable to consume magic link: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	userDb, err := cfg.dbQueries.GetUserById(req.Context(), linkDb.UserID)
+	if err != nil {
+		log.Printf("Unable to retrieve user: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusUnauthorized, "")
+		return
+	}
+
+	user, err := cfg.makeSession(req, userDb)
+	if err != nil {
+		log.Printf("Unable to create session: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, user)
+}
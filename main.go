@@ -1,17 +1,32 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
+	"github.com/lighthoof/Chirpy/internal/auth"
 	"github.com/lighthoof/Chirpy/internal/database"
+	"github.com/lighthoof/Chirpy/internal/events"
+	"github.com/lighthoof/Chirpy/internal/mailer"
+	"github.com/lighthoof/Chirpy/internal/media"
+	"github.com/lighthoof/Chirpy/internal/oidc"
+	"github.com/lighthoof/Chirpy/internal/stream"
+	"github.com/lighthoof/Chirpy/internal/webhooks"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func main() {
@@ -19,6 +34,8 @@ func main() {
 	const filePathRoot = "."
 	//const metricsTemplate = "./metrics_tmplt.html"
 	const port = "8080"
+	// How long open requests and sockets get to finish on shutdown.
+	const shutdownTimeout = 15 * time.Second
 
 	dbURL := os.Getenv("DB_URL")
 	db, err := sql.Open("postgres", dbURL)
@@ -26,43 +43,307 @@ func main() {
 		log.Fatalf("Unable to open DB connection : %v", err)
 	}
 
+	hasher, err := newPasswordHasher()
+	if err != nil {
+		log.Fatalf("Invalid password hasher configuration : %v", err)
+	}
+
+	minPasswordLength, err := strconv.Atoi(getEnvDefault("PASSWORD_MIN_LENGTH", "8"))
+	if err != nil {
+		log.Fatalf("Invalid PASSWORD_MIN_LENGTH : %v", err)
+	}
+	passwordPolicy, err := auth.LoadPasswordPolicy(minPasswordLength, 64, os.Getenv("BREACHED_PASSWORDS_FILE"))
+	if err != nil {
+		log.Fatalf("Unable to load password policy : %v", err)
+	}
+	if _, ok := hasher.(auth.BcryptHasher); ok {
+		passwordPolicy.MaxBytes = auth.BcryptMaxBytes
+	}
+
+	deletionGracePeriod, err := time.ParseDuration(getEnvDefault("ACCOUNT_DELETION_GRACE", "720h"))
+	if err != nil {
+		log.Fatalf("Invalid ACCOUNT_DELETION_GRACE : %v", err)
+	}
+
+	polkaTolerance, err := time.ParseDuration(getEnvDefault("POLKA_WEBHOOK_TOLERANCE", "5m"))
+	if err != nil {
+		log.Fatalf("Invalid POLKA_WEBHOOK_TOLERANCE : %v", err)
+	}
+
+	polkaAllowKey, err := strconv.ParseBool(getEnvDefault("POLKA_ALLOW_API_KEY", "false"))
+	if err != nil {
+		log.Fatalf("Invalid POLKA_ALLOW_API_KEY : %v", err)
+	}
+	polkaSecrets := splitList(os.Getenv("POLKA_WEBHOOK_SECRETS"))
+	if len(polkaSecrets) == 0 && polkaAllowKey {
+		log.Printf("WARNING: accepting unsigned Polka webhooks with POLKA_KEY, which have no timestamp or replay protection; set POLKA_WEBHOOK_SECRETS instead")
+	} else if len(polkaSecrets) == 0 {
+		log.Printf("POLKA_WEBHOOK_SECRETS is not set, Polka webhooks will be rejected")
+	}
+
+	chirpRestoreWindow, err := time.ParseDuration(getEnvDefault("CHIRP_RESTORE_WINDOW", "168h"))
+	if err != nil {
+		log.Fatalf("Invalid CHIRP_RESTORE_WINDOW : %v", err)
+	}
+	chirpRetention, err := time.ParseDuration(getEnvDefault("CHIRP_RETENTION", "720h"))
+	if err != nil {
+		log.Fatalf("Invalid CHIRP_RETENTION : %v", err)
+	}
+	if chirpRetention < chirpRestoreWindow {
+		log.Fatalf("CHIRP_RETENTION must not be shorter than CHIRP_RESTORE_WINDOW")
+	}
+
+	maxSockets, err := strconv.Atoi(getEnvDefault("WS_MAX_CONNECTIONS", "10000"))
+	if err != nil {
+		log.Fatalf("Invalid WS_MAX_CONNECTIONS : %v", err)
+	}
+	maxSocketsPerUser, err := strconv.Atoi(getEnvDefault("WS_MAX_CONNECTIONS_PER_USER", "5"))
+	if err != nil {
+		log.Fatalf("Invalid WS_MAX_CONNECTIONS_PER_USER : %v", err)
+	}
+
 	cfg := apiConfig{
 		fileserverHits: atomic.Int32{},
+		db:             db,
 		dbQueries:      database.New(db),
 		platform:       os.Getenv("PLATFORM"),
 		secret:         os.Getenv("TOKEN_SECRET"),
 		authExpiry:     time.Hour,
 		polkaAPIKey:    os.Getenv("POLKA_KEY"),
+		polkaVerifier: auth.WebhookVerifier{
+			Secrets:   polkaSecrets,
+			Tolerance: polkaTolerance,
+		},
+		polkaAllowKey:  polkaAllowKey,
+		adminAPIKey:    os.Getenv("ADMIN_API_KEY"),
+		publicURL:      getEnvDefault("PUBLIC_URL", "http://localhost:"+port),
+		mailer:         mailer.FileMailer{Dir: getEnvDefault("MAIL_DIR", "mail")},
+		hasher:         hasher,
+		passwordPolicy: passwordPolicy,
+
+		deletionGracePeriod: deletionGracePeriod,
+		exportDir:           getEnvDefault("EXPORT_DIR", "exports"),
+		blobStore:           media.LocalBlobStore{Dir: getEnvDefault("MEDIA_DIR", "media")},
+		chirpRestoreWindow:  chirpRestoreWindow,
+		chirpRetention:      chirpRetention,
+		webhookSender:       webhooks.Sender{Client: webhooks.NewClient(10 * time.Second)},
+		adminWebhookSender:  webhooks.Sender{Client: &http.Client{Timeout: 10 * time.Second}},
+		events:              events.NewBus(),
+		streamHub:           stream.NewHub(),
+		sockets:             newSocketRegistry(maxSockets, maxSocketsPerUser),
 	}
 
+	if os.Getenv("OIDC_ISSUER") != "" {
+		cfg.oidcProvider, err = oidc.Discover(context.Background(),
+			os.Getenv("OIDC_ISSUER"),
+			os.Getenv("OIDC_CLIENT_ID"),
+			os.Getenv("OIDC_CLIENT_SECRET"),
+			os.Getenv("OIDC_REDIRECT_URL"),
+		)
+		if err != nil {
+			log.Printf("OIDC login disabled: %v", err)
+		}
+	}
+
+	for _, eventType := range outboundEventTypes {
+		cfg.events.Subscribe(eventType, cfg.enqueueWebhookDeliveries)
+	}
+	cfg.events.Subscribe(eventUserFollowed, cfg.notifyFollow)
+	cfg.events.Subscribe(eventChirpCreated, cfg.notifyMentions)
+	cfg.events.Subscribe(eventSubscriptionChanged, cfg.notifySubscriptionChange)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go cfg.runAccountPurger(ctx, time.Hour)
+	go cfg.runDataExporter(ctx, 5*time.Second)
+	go cfg.runChirpPublisher(ctx, 10*time.Second)
+	go cfg.runChirpPurger(ctx, time.Hour)
+	go cfg.runMediaSweeper(ctx, time.Hour)
+	go cfg.runSubscriptionSweeper(ctx, 10*time.Minute)
+	go cfg.runOutboxRelay(ctx, time.Second)
+	go cfg.runWebhookDispatcher(ctx, 5*time.Second)
+	go cfg.runStreamListener(ctx, dbURL)
+
 	serveMux := http.NewServeMux()
 	fileServerHandler := http.FileServer(http.Dir(filePathRoot))
 	noPrefixFileHandler := http.StripPrefix("/app/", fileServerHandler)
 	serveMux.Handle("/app/", middlewareLog(cfg.middlewareMetricsInc(noPrefixFileHandler)))
 	serveMux.HandleFunc("GET /admin/metrics", cfg.counterHandler)
 	serveMux.HandleFunc("POST /admin/reset", cfg.resetHandler)
+	serveMux.HandleFunc("GET /admin/chirps/deleted", cfg.getDeletedChirpsHandler)
+	serveMux.HandleFunc("GET /admin/webhooks/events", cfg.getWebhookEventsHandler)
+	serveMux.HandleFunc("POST /admin/webhooks/events/{eventID}/replay", cfg.replayWebhookEventHandler)
+	serveMux.HandleFunc("POST /admin/webhooks/endpoints", cfg.adminWebhooks(cfg.createWebhookEndpoint))
+	serveMux.HandleFunc("GET /admin/webhooks/endpoints", cfg.adminWebhooks(cfg.getWebhookEndpoints))
+	serveMux.HandleFunc("DELETE /admin/webhooks/endpoints/{webhookID}", cfg.adminWebhooks(cfg.deleteWebhookEndpoint))
+	serveMux.HandleFunc("GET /admin/webhooks/endpoints/{webhookID}/deliveries", cfg.adminWebhooks(cfg.getWebhookDeliveries))
+	serveMux.HandleFunc("POST /admin/webhooks/endpoints/{webhookID}/deliveries/{deliveryID}/retry", cfg.adminWebhooks(cfg.retryWebhookDelivery))
 	serveMux.HandleFunc("GET /api/healthz", readinessHandler)
 	serveMux.HandleFunc("GET /api/chirps", cfg.getChirpsHandler)
 	serveMux.HandleFunc("GET /api/chirps/{chirpID}", cfg.getChirpByIdHandler)
+	serveMux.HandleFunc("POST /api/chirps/{chirpID}/votes", cfg.voteHandler)
+	serveMux.HandleFunc("POST /api/chirps/{chirpID}/restore", cfg.restoreChirpHandler)
 	serveMux.HandleFunc("POST /api/chirps", cfg.createChirpHandler)
+	serveMux.HandleFunc("PUT /api/chirps/{chirpID}", cfg.editChirpHandler)
+	serveMux.HandleFunc("POST /api/collections", cfg.createCollectionHandler)
+	serveMux.HandleFunc("GET /api/collections", cfg.getCollectionsHandler)
+	serveMux.HandleFunc("PUT /api/collections/{collectionID}", cfg.renameCollectionHandler)
+	serveMux.HandleFunc("DELETE /api/collections/{collectionID}", cfg.deleteCollectionHandler)
+	serveMux.HandleFunc("GET /api/collections/{collectionID}/chirps", cfg.getBookmarksHandler)
+	serveMux.HandleFunc("POST /api/collections/{collectionID}/chirps", cfg.addBookmarkHandler)
+	serveMux.HandleFunc("DELETE /api/collections/{collectionID}/chirps/{chirpID}", cfg.removeBookmarkHandler)
+	serveMux.HandleFunc("POST /api/conversations", cfg.startConversationHandler)
+	serveMux.HandleFunc("GET /api/conversations", cfg.getConversationsHandler)
+	serveMux.HandleFunc("GET /api/conversations/{conversationID}", cfg.getConversationHandler)
+	serveMux.HandleFunc("GET /api/conversations/{conversationID}/messages", cfg.getMessagesHandler)
+	serveMux.HandleFunc("POST /api/conversations/{conversationID}/messages", cfg.sendMessageHandler)
+	serveMux.HandleFunc("POST /api/conversations/{conversationID}/read", cfg.readConversationHandler)
+	serveMux.HandleFunc("POST /api/drafts", cfg.createDraftHandler)
+	serveMux.HandleFunc("GET /api/drafts", cfg.getDraftsHandler)
+	serveMux.HandleFunc("GET /api/drafts/{draftID}", cfg.getDraftHandler)
+	serveMux.HandleFunc("PUT /api/drafts/{draftID}", cfg.updateDraftHandler)
+	serveMux.HandleFunc("DELETE /api/drafts/{draftID}", cfg.deleteDraftHandler)
+	serveMux.HandleFunc("POST /api/drafts/{draftID}/publish", cfg.publishDraftHandler)
+	serveMux.HandleFunc("POST /api/media", cfg.uploadMediaHandler)
+	serveMux.HandleFunc("GET /api/media/{mediaID}", cfg.getMediaHandler)
+	serveMux.HandleFunc("GET /api/media/{mediaID}/thumbnail", cfg.getThumbnailHandler)
 	serveMux.HandleFunc("POST /api/users", cfg.createUserHandler)
 	serveMux.HandleFunc("POST /api/login", cfg.loginHandler)
-	serveMux.HandleFunc("POST /api/polka/webhooks", cfg.userUpgradeHandler)
+	serveMux.HandleFunc("POST /api/login/magic", cfg.magicLinkHandler)
+	serveMux.HandleFunc("POST /api/login/magic/consume", cfg.consumeMagicLinkHandler)
+	serveMux.HandleFunc("GET /api/login/oidc", cfg.oidcLoginHandler)
+	serveMux.HandleFunc("GET /api/login/oidc/callback", cfg.oidcCallbackHandler)
+	serveMux.HandleFunc("GET /api/notifications", cfg.getNotificationsHandler)
+	serveMux.HandleFunc("POST /api/notifications/read", cfg.readNotificationsHandler)
+	serveMux.HandleFunc("GET /api/notifications/preferences", cfg.getNotificationPreferencesHandler)
+	serveMux.HandleFunc("PUT /api/notifications/preferences", cfg.updateNotificationPreferencesHandler)
+	serveMux.HandleFunc("POST /api/polka/webhooks", cfg.polkaWebhookHandler)
 	serveMux.HandleFunc("POST /api/refresh", cfg.refreshHandler)
+	serveMux.HandleFunc("GET /api/stream", cfg.streamHandler)
+	serveMux.HandleFunc("GET /api/ws", cfg.socketHandler)
+	serveMux.HandleFunc("POST /api/webhooks", cfg.userWebhooks(cfg.createWebhookEndpoint))
+	serveMux.HandleFunc("GET /api/webhooks", cfg.userWebhooks(cfg.getWebhookEndpoints))
+	serveMux.HandleFunc("DELETE /api/webhooks/{webhookID}", cfg.userWebhooks(cfg.deleteWebhookEndpoint))
+	serveMux.HandleFunc("GET /api/webhooks/{webhookID}/deliveries", cfg.userWebhooks(cfg.getWebhookDeliveries))
+	serveMux.HandleFunc("POST /api/webhooks/{webhookID}/deliveries/{deliveryID}/retry", cfg.userWebhooks(cfg.retryWebhookDelivery))
 	serveMux.HandleFunc("POST /api/revoke", cfg.revokeHandler)
 	serveMux.HandleFunc("PUT /api/users", cfg.updateUserHandler)
+	serveMux.HandleFunc("PATCH /api/users/me", cfg.patchUserHandler)
+	serveMux.HandleFunc("DELETE /api/users/me", cfg.deleteAccountHandler)
+	serveMux.HandleFunc("POST /api/users/me/pin", cfg.pinChirpHandler)
+	serveMux.HandleFunc("DELETE /api/users/me/pin", cfg.unpinChirpHandler)
+	serveMux.HandleFunc("GET /api/users/me/subscription", cfg.getSubscriptionHandler)
+	serveMux.HandleFunc("GET /api/users/me/entitlements", cfg.getEntitlementsHandler)
+	serveMux.HandleFunc("GET /api/users/me/export", cfg.exportAccountHandler)
+	serveMux.HandleFunc("GET /api/users/me/exports/{exportID}", cfg.getDataExportHandler)
+	serveMux.HandleFunc("GET /api/users/{userID}", cfg.getPublicProfileHandler)
+	serveMux.HandleFunc("GET /api/users/by-username/{username}", cfg.getPublicProfileByUsernameHandler)
+	serveMux.HandleFunc("POST /api/users/{userID}/follow", cfg.followHandler)
+	serveMux.HandleFunc("DELETE /api/users/{userID}/follow", cfg.unfollowHandler)
+	serveMux.HandleFunc("POST /api/users/{userID}/block", cfg.blockHandler)
+	serveMux.HandleFunc("DELETE /api/users/{userID}/block", cfg.unblockHandler)
+	serveMux.HandleFunc("POST /api/users/{userID}/mute", cfg.muteHandler)
+	serveMux.HandleFunc("DELETE /api/users/{userID}/mute", cfg.unmuteHandler)
 	serveMux.HandleFunc("DELETE /api/chirps/{chirpID}", cfg.deleteChirpHandler)
+	serveMux.HandleFunc("POST /api/oauth/clients", cfg.registerOAuthClientHandler)
+	serveMux.HandleFunc("GET /api/oauth/authorize", cfg.authorizeHandler)
+	serveMux.HandleFunc("POST /api/oauth/authorize", cfg.approveHandler)
+	serveMux.HandleFunc("POST /api/oauth/token", cfg.tokenHandler)
+	serveMux.HandleFunc("POST /api/oauth/introspect", cfg.introspectHandler)
 
 	server := &http.Server{
 		Handler: serveMux,
 		Addr:    ":" + port,
 	}
 
+	// Shutdown neither waits for hijacked WebSockets nor ends open event
+	// streams, so both are told to close first.
+	server.RegisterOnShutdown(func() {
+		cfg.sockets.shutdown()
+		cfg.streamHub.Close()
+	})
+
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-ctx.Done()
+		log.Printf("Shutting down")
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		err := server.Shutdown(shutdownCtx)
+		if err != nil {
+			log.Printf("Unable to shut down cleanly: %v", err)
+		}
+		err = cfg.sockets.wait(shutdownCtx)
+		if err != nil {
+			log.Printf("Unable to close all sockets: %v", err)
+		}
+	}()
+
 	log.Printf("Serving files from %s on port: %s\n", filePathRoot, port)
 	err = server.ListenAndServe()
-	if err != nil {
+	if err != http.ErrServerClosed {
 		log.Fatalf("Server error : %v", err)
 	}
+	<-shutdownDone
+}
+
+// splitList splits a comma-separated setting, dropping empty entries.
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func getEnvDefault(key, fallback string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// newPasswordHasher configures the hasher for new passwords. Hashes made with
+// any other supported algorithm keep working and are upgraded on login.
+func newPasswordHasher() (auth.PasswordHasher, error) {
+	switch getEnvDefault("PASSWORD_HASHER", "argon2id") {
+	case "argon2id":
+		hasher := auth.DefaultHasher.(auth.Argon2idHasher)
+		memory, err := strconv.ParseUint(getEnvDefault("ARGON2_MEMORY_KIB", fmt.Sprint(hasher.Memory)), 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		iterations, err := strconv.ParseUint(getEnvDefault("ARGON2_ITERATIONS", fmt.Sprint(hasher.Iterations)), 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		parallelism, err := strconv.ParseUint(getEnvDefault("ARGON2_PARALLELISM", fmt.Sprint(hasher.Parallelism)), 10, 8)
+		if err != nil {
+			return nil, err
+		}
+		hasher.Memory = uint32(memory)
+		hasher.Iterations = uint32(iterations)
+		hasher.Parallelism = uint8(parallelism)
+		return hasher, nil
+	case "bcrypt":
+		cost, err := strconv.Atoi(getEnvDefault("BCRYPT_COST", "12"))
+		if err != nil {
+			return nil, err
+		}
+		if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("bcrypt cost out of range: %d", cost)
+		}
+		return auth.BcryptHasher{Cost: cost}, nil
+	default:
+		return nil, fmt.Errorf("unknown password hasher: %s", os.Getenv("PASSWORD_HASHER"))
+	}
 }
 
 type User struct {
@@ -73,25 +354,300 @@ type User struct {
 	Token       string    `json:"token"`
 	Refresh     string    `json:"refresh_token"`
 	IsChirpyRed bool      `json:"is_chirpy_red"`
+	Username    string    `json:"username,omitempty"`
+	DisplayName string    `json:"display_name,omitempty"`
+	Bio         string    `json:"bio,omitempty"`
+	AvatarURL   string    `json:"avatar_url,omitempty"`
+}
+
+// PublicUser is the profile visible to everyone. It must never carry the
+// e-mail or any token.
+type PublicUser struct {
+	ID             uuid.UUID `json:"id"`
+	JoinedAt       time.Time `json:"joined_at"`
+	Username       string    `json:"username,omitempty"`
+	DisplayName    string    `json:"display_name"`
+	Bio            string    `json:"bio"`
+	AvatarURL      string    `json:"avatar_url"`
+	IsChirpyRed    bool      `json:"is_chirpy_red"`
+	ChirpCount     int64     `json:"chirp_count"`
+	FollowerCount  int64     `json:"follower_count"`
+	FollowingCount int64     `json:"following_count"`
+}
+
+type UserPatch struct {
+	Email           *string `json:"email"`
+	Password        *string `json:"password"`
+	CurrentPassword string  `json:"current_password"`
+	Username        *string `json:"username"`
+	DisplayName     *string `json:"display_name"`
+	Bio             *string `json:"bio"`
+	AvatarURL       *string `json:"avatar_url"`
+}
+
+type AccountDeletion struct {
+	PurgeAfter time.Time `json:"purge_after"`
+}
+
+type DataExport struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+}
+
+type Subscription struct {
+	Plan               string    `json:"plan"`
+	Status             string    `json:"status"`
+	Active             bool      `json:"active"`
+	CurrentPeriodStart time.Time `json:"current_period_start"`
+	CurrentPeriodEnd   time.Time `json:"current_period_end"`
 }
+
+type Entitlements struct {
+	Plan              string `json:"plan"`
+	MaxChirpLength    int    `json:"max_chirp_length"`
+	MaxChirpMedia     int    `json:"max_chirp_media"`
+	ChirpsPerHour     int    `json:"chirps_per_hour"`
+	ScheduledChirps   bool   `json:"scheduled_chirps"`
+	PinChirps         bool   `json:"pin_chirps"`
+	EditWindowSeconds int    `json:"edit_window_seconds"`
+}
+
 type Auth struct {
 	Password string `json:"password"`
 	Email    string `json:"email"`
 }
 
+type MagicLink struct {
+	Token string `json:"token"`
+}
+
 type Chirp struct {
+	ID        uuid.UUID    `json:"id"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+	Body      string       `json:"body"`
+	UserID    uuid.UUID    `json:"user_id"`
+	Media     []ChirpMedia `json:"media,omitempty"`
+	Poll      *Poll        `json:"poll,omitempty"`
+	PublishAt *time.Time   `json:"publish_at,omitempty"`
+	Pinned    bool         `json:"pinned,omitempty"`
+	DeletedAt *time.Time   `json:"deleted_at,omitempty"`
+	EditedAt  *time.Time   `json:"edited_at,omitempty"`
+}
+
+type ChirpMedia struct {
+	ID           uuid.UUID `json:"id"`
+	AltText      string    `json:"alt_text"`
+	URL          string    `json:"url,omitempty"`
+	ThumbnailURL string    `json:"thumbnail_url,omitempty"`
+	ContentType  string    `json:"content_type,omitempty"`
+	Width        int       `json:"width,omitempty"`
+	Height       int       `json:"height,omitempty"`
+}
+
+// ChirpRef identifies a chirp without its content.
+type ChirpRef struct {
+	ID     uuid.UUID `json:"id"`
+	UserID uuid.UUID `json:"user_id"`
+}
+
+type ConversationStart struct {
+	UserIDs []uuid.UUID `json:"user_ids"`
+}
+
+type Conversation struct {
+	ID          uuid.UUID            `json:"id"`
+	CreatedAt   time.Time            `json:"created_at"`
+	UpdatedAt   time.Time            `json:"updated_at"`
+	Direct      bool                 `json:"direct"`
+	Members     []ConversationMember `json:"members"`
+	UnreadCount int64                `json:"unread_count"`
+}
+
+type ConversationMember struct {
+	UserID     uuid.UUID  `json:"user_id"`
+	LastReadAt *time.Time `json:"last_read_at,omitempty"`
+}
+
+// Message is a direct message. Messages are only ever returned to the
+// members of their conversation.
+type Message struct {
+	ID             uuid.UUID   `json:"id"`
+	CreatedAt      time.Time   `json:"created_at"`
+	ConversationID uuid.UUID   `json:"conversation_id"`
+	SenderID       uuid.UUID   `json:"sender_id"`
+	Body           string      `json:"body"`
+	ReadBy         []uuid.UUID `json:"read_by"`
+}
+
+// SocketCommand is a message from a WebSocket client: "auth" with a token,
+// or "subscribe" and "unsubscribe" with a channel.
+type SocketCommand struct {
+	Type    string `json:"type"`
+	Token   string `json:"token"`
+	Channel string `json:"channel"`
+}
+
+// SocketMessage is a message to a WebSocket client: a pushed event, with the
+// subscribed channels it belongs to, or the reply to a command.
+type SocketMessage struct {
+	Type     string          `json:"type"`
+	ID       int64           `json:"id,omitempty"`
+	Channels []string        `json:"channels,omitempty"`
+	Channel  string          `json:"channel,omitempty"`
+	Data     json.RawMessage `json:"data,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+type Collection struct {
+	ID            uuid.UUID `json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	Name          string    `json:"name"`
+	BookmarkCount int64     `json:"bookmark_count"`
+}
+
+type Follow struct {
+	FollowerID uuid.UUID `json:"follower_id"`
+	FolloweeID uuid.UUID `json:"followee_id"`
+}
+
+type Notification struct {
+	ID        uuid.UUID       `json:"id"`
+	CreatedAt time.Time       `json:"created_at"`
+	Type      string          `json:"type"`
+	ActorID   *uuid.UUID      `json:"actor_id,omitempty"`
+	ChirpID   *uuid.UUID      `json:"chirp_id,omitempty"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	Read      bool            `json:"read"`
+}
+
+type NotificationsRead struct {
+	IDs []uuid.UUID `json:"ids"`
+	All bool        `json:"all"`
+}
+
+type Pin struct {
+	ChirpID uuid.UUID `json:"chirp_id"`
+}
+
+type Bookmark struct {
+	ChirpID uuid.UUID `json:"chirp_id"`
+}
+
+type Draft struct {
 	ID        uuid.UUID `json:"id"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 	Body      string    `json:"body"`
-	UserID    uuid.UUID `json:"user_id"`
+}
+
+// Poll is both the poll part of a new chirp and the poll returned with it.
+// Vote counts are left out until the viewer has voted or the poll is closed.
+type Poll struct {
+	ID            uuid.UUID    `json:"id"`
+	ClosesAt      time.Time    `json:"closes_at"`
+	Closed        bool         `json:"closed"`
+	Options       []PollOption `json:"options"`
+	TotalVotes    *int64       `json:"total_votes,omitempty"`
+	VotedOptionID *uuid.UUID   `json:"voted_option_id,omitempty"`
+}
+
+type PollOption struct {
+	ID    uuid.UUID `json:"id"`
+	Text  string    `json:"text"`
+	Votes *int64    `json:"votes,omitempty"`
+}
+
+type PollVote struct {
+	OptionID uuid.UUID `json:"option_id"`
 }
 
 type Event struct {
+	ID    string `json:"id"`
 	Event string `json:"event"`
 	Data  Data   `json:"data"`
 }
 
 type Data struct {
-	User_id string `json:"user_id"`
+	User_id   string     `json:"user_id"`
+	Plan      string     `json:"plan"`
+	PeriodEnd *time.Time `json:"period_end"`
+}
+
+type WebhookEvent struct {
+	ID          uuid.UUID       `json:"id"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+	Provider    string          `json:"provider"`
+	EventID     string          `json:"event_id"`
+	EventType   string          `json:"event_type"`
+	Status      string          `json:"status"`
+	Error       string          `json:"error,omitempty"`
+	Attempts    int32           `json:"attempts"`
+	ProcessedAt *time.Time      `json:"processed_at,omitempty"`
+	Payload     json.RawMessage `json:"payload"`
+}
+
+type WebhookEndpoint struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret,omitempty"`
+}
+
+type WebhookDelivery struct {
+	ID             uuid.UUID       `json:"id"`
+	CreatedAt      time.Time       `json:"created_at"`
+	EventID        uuid.UUID       `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	Status         string          `json:"status"`
+	Attempts       int32           `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	ResponseStatus *int32          `json:"response_status,omitempty"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+	Payload        json.RawMessage `json:"payload"`
+}
+
+// OutboundEvent is the body of every outbound webhook.
+type OutboundEvent struct {
+	ID        uuid.UUID `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+type UserUpgrade struct {
+	UserID       uuid.UUID    `json:"user_id"`
+	Subscription Subscription `json:"subscription"`
+}
+
+type OAuthClient struct {
+	ID           string `json:"client_id"`
+	Secret       string `json:"client_secret,omitempty"`
+	Name         string `json:"name"`
+	RedirectURI  string `json:"redirect_uri"`
+	Scope        string `json:"scope"`
+	Confidential bool   `json:"confidential"`
+}
+
+type OAuthToken struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+}
+
+type Introspection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
 }
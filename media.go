@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lighthoof/Chirpy/internal/auth"
+	"github.com/lighthoof/Chirpy/internal/database"
+	"github.com/lighthoof/Chirpy/internal/media"
+	"github.com/lighthoof/Chirpy/internal/oauth"
+)
+
+var errMediaUnavailable = errors.New("media not found or already attached")
+
+// Uploads not attached to a chirp within unattachedMediaTTL are removed by
+// runMediaSweeper.
+const unattachedMediaTTL = 24 * time.Hour
+
+// uploadMediaHandler accepts a single image in the multipart field "file". The
+// stored media can then be attached to a chirp by its ID.
+func (cfg *apiConfig) uploadMediaHandler(w http.ResponseWriter, req *http.Request) {
+	stringToken, err := auth.GetBearerToken(req.Header)
+	if err != nil {
+		log.Printf("Unable to get the token from request header: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusUnauthorized, "")
+		return
+	}
+
+	userID, err := auth.ValidateJWTScope(stringToken, cfg.secret, oauth.ScopeChirpsWrite)
+	if err != nil {
+		log.Printf("Unable to validate the token: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusUnauthorized, "")
+		return
+	}
+
+	req.Body = http.MaxBytesReader(w, req.Body, media.MaxUploadSize+1<<20)
+	file, _, err := req.FormFile("file")
+	if err != nil {
+		log.Printf("Unable to read the upload: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusBadRequest, "Missing or oversized file")
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, media.MaxUploadSize+1))
+	if err != nil {
+		log.Printf("Unable to read the upload: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusBadRequest, "")
+		return
+	}
+	if len(data) > media.MaxUploadSize {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "File is too large")
+		return
+	}
+
+	processed, err := media.Process(data)
+	if err != nil {
+		log.Printf("Unable to process the upload: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusUnsupportedMediaType, err.Error())
+		return
+	}
+
+	mediaID := uuid.New()
+	blobKey := path.Join(userID.String(), mediaID.String())
+	thumbnailKey := blobKey + "_thumb"
+
+	err = cfg.blobStore.Put(req.Context(), blobKey, bytes.NewReader(processed.Data))
+	if err != nil {
+		log.Printf("Unable to store media: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+	mediumDb := database.Medium{BlobKey: blobKey, ThumbnailKey: thumbnailKey}
+	err = cfg.blobStore.Put(req.Context(), thumbnailKey, bytes.NewReader(processed.Thumbnail))
+	if err != nil {
+		log.Printf("Unable to store thumbnail: %s %s [%s]", req.Method, req.URL.Path, err)
+		deleteMediaBlobs(context.WithoutCancel(req.Context()), cfg.blobStore, []database.Medium{mediumDb})
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	mediaDb, err := cfg.dbQueries.CreateMedia(req.Context(), database.CreateMediaParams{
+		ID:           mediaID,
+		UserID:       userID,
+		ContentType:  processed.ContentType,
+		Width:        int32(processed.Width),
+		Height:       int32(processed.Height),
+		BlobKey:      blobKey,
+		ThumbnailKey: thumbnailKey,
+	})
+	if err != nil {
+		log.Printf("Unable to create media: %s %s [%s]", req.Method, req.URL.Path, err)
+		deleteMediaBlobs(context.WithoutCancel(req.Context()), cfg.blobStore, []database.Medium{mediumDb})
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, chirpMediaFromDb(mediaDb))
+}
+
+func (cfg *apiConfig) getMediaHandler(w http.ResponseWriter, req *http.Request) {
+	cfg.serveMedia(w, req, false)
+}
+
+func (cfg *apiConfig) getThumbnailHandler(w http.ResponseWriter, req *http.Request) {
+	cfg.serveMedia(w, req, true)
+}
+
+func (cfg *apiConfig) serveMedia(w http.ResponseWriter, req *http.Request, thumbnail bool) {
+	mediaID, err := uuid.Parse(req.PathValue("mediaID"))
+	if err != nil {
+		log.Printf("Unable to parse mediaID: %s", req.PathValue("mediaID"))
+		respondWithError(w, http.StatusBadRequest, "")
+		return
+	}
+
+	mediaDb, err := cfg.dbQueries.GetMedia(req.Context(), mediaID)
+	if err == sql.ErrNoRows {
+		log.Printf("Media not found")
+		respondWithError(w, http.StatusNotFound, "")
+		return
+	} else if err != nil {
+		log.Printf("Unable to retrieve media: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	key, contentType := mediaDb.BlobKey, mediaDb.ContentType
+	if thumbnail {
+		key, contentType = mediaDb.ThumbnailKey, "image/jpeg"
+	}
+
+	blob, err := cfg.blobStore.Open(req.Context(), key)
+	if err != nil {
+		log.Printf("Unable to open blob: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusNotFound, "")
+		return
+	}
+	defer blob.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, blob)
+}
+
+// runMediaSweeper removes uploads that were never attached to a chirp,
+// together with their blobs. Attaching and sweeping the same upload cannot
+// both succeed, as both only touch rows without a chirp.
+func (cfg *apiConfig) runMediaSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		mediaDb, err := cfg.dbQueries.DeleteUnattachedMedia(ctx, durationSeconds(unattachedMediaTTL))
+		if err != nil {
+			log.Printf("Unable to delete unattached media: %s", err)
+		}
+		deleteMediaBlobs(ctx, cfg.blobStore, mediaDb)
+		if len(mediaDb) > 0 {
+			log.Printf("Deleted %d unattached uploads", len(mediaDb))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// loadChirpMedia fills in the media of every chirp with a single query.
+func (cfg *apiConfig) loadChirpMedia(ctx context.Context, queries *database.Queries, chirps []*Chirp) error {
+	if len(chirps) == 0 {
+		return nil
+	}
+
+	chirpIDs := []uuid.UUID{}
+	byID := map[uuid.UUID]*Chirp{}
+	for _, chirp := range chirps {
+		chirpIDs = append(chirpIDs, chirp.ID)
+		byID[chirp.ID] = chirp
+		chirp.Media = nil
+	}
+
+	mediaDb, err := queries.GetMediaForChirps(ctx, chirpIDs)
+	if err != nil {
+		return err
+	}
+
+	for _, mediumDb := range mediaDb {
+		chirp := byID[mediumDb.ChirpID.UUID]
+		chirp.Media = append(chirp.Media, chirpMediaFromDb(mediumDb))
+	}
+
+	return nil
+}
+
+func chirpMediaFromDb(mediaDb database.Medium) ChirpMedia {
+	return ChirpMedia{
+		ID:           mediaDb.ID,
+		AltText:      mediaDb.AltText,
+		URL:          "/api/media/" + mediaDb.ID.String(),
+		ThumbnailURL: "/api/media/" + mediaDb.ID.String() + "/thumbnail",
+		ContentType:  mediaDb.ContentType,
+		Width:        int(mediaDb.Width),
+		Height:       int(mediaDb.Height),
+	}
+}
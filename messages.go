@@ -0,0 +1,435 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/lighthoof/Chirpy/internal/database"
+	"github.com/lighthoof/Chirpy/internal/oauth"
+)
+
+const (
+	// Members of a group conversation, including the user who starts it.
+	maxConversationMembers = 8
+	maxMessageLength       = 2000
+)
+
+// startConversationHandler starts a conversation with the users in
+// {"user_ids": [...]}. With a single user it is a one-to-one conversation,
+// of which there is only one per pair: starting it again returns the
+// existing one with a 200. No two members can have a block between them.
+func (cfg *apiConfig) startConversationHandler(w http.ResponseWriter, req *http.Request) {
+	reqBody := ConversationStart{}
+
+	err := unmarshalType(req, &reqBody)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Malformed request body")
+		return
+	}
+
+	userID, ok := cfg.authenticate(w, req, oauth.ScopeMessages)
+	if !ok {
+		return
+	}
+
+	otherIDs := []uuid.UUID{}
+	for _, otherID := range reqBody.UserIDs {
+		if otherID != userID && !slices.Contains(otherIDs, otherID) {
+			otherIDs = append(otherIDs, otherID)
+		}
+	}
+	if len(otherIDs) == 0 {
+		respondWithError(w, http.StatusBadRequest, "Provide the user_ids to talk to")
+		return
+	}
+	if len(otherIDs)+1 > maxConversationMembers {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("A conversation can have at most %d members", maxConversationMembers))
+		return
+	}
+
+	// Blocks between any two members count, not only those with the caller.
+	memberIDs := append([]uuid.UUID{userID}, otherIDs...)
+	blocked, err := cfg.dbQueries.HasBlocksAmong(req.Context(), memberIDs)
+	if err != nil {
+		log.Printf("Unable to check blocks: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+	if blocked {
+		respondWithError(w, http.StatusForbidden, "Unable to message these users")
+		return
+	}
+
+	directKey := sql.NullString{}
+	if len(otherIDs) == 1 {
+		directKey = sql.NullString{String: directConversationKey(userID, otherIDs[0]), Valid: true}
+	}
+
+	tx, err := cfg.db.BeginTx(req.Context(), nil)
+	if err != nil {
+		log.Printf("Unable to begin transaction: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	status := http.StatusCreated
+	conversationDb, err := qtx.CreateConversation(req.Context(), database.CreateConversationParams{
+		CreatedBy: uuid.NullUUID{UUID: userID, Valid: true},
+		DirectKey: directKey,
+	})
+	if err == sql.ErrNoRows && directKey.Valid {
+		// The one-to-one conversation already exists, with both members.
+		status = http.StatusOK
+		conversationDb, err = qtx.GetConversationByDirectKey(req.Context(), directKey)
+		if err != nil {
+			log.Printf("Unable to retrieve conversation: %s %s [%s]", req.Method, req.URL.Path, err)
+			respondWithError(w, http.StatusInternalServerError, "")
+			return
+		}
+	} else if err != nil {
+		log.Printf("Unable to create conversation: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	} else {
+		err = qtx.AddConversationMembers(req.Context(), database.AddConversationMembersParams{
+			ConversationID: conversationDb.ID,
+			UserIds:        memberIDs,
+		})
+		if isForeignKeyViolation(err) {
+			respondWithError(w, http.StatusNotFound, "User not found")
+			return
+		} else if err != nil {
+			log.Printf("Unable to add conversation members: %s %s [%s]", req.Method, req.URL.Path, err)
+			respondWithError(w, http.StatusInternalServerError, "")
+			return
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("Unable to commit transaction: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	rowDb, err := cfg.dbQueries.GetConversation(req.Context(),
+		database.GetConversationParams{UserID: userID, ID: conversationDb.ID})
+	if err != nil {
+		log.Printf("Unable to retrieve conversation: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respBody, err := cfg.conversationsFromDb(req.Context(), []database.GetConversationsRow{database.GetConversationsRow(rowDb)})
+	if err != nil {
+		log.Printf("Unable to retrieve conversation members: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respondWithJSON(w, status, respBody[0])
+}
+
+// getConversationsHandler lists the conversations of the user, the most
+// recently active first, a page at a time.
+func (cfg *apiConfig) getConversationsHandler(w http.ResponseWriter, req *http.Request) {
+	userID, ok := cfg.authenticate(w, req, oauth.ScopeMessages)
+	if !ok {
+		return
+	}
+
+	limit, offset, err := parsePagination(req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	conversationsDb, err := cfg.dbQueries.GetConversations(req.Context(), database.GetConversationsParams{
+		UserID: userID,
+		Limit:  limit,
+		Offset: offset,
+	})
+	if err != nil {
+		log.Printf("Unable to retrieve conversations: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respBody, err := cfg.conversationsFromDb(req.Context(), conversationsDb)
+	if err != nil {
+		log.Printf("Unable to retrieve conversation members: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, respBody)
+}
+
+func (cfg *apiConfig) getConversationHandler(w http.ResponseWriter, req *http.Request) {
+	_, conversationDb, ok := cfg.getMemberConversation(w, req)
+	if !ok {
+		return
+	}
+
+	respBody, err := cfg.conversationsFromDb(req.Context(), []database.GetConversationsRow{database.GetConversationsRow(conversationDb)})
+	if err != nil {
+		log.Printf("Unable to retrieve conversation members: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, respBody[0])
+}
+
+// sendMessageHandler posts {"body": ...} to a conversation of the user. A
+// block in either direction between the sender and another member stops new
+// messages, in groups as in one-to-one conversations.
+func (cfg *apiConfig) sendMessageHandler(w http.ResponseWriter, req *http.Request) {
+	reqBody := Message{}
+
+	err := unmarshalType(req, &reqBody)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Malformed request body")
+		return
+	}
+
+	userID, conversationDb, ok := cfg.getMemberConversation(w, req)
+	if !ok {
+		return
+	}
+
+	body := strings.TrimSpace(reqBody.Body)
+	if body == "" {
+		respondWithError(w, http.StatusBadRequest, "Message is empty")
+		return
+	}
+	if len(body) > maxMessageLength {
+		respondWithError(w, http.StatusBadRequest, "Message is too long")
+		return
+	}
+
+	blocked, err := cfg.dbQueries.IsBlockedInConversation(req.Context(),
+		database.IsBlockedInConversationParams{ConversationID: conversationDb.ID, UserID: userID})
+	if err != nil {
+		log.Printf("Unable to check blocks: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+	if blocked {
+		respondWithError(w, http.StatusForbidden, "Unable to message this conversation")
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(req.Context(), nil)
+	if err != nil {
+		log.Printf("Unable to begin transaction: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	messageDb, err := qtx.CreateMessage(req.Context(), database.CreateMessageParams{
+		ConversationID: conversationDb.ID,
+		SenderID:       userID,
+		Body:           body,
+	})
+	if err != nil {
+		log.Printf("Unable to create message: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	err = qtx.TouchConversation(req.Context(), conversationDb.ID)
+	if err != nil {
+		log.Printf("Unable to update conversation: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	// Senders have read everything up to their own message.
+	err = qtx.MarkConversationRead(req.Context(),
+		database.MarkConversationReadParams{ConversationID: conversationDb.ID, UserID: userID})
+	if err != nil {
+		log.Printf("Unable to mark conversation read: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("Unable to commit transaction: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, messageFromDb(messageDb, nil))
+}
+
+// getMessagesHandler lists the messages of a conversation, newest first, a
+// page at a time. Each message lists the members who have read it.
+func (cfg *apiConfig) getMessagesHandler(w http.ResponseWriter, req *http.Request) {
+	userID, conversationDb, ok := cfg.getMemberConversation(w, req)
+	if !ok {
+		return
+	}
+
+	limit, offset, err := parsePagination(req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	messagesDb, err := cfg.dbQueries.GetMessages(req.Context(), database.GetMessagesParams{
+		ConversationID: conversationDb.ID,
+		ViewerID:       userID,
+		Limit:          limit,
+		Offset:         offset,
+	})
+	if err != nil {
+		log.Printf("Unable to retrieve messages: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	membersDb, err := cfg.dbQueries.GetConversationMembers(req.Context(), []uuid.UUID{conversationDb.ID})
+	if err != nil {
+		log.Printf("Unable to retrieve conversation members: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respBody := []Message{}
+	for _, messageDb := range messagesDb {
+		respBody = append(respBody, messageFromDb(messageDb, membersDb))
+	}
+
+	respondWithJSON(w, http.StatusOK, respBody)
+}
+
+// readConversationHandler marks every message of the conversation as read by
+// the user, which is what the read receipts of the other members show.
+func (cfg *apiConfig) readConversationHandler(w http.ResponseWriter, req *http.Request) {
+	userID, conversationDb, ok := cfg.getMemberConversation(w, req)
+	if !ok {
+		return
+	}
+
+	err := cfg.dbQueries.MarkConversationRead(req.Context(),
+		database.MarkConversationReadParams{ConversationID: conversationDb.ID, UserID: userID})
+	if err != nil {
+		log.Printf("Unable to mark conversation read: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
+
+// getMemberConversation loads the conversation of the request path.
+// Conversations the user is not a member of are reported as not found.
+func (cfg *apiConfig) getMemberConversation(w http.ResponseWriter, req *http.Request) (uuid.UUID, database.GetConversationRow, bool) {
+	userID, ok := cfg.authenticate(w, req, oauth.ScopeMessages)
+	if !ok {
+		return uuid.UUID{}, database.GetConversationRow{}, false
+	}
+
+	conversationID, err := uuid.Parse(req.PathValue("conversationID"))
+	if err != nil {
+		log.Printf("Unable to parse conversationID: %s", req.PathValue("conversationID"))
+		respondWithError(w, http.StatusBadRequest, "")
+		return uuid.UUID{}, database.GetConversationRow{}, false
+	}
+
+	conversationDb, err := cfg.dbQueries.GetConversation(req.Context(),
+		database.GetConversationParams{UserID: userID, ID: conversationID})
+	if err == sql.ErrNoRows {
+		log.Printf("Conversation not found")
+		respondWithError(w, http.StatusNotFound, "")
+		return uuid.UUID{}, database.GetConversationRow{}, false
+	} else if err != nil {
+		log.Printf("Unable to retrieve conversation: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return uuid.UUID{}, database.GetConversationRow{}, false
+	}
+
+	return userID, conversationDb, true
+}
+
+// conversationsFromDb converts the conversations and fills in their members
+// with a single query.
+func (cfg *apiConfig) conversationsFromDb(ctx context.Context, conversationsDb []database.GetConversationsRow) ([]Conversation, error) {
+	conversations := []Conversation{}
+	if len(conversationsDb) == 0 {
+		return conversations, nil
+	}
+
+	conversationIDs := []uuid.UUID{}
+	for _, conversationDb := range conversationsDb {
+		conversationIDs = append(conversationIDs, conversationDb.ID)
+	}
+
+	membersDb, err := cfg.dbQueries.GetConversationMembers(ctx, conversationIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	members := map[uuid.UUID][]ConversationMember{}
+	for _, memberDb := range membersDb {
+		member := ConversationMember{UserID: memberDb.UserID}
+		if memberDb.LastReadAt.Valid {
+			member.LastReadAt = &memberDb.LastReadAt.Time
+		}
+		members[memberDb.ConversationID] = append(members[memberDb.ConversationID], member)
+	}
+
+	for _, conversationDb := range conversationsDb {
+		conversations = append(conversations, Conversation{
+			ID:          conversationDb.ID,
+			CreatedAt:   conversationDb.CreatedAt,
+			UpdatedAt:   conversationDb.UpdatedAt,
+			Direct:      conversationDb.DirectKey.Valid,
+			Members:     members[conversationDb.ID],
+			UnreadCount: conversationDb.UnreadCount,
+		})
+	}
+	return conversations, nil
+}
+
+// directConversationKey identifies the one-to-one conversation of two users
+// regardless of who started it.
+func directConversationKey(userID, otherID uuid.UUID) string {
+	first, second := userID.String(), otherID.String()
+	if second < first {
+		first, second = second, first
+	}
+	return first + ":" + second
+}
+
+// messageFromDb converts the message; members who read the conversation
+// after it was sent, other than the sender, are listed in read_by.
+func messageFromDb(messageDb database.Message, membersDb []database.ConversationMember) Message {
+	message := Message{
+		ID:             messageDb.ID,
+		CreatedAt:      messageDb.CreatedAt,
+		ConversationID: messageDb.ConversationID,
+		SenderID:       messageDb.SenderID,
+		Body:           messageDb.Body,
+		ReadBy:         []uuid.UUID{},
+	}
+	for _, memberDb := range membersDb {
+		if memberDb.UserID != messageDb.SenderID && memberDb.LastReadAt.Valid &&
+			!memberDb.LastReadAt.Time.Before(messageDb.CreatedAt) {
+			message.ReadBy = append(message.ReadBy, memberDb.UserID)
+		}
+	}
+	return message
+}
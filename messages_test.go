@@ -0,0 +1,227 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lighthoof/Chirpy/internal/auth"
+	"github.com/lighthoof/Chirpy/internal/database"
+)
+
+func TestDirectConversationKey(t *testing.T) {
+	first := uuid.MustParse("11111111-1111-1111-1111-111111111111")
+	second := uuid.MustParse("22222222-2222-2222-2222-222222222222")
+	third := uuid.MustParse("33333333-3333-3333-3333-333333333333")
+
+	if directConversationKey(first, second) != directConversationKey(second, first) {
+		t.Errorf("Expected the same key for both directions, got %s and %s",
+			directConversationKey(first, second), directConversationKey(second, first))
+	}
+
+	want := first.String() + ":" + second.String()
+	if key := directConversationKey(second, first); key != want {
+		t.Errorf("Expected %s, got %s", want, key)
+	}
+
+	if directConversationKey(first, second) == directConversationKey(first, third) {
+		t.Errorf("Expected different keys for different pairs")
+	}
+}
+
+// fakeConversations answers the conversation queries of the message
+// handlers from memory. CreateConversation returns no row for a direct key
+// that is already taken, like ON CONFLICT DO NOTHING.
+type fakeConversations struct {
+	*fakeDB
+	now           time.Time
+	conversations map[uuid.UUID]database.Conversation
+	members       map[uuid.UUID][]uuid.UUID
+}
+
+func newFakeConversations() *fakeConversations {
+	f := &fakeConversations{
+		fakeDB:        newFakeDB(),
+		now:           time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		conversations: map[uuid.UUID]database.Conversation{},
+		members:       map[uuid.UUID][]uuid.UUID{},
+	}
+
+	f.handle("IsBlockedInConversation", func(args []driver.Value) (*fakeRows, error) {
+		userID := fakeUUID(args[1])
+		blocked := false
+		for _, memberID := range f.members[fakeUUID(args[0])] {
+			blocked = blocked || f.blockedBetween(userID, memberID)
+		}
+		return fakeExists(blocked), nil
+	})
+	f.handle("CreateConversation", func(args []driver.Value) (*fakeRows, error) {
+		directKey, _ := args[1].(string)
+		if _, ok := f.directConversation(directKey); ok {
+			return nil, nil
+		}
+		conversation := database.Conversation{
+			ID:        uuid.New(),
+			CreatedAt: f.now,
+			UpdatedAt: f.now,
+			CreatedBy: uuid.NullUUID{UUID: fakeUUID(args[0]), Valid: true},
+			DirectKey: sql.NullString{String: directKey, Valid: directKey != ""},
+		}
+		f.conversations[conversation.ID] = conversation
+		return fakeResult(fakeConversationValues(conversation)), nil
+	})
+	f.handle("GetConversationByDirectKey", func(args []driver.Value) (*fakeRows, error) {
+		conversation, ok := f.directConversation(args[0].(string))
+		if !ok {
+			return nil, nil
+		}
+		return fakeResult(fakeConversationValues(conversation)), nil
+	})
+	f.handle("GetConversation", func(args []driver.Value) (*fakeRows, error) {
+		conversation, ok := f.conversations[fakeUUID(args[1])]
+		if !ok || !f.isMember(conversation.ID, fakeUUID(args[0])) {
+			return nil, nil
+		}
+		return fakeResult(append(fakeConversationValues(conversation), int64(0))), nil
+	})
+	f.handle("GetConversationMembers", func(args []driver.Value) (*fakeRows, error) {
+		values := [][]driver.Value{}
+		for _, conversationID := range fakeUUIDArray(args[0]) {
+			for _, memberID := range f.members[conversationID] {
+				values = append(values, []driver.Value{conversationID.String(), memberID.String(), f.now, nil})
+			}
+		}
+		return fakeResult(values...), nil
+	})
+	f.handle("AddConversationMembers", func(args []driver.Value) (*fakeRows, error) {
+		conversationID := fakeUUID(args[0])
+		for _, userID := range fakeUUIDArray(args[1]) {
+			if !f.isMember(conversationID, userID) {
+				f.members[conversationID] = append(f.members[conversationID], userID)
+			}
+		}
+		return nil, nil
+	})
+	return f
+}
+
+func (f *fakeConversations) directConversation(directKey string) (database.Conversation, bool) {
+	for _, conversation := range f.conversations {
+		if directKey != "" && conversation.DirectKey.String == directKey {
+			return conversation, true
+		}
+	}
+	return database.Conversation{}, false
+}
+
+func (f *fakeConversations) isMember(conversationID, userID uuid.UUID) bool {
+	for _, memberID := range f.members[conversationID] {
+		if memberID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+func fakeConversationValues(conversation database.Conversation) []driver.Value {
+	var directKey driver.Value
+	if conversation.DirectKey.Valid {
+		directKey = conversation.DirectKey.String
+	}
+	return []driver.Value{
+		conversation.ID.String(),
+		conversation.CreatedAt,
+		conversation.UpdatedAt,
+		conversation.CreatedBy.UUID.String(),
+		directKey,
+	}
+}
+
+func TestStartConversation(t *testing.T) {
+	fake := newFakeConversations()
+	cfg := fake.config()
+
+	userID, otherID, thirdID, fourthID := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	fake.blocks[[2]uuid.UUID{thirdID, fourthID}] = true
+
+	start := func(callerID uuid.UUID, userIDs ...uuid.UUID) int {
+		ids := []string{}
+		for _, id := range userIDs {
+			ids = append(ids, `"`+id.String()+`"`)
+		}
+		token, err := auth.MakeJWT(callerID, cfg.secret, time.Hour)
+		if err != nil {
+			t.Fatalf("Unable to make token: %v", err)
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "/api/conversations",
+			strings.NewReader(fmt.Sprintf(`{"user_ids": [%s]}`, strings.Join(ids, ","))))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		cfg.startConversationHandler(w, req)
+		return w.Code
+	}
+
+	cases := []struct {
+		name     string
+		callerID uuid.UUID
+		userIDs  []uuid.UUID
+		want     int
+	}{
+		{name: "new direct conversation", callerID: userID, userIDs: []uuid.UUID{otherID}, want: http.StatusCreated},
+		{name: "existing direct conversation", callerID: userID, userIDs: []uuid.UUID{otherID}, want: http.StatusOK},
+		{name: "existing direct conversation started by the other user", callerID: otherID, userIDs: []uuid.UUID{userID}, want: http.StatusOK},
+		{name: "group", callerID: userID, userIDs: []uuid.UUID{otherID, thirdID}, want: http.StatusCreated},
+		{name: "group with the same members", callerID: userID, userIDs: []uuid.UUID{otherID, thirdID}, want: http.StatusCreated},
+		{name: "block with the caller", callerID: thirdID, userIDs: []uuid.UUID{fourthID}, want: http.StatusForbidden},
+		{name: "block with the caller in a group", callerID: fourthID, userIDs: []uuid.UUID{userID, thirdID}, want: http.StatusForbidden},
+		{name: "block between other members", callerID: userID, userIDs: []uuid.UUID{thirdID, fourthID}, want: http.StatusForbidden},
+		{name: "only the caller", callerID: userID, userIDs: []uuid.UUID{userID}, want: http.StatusBadRequest},
+	}
+
+	for _, c := range cases {
+		if code := start(c.callerID, c.userIDs...); code != c.want {
+			t.Errorf("%s: expected %d, got %d", c.name, c.want, code)
+		}
+	}
+
+	if len(fake.conversations) != 3 {
+		t.Errorf("Expected 3 conversations, got %d", len(fake.conversations))
+	}
+}
+
+func TestSendMessageBlockedInGroup(t *testing.T) {
+	fake := newFakeConversations()
+	cfg := fake.config()
+
+	userID, otherID, thirdID := uuid.New(), uuid.New(), uuid.New()
+	conversation := database.Conversation{ID: uuid.New(), CreatedAt: fake.now, UpdatedAt: fake.now}
+	fake.conversations[conversation.ID] = conversation
+	fake.members[conversation.ID] = []uuid.UUID{userID, otherID, thirdID}
+
+	for _, block := range [][2]uuid.UUID{{thirdID, userID}, {userID, thirdID}} {
+		clear(fake.blocks)
+		fake.blocks[block] = true
+
+		token, err := auth.MakeJWT(userID, cfg.secret, time.Hour)
+		if err != nil {
+			t.Fatalf("Unable to make token: %v", err)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/api/conversations/"+conversation.ID.String()+"/messages",
+			strings.NewReader(`{"body": "hello"}`))
+		req.SetPathValue("conversationID", conversation.ID.String())
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+
+		cfg.sendMessageHandler(w, req)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected %d for block %v, got %d", http.StatusForbidden, block, w.Code)
+		}
+	}
+}
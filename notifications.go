@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/lighthoof/Chirpy/internal/database"
+	"github.com/lighthoof/Chirpy/internal/events"
+	"github.com/lighthoof/Chirpy/internal/oauth"
+)
+
+// Notification types. Users can turn each of them off.
+const (
+	notificationFollow    = "follow"
+	notificationMention   = "mention"
+	notificationChirpyRed = "chirpy_red"
+)
+
+var notificationTypes = []string{notificationFollow, notificationMention, notificationChirpyRed}
+
+// Mentions beyond this many in a single chirp do not notify anyone.
+const maxMentions = 10
+
+// mentionPattern matches @username where the @ does not follow a word
+// character, so e-mail addresses are not mentions.
+var mentionPattern = regexp.MustCompile(`(?:^|[^A-Za-z0-9_])@([A-Za-z0-9_]{3,30})`)
+
+// notify creates a notification unless the recipient turned the type off or
+// blocked or muted the actor, and records notification.created for the
+// stream. The outbox event ID makes it idempotent, as events may be relayed
+// more than once.
+func (cfg *apiConfig) notify(ctx context.Context, event events.Event, params database.CreateNotificationParams) error {
+	if params.ActorID.Valid {
+		if params.ActorID.UUID == params.UserID {
+			return nil
+		}
+		hidden, err := cfg.hiddenFrom(ctx, params.UserID, params.ActorID.UUID, true)
+		if err != nil {
+			return err
+		}
+		if hidden {
+			return nil
+		}
+	}
+
+	params.EventID = event.ID
+	if params.Data == "" {
+		params.Data = "{}"
+	}
+
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	notificationDb, err := qtx.CreateNotification(ctx, params)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	err = recordEvent(ctx, qtx, eventNotificationCreated, params.UserID, notificationFromDb(notificationDb))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (cfg *apiConfig) notifyFollow(ctx context.Context, event events.Event) error {
+	follow := Follow{}
+	err := json.Unmarshal(event.Payload, &follow)
+	if err != nil {
+		return err
+	}
+
+	return cfg.notify(ctx, event, database.CreateNotificationParams{
+		UserID:  follow.FolloweeID,
+		Type:    notificationFollow,
+		ActorID: uuid.NullUUID{UUID: follow.FollowerID, Valid: true},
+	})
+}
+
+func (cfg *apiConfig) notifyMentions(ctx context.Context, event events.Event) error {
+	chirp := Chirp{}
+	err := json.Unmarshal(event.Payload, &chirp)
+	if err != nil {
+		return err
+	}
+
+	usernames := parseMentions(chirp.Body)
+	if len(usernames) == 0 {
+		return nil
+	}
+
+	usersDb, err := cfg.dbQueries.GetUsersByUsernames(ctx, usernames)
+	if err != nil {
+		return err
+	}
+
+	for _, userDb := range usersDb {
+		err = cfg.notify(ctx, event, database.CreateNotificationParams{
+			UserID:  userDb.ID,
+			Type:    notificationMention,
+			ActorID: uuid.NullUUID{UUID: chirp.UserID, Valid: true},
+			ChirpID: uuid.NullUUID{UUID: chirp.ID, Valid: true},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cfg *apiConfig) notifySubscriptionChange(ctx context.Context, event events.Event) error {
+	return cfg.notify(ctx, event, database.CreateNotificationParams{
+		UserID: event.UserID,
+		Type:   notificationChirpyRed,
+		Data:   string(event.Payload),
+	})
+}
+
+// parseMentions returns the distinct usernames mentioned in the body, or none
+// if there are more than maxMentions.
+func parseMentions(body string) []string {
+	usernames := mentionedUsernames(body)
+	if len(usernames) > maxMentions {
+		return nil
+	}
+	return usernames
+}
+
+// mentionedUsernames returns every distinct username mentioned in the body.
+func mentionedUsernames(body string) []string {
+	usernames := []string{}
+	for _, match := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		if !slices.Contains(usernames, match[1]) {
+			usernames = append(usernames, match[1])
+		}
+	}
+	return usernames
+}
+
+// getNotificationsHandler lists the notifications of the user, newest first,
+// a page at a time. ?unread=true leaves out the ones already read. The
+// unread count is returned in the X-Unread-Count header.
+func (cfg *apiConfig) getNotificationsHandler(w http.ResponseWriter, req *http.Request) {
+	userID, ok := cfg.authenticate(w, req, oauth.ScopeNotifications)
+	if !ok {
+		return
+	}
+
+	limit, offset, err := parsePagination(req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	notificationsDb, err := cfg.dbQueries.GetNotifications(req.Context(), database.GetNotificationsParams{
+		UserID:     userID,
+		UnreadOnly: req.URL.Query().Get("unread") == "true",
+		Limit:      limit,
+		Offset:     offset,
+	})
+	if err != nil {
+		log.Printf("Unable to retrieve notifications: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	unread, err := cfg.dbQueries.CountUnreadNotifications(req.Context(), userID)
+	if err != nil {
+		log.Printf("Unable to count notifications: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respBody := []Notification{}
+	for _, notificationDb := range notificationsDb {
+		respBody = append(respBody, notificationFromDb(notificationDb))
+	}
+
+	w.Header().Set("X-Unread-Count", fmt.Sprint(unread))
+	respondWithJSON(w, http.StatusOK, respBody)
+}
+
+// readNotificationsHandler marks the notifications with the given IDs, or all
+// of them with "all": true, as read.
+func (cfg *apiConfig) readNotificationsHandler(w http.ResponseWriter, req *http.Request) {
+	reqBody := NotificationsRead{}
+
+	err := unmarshalType(req, &reqBody)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Malformed request body")
+		return
+	}
+
+	userID, ok := cfg.authenticate(w, req, oauth.ScopeNotifications)
+	if !ok {
+		return
+	}
+
+	if !reqBody.All && len(reqBody.IDs) == 0 {
+		respondWithError(w, http.StatusBadRequest, "Provide ids or all")
+		return
+	}
+
+	_, err = cfg.dbQueries.MarkNotificationsRead(req.Context(), database.MarkNotificationsReadParams{
+		UserID: userID,
+		All:    reqBody.All,
+		Ids:    reqBody.IDs,
+	})
+	if err != nil {
+		log.Printf("Unable to mark notifications read: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
+
+// getNotificationPreferencesHandler returns whether each notification type is
+// enabled. Types are enabled unless the user turned them off.
+func (cfg *apiConfig) getNotificationPreferencesHandler(w http.ResponseWriter, req *http.Request) {
+	userID, ok := cfg.authenticate(w, req, oauth.ScopeNotifications)
+	if !ok {
+		return
+	}
+
+	cfg.respondWithNotificationPreferences(w, req, userID)
+}
+
+// updateNotificationPreferencesHandler takes {"<type>": true|false, ...};
+// types that are left out keep their setting.
+func (cfg *apiConfig) updateNotificationPreferencesHandler(w http.ResponseWriter, req *http.Request) {
+	reqBody := map[string]bool{}
+
+	err := unmarshalType(req, &reqBody)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Malformed request body")
+		return
+	}
+
+	userID, ok := cfg.authenticate(w, req, oauth.ScopeNotifications)
+	if !ok {
+		return
+	}
+
+	err = validateNotificationPreferences(reqBody)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(req.Context(), nil)
+	if err != nil {
+		log.Printf("Unable to begin transaction: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	for notificationType, enabled := range reqBody {
+		err = qtx.SetNotificationPreference(req.Context(), database.SetNotificationPreferenceParams{
+			UserID:  userID,
+			Type:    notificationType,
+			Enabled: enabled,
+		})
+		if err != nil {
+			log.Printf("Unable to update notification preference: %s %s [%s]", req.Method, req.URL.Path, err)
+			respondWithError(w, http.StatusInternalServerError, "")
+			return
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("Unable to commit transaction: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	cfg.respondWithNotificationPreferences(w, req, userID)
+}
+
+func (cfg *apiConfig) respondWithNotificationPreferences(w http.ResponseWriter, req *http.Request, userID uuid.UUID) {
+	preferencesDb, err := cfg.dbQueries.GetNotificationPreferences(req.Context(), userID)
+	if err != nil {
+		log.Printf("Unable to retrieve notification preferences: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, notificationPreferences(preferencesDb))
+}
+
+// validateNotificationPreferences checks an update of the preferences. The
+// error is meant for the client.
+func validateNotificationPreferences(preferences map[string]bool) error {
+	for notificationType := range preferences {
+		if !slices.Contains(notificationTypes, notificationType) {
+			return fmt.Errorf("Unknown notification type: %s", notificationType)
+		}
+	}
+	return nil
+}
+
+// notificationPreferences returns the setting of every notification type.
+// Only the types a user changed are stored; the others are enabled.
+func notificationPreferences(preferencesDb []database.NotificationPreference) map[string]bool {
+	preferences := map[string]bool{}
+	for _, notificationType := range notificationTypes {
+		preferences[notificationType] = true
+	}
+	for _, preferenceDb := range preferencesDb {
+		preferences[preferenceDb.Type] = preferenceDb.Enabled
+	}
+	return preferences
+}
+
+func notificationFromDb(notificationDb database.Notification) Notification {
+	notification := Notification{
+		ID:        notificationDb.ID,
+		CreatedAt: notificationDb.CreatedAt,
+		Type:      notificationDb.Type,
+		Read:      notificationDb.ReadAt.Valid,
+	}
+	if notificationDb.ActorID.Valid {
+		notification.ActorID = &notificationDb.ActorID.UUID
+	}
+	if notificationDb.ChirpID.Valid {
+		notification.ChirpID = &notificationDb.ChirpID.UUID
+	}
+	if notificationDb.Data != "{}" {
+		notification.Data = json.RawMessage(notificationDb.Data)
+	}
+	return notification
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lighthoof/Chirpy/internal/database"
+	"github.com/lighthoof/Chirpy/internal/events"
+)
+
+func TestParseMentions(t *testing.T) {
+	cases := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{name: "single", body: "Hello @alice!", want: []string{"alice"}},
+		{name: "start of body", body: "@bob_1 hi", want: []string{"bob_1"}},
+		{name: "distinct", body: "@alice and @carol and @alice again", want: []string{"alice", "carol"}},
+		{name: "e-mail address", body: "mail me at alice@example.com", want: []string{}},
+		{name: "too short", body: "hi @al", want: []string{}},
+		{name: "none", body: "no mentions here", want: []string{}},
+		{
+			name: "too many",
+			body: "@user01 @user02 @user03 @user04 @user05 @user06 @user07 @user08 @user09 @user10 @user11",
+			want: nil,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := parseMentions(c.body)
+			if !slices.Equal(got, c.want) {
+				t.Errorf("Expected %v, got %v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestNotificationPreferences(t *testing.T) {
+	preferences := notificationPreferences([]database.NotificationPreference{
+		{Type: notificationMention, Enabled: false},
+		{Type: notificationFollow, Enabled: true},
+	})
+
+	want := map[string]bool{notificationFollow: true, notificationMention: false, notificationChirpyRed: true}
+	for notificationType, enabled := range want {
+		if preferences[notificationType] != enabled {
+			t.Errorf("Expected %s to be %v, got %v", notificationType, enabled, preferences[notificationType])
+		}
+	}
+	if len(preferences) != len(notificationTypes) {
+		t.Errorf("Expected %d types, got %v", len(notificationTypes), preferences)
+	}
+
+	err := validateNotificationPreferences(map[string]bool{notificationMention: false})
+	if err != nil {
+		t.Errorf("Valid preferences were rejected: %v", err)
+	}
+	err = validateNotificationPreferences(map[string]bool{"reply": false})
+	if err == nil {
+		t.Errorf("Unknown notification type was accepted!")
+	}
+}
+
+// fakeNotifications answers CreateNotification from memory, leaving out
+// disabled types like the SQL does.
+type fakeNotifications struct {
+	*fakeDB
+	disabled      map[string]bool
+	notifications []string
+}
+
+func newFakeNotifications() *fakeNotifications {
+	f := &fakeNotifications{fakeDB: newFakeDB(), disabled: map[string]bool{}}
+	f.handle("CreateNotification", func(args []driver.Value) (*fakeRows, error) {
+		notificationType := args[1].(string)
+		if f.disabled[notificationType] {
+			return nil, nil
+		}
+		f.notifications = append(f.notifications, notificationType)
+		return fakeResult([]driver.Value{
+			uuid.NewString(), time.Now(), args[0], notificationType, args[2], args[3], args[4], args[5], nil,
+		}), nil
+	})
+	return f
+}
+
+func TestNotifyFilters(t *testing.T) {
+	recipientID := uuid.New()
+	actorID := uuid.New()
+	follow := database.CreateNotificationParams{
+		UserID:  recipientID,
+		Type:    notificationFollow,
+		ActorID: uuid.NullUUID{UUID: actorID, Valid: true},
+	}
+
+	cases := []struct {
+		name     string
+		setup    func(f *fakeNotifications)
+		params   database.CreateNotificationParams
+		notified bool
+	}{
+		{name: "notified", params: follow, notified: true},
+		{
+			name:   "own action",
+			params: database.CreateNotificationParams{UserID: recipientID, Type: notificationFollow, ActorID: uuid.NullUUID{UUID: recipientID, Valid: true}},
+		},
+		{
+			name:   "actor blocked by recipient",
+			setup:  func(f *fakeNotifications) { f.blocks[[2]uuid.UUID{recipientID, actorID}] = true },
+			params: follow,
+		},
+		{
+			name:   "recipient blocked by actor",
+			setup:  func(f *fakeNotifications) { f.blocks[[2]uuid.UUID{actorID, recipientID}] = true },
+			params: follow,
+		},
+		{
+			name:   "actor muted by recipient",
+			setup:  func(f *fakeNotifications) { f.mutes[[2]uuid.UUID{recipientID, actorID}] = true },
+			params: follow,
+		},
+		{
+			name:     "recipient muted by actor",
+			setup:    func(f *fakeNotifications) { f.mutes[[2]uuid.UUID{actorID, recipientID}] = true },
+			params:   follow,
+			notified: true,
+		},
+		{
+			name:   "type turned off",
+			setup:  func(f *fakeNotifications) { f.disabled[notificationFollow] = true },
+			params: follow,
+		},
+		{
+			name:     "other type turned off",
+			setup:    func(f *fakeNotifications) { f.disabled[notificationMention] = true },
+			params:   follow,
+			notified: true,
+		},
+		{
+			name:     "no actor",
+			params:   database.CreateNotificationParams{UserID: recipientID, Type: notificationChirpyRed},
+			notified: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fake := newFakeNotifications()
+			if c.setup != nil {
+				c.setup(fake)
+			}
+			cfg := fake.config()
+
+			err := cfg.notify(context.Background(), events.Event{ID: uuid.New()}, c.params)
+			if err != nil {
+				t.Fatalf("Unable to notify: %v", err)
+			}
+			if notified := len(fake.notifications) == 1; notified != c.notified {
+				t.Errorf("Expected notified to be %v, got notifications %v", c.notified, fake.notifications)
+			}
+		})
+	}
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/lighthoof/Chirpy/internal/auth"
+	"github.com/lighthoof/Chirpy/internal/database"
+	"github.com/lighthoof/Chirpy/internal/oauth"
+)
+
+const consentPagePath = "/app/oauth/consent.html"
+
+// registerOAuthClientHandler registers a client for the user. Only first-party
+// sessions may do so, so a client cannot register further clients.
+func (cfg *apiConfig) registerOAuthClientHandler(w http.ResponseWriter, req *http.Request) {
+	reqBody := OAuthClient{}
+
+	_ = unmarshalType(req, &reqBody)
+
+	stringToken, err := auth.GetBearerToken(req.Header)
+	if err != nil {
+		log.Printf("Unable to get the token from request header: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusUnauthorized, "")
+		return
+	}
+
+	userID, err := auth.ValidateFirstPartyJWT(stringToken, cfg.secret)
+	if err != nil {
+		log.Printf("Unable to validate the token: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusUnauthorized, "")
+		return
+	}
+
+	redirectURI, err := url.Parse(reqBody.RedirectURI)
+	if err != nil || !redirectURI.IsAbs() || redirectURI.Fragment != "" {
+		log.Printf("Invalid redirect URI: %s", reqBody.RedirectURI)
+		respondWithError(w, http.StatusBadRequest, "Invalid redirect_uri")
+		return
+	}
+
+	scope, err := oauth.NormalizeScope(reqBody.Scope, strings.Join(oauth.SupportedScopes, " "))
+	if err != nil {
+		log.Printf("Invalid client scope: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	clientID, err := oauth.MakeRandomString(16)
+	if err != nil {
+		log.Printf("Unable to create client ID: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	hashedSecret := sql.NullString{}
+	clientSecret := ""
+	if reqBody.Confidential {
+		clientSecret, err = oauth.MakeRandomString(32)
+		if err != nil {
+			log.Printf("Unable to create client secret: %s", err)
+			respondWithError(w, http.StatusInternalServerError, "")
+			return
+		}
+		hashedSecret.String, err = cfg.hasher.Hash(clientSecret)
+		if err != nil {
+			log.Printf("Unable to hash the client secret: %s", err)
+			respondWithError(w, http.StatusInternalServerError, "")
+			return
+		}
+		hashedSecret.Valid = true
+	}
+
+	clientDb, err := cfg.dbQueries.CreateOAuthClient(req.Context(), database.CreateOAuthClientParams{
+		ID:           clientID,
+		Name:         reqBody.Name,
+		HashedSecret: hashedSecret,
+		RedirectUri:  redirectURI.String(),
+		Scope:        scope,
+		UserID:       userID,
+	})
+	if err != nil {
+		log.Printf("Unable to create OAuth client: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	client := OAuthClient{
+		ID:           clientDb.ID,
+		Secret:       clientSecret,
+		Name:         clientDb.Name,
+		RedirectURI:  clientDb.RedirectUri,
+		Scope:        clientDb.Scope,
+		Confidential: clientDb.HashedSecret.Valid,
+	}
+
+	respondWithJSON(w, http.StatusCreated, client)
+}
+
+// authorizeHandler validates an authorization request and hands it over to the
+// consent screen, which posts the user's decision back to approveHandler.
+func (cfg *apiConfig) authorizeHandler(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+
+	clientDb, err := cfg.validateAuthorizeRequest(req, query)
+	if err != nil {
+		log.Printf("Invalid authorization request: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	scope, err := oauth.NormalizeScope(query.Get("scope"), clientDb.Scope)
+	if err != nil {
+		redirectWithParams(w, req, clientDb.RedirectUri, url.Values{
+			"error": {"invalid_scope"},
+			"state": {query.Get("state")},
+		})
+		return
+	}
+
+	query.Set("scope", scope)
+	query.Set("client_name", clientDb.Name)
+	http.Redirect(w, req, consentPagePath+"?"+query.Encode(), http.StatusFound)
+}
+
+func (cfg *apiConfig) approveHandler(w http.ResponseWriter, req *http.Request) {
+	err := req.ParseForm()
+	if err != nil {
+		log.Printf("Unable to parse the form: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	clientDb, err := cfg.validateAuthorizeRequest(req, req.PostForm)
+	if err != nil {
+		log.Printf("Invalid authorization request: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	state := req.PostForm.Get("state")
+	if req.PostForm.Get("decision") != "approve" {
+		redirectWithParams(w, req, clientDb.RedirectUri, url.Values{
+			"error": {"access_denied"},
+			"state": {state},
+		})
+		return
+	}
+
+	scope, err := oauth.NormalizeScope(req.PostForm.Get("scope"), clientDb.Scope)
+	if err != nil {
+		redirectWithParams(w, req, clientDb.RedirectUri, url.Values{
+			"error": {"invalid_scope"},
+			"state": {state},
+		})
+		return
+	}
+
+	userDb, err := cfg.dbQueries.GetUserByEmail(req.Context(), req.PostForm.Get("email"))
+	if err != nil {
+		log.Printf("Unable to retrieve user with the e-mail: %s %s [%s]", req.Method, req.URL.Path, req.PostForm.Get("email"))
+		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password")
+		return
+	}
+
+	err = auth.CheckPasswordHash(userDb.HashedPassword, req.PostForm.Get("password"))
+	if err != nil {
+		log.Printf("Incorrect email or password: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password")
+		return
+	}
+
+	code, err := oauth.MakeRandomString(32)
+	if err != nil {
+		log.Printf("Unable to create authorization code: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	_, err = cfg.dbQueries.CreateOAuthCode(req.Context(), database.CreateOAuthCodeParams{
+		Code:          code,
+		ClientID:      clientDb.ID,
+		UserID:        userDb.ID,
+		RedirectUri:   clientDb.RedirectUri,
+		Scope:         scope,
+		CodeChallenge: req.PostForm.Get("code_challenge"),
+	})
+	if err != nil {
+		log.Printf("Unable to store authorization code: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	redirectWithParams(w, req, clientDb.RedirectUri, url.Values{
+		"code":  {code},
+		"state": {state},
+	})
+}
+
+func (cfg *apiConfig) tokenHandler(w http.ResponseWriter, req *http.Request) {
+	err := req.ParseForm()
+	if err != nil {
+		log.Printf("Unable to parse the form: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	clientDb, err := cfg.authenticateOAuthClient(req)
+	if err != nil {
+		log.Printf("Unable to authenticate OAuth client: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	var refreshTokenDb database.RefreshToken
+	switch req.PostForm.Get("grant_type") {
+	case "authorization_code":
+		codeDb, err := cfg.dbQueries.ConsumeOAuthCode(req.Context(), req.PostForm.Get("code"))
+		if err != nil {
+			log.Printf("Unable to consume authorization code: %s %s [%s]", req.Method, req.URL.Path, err)
+			respondWithError(w, http.StatusBadRequest, "invalid_grant")
+			return
+		}
+		if codeDb.ClientID != clientDb.ID || codeDb.RedirectUri != req.PostForm.Get("redirect_uri") {
+			log.Printf("Authorization code issued to another client: %s %s [%s]", req.Method, req.URL.Path, clientDb.ID)
+			respondWithError(w, http.StatusBadRequest, "invalid_grant")
+			return
+		}
+		err = oauth.VerifyPKCE(req.PostForm.Get("code_verifier"), codeDb.CodeChallenge)
+		if err != nil {
+			log.Printf("Unable to verify PKCE: %s %s [%s]", req.Method, req.URL.Path, err)
+			respondWithError(w, http.StatusBadRequest, "invalid_grant")
+			return
+		}
+
+		refreshToken, err := auth.MakeRefreshToken()
+		if err != nil {
+			log.Printf("Unable to create refresh token: %s %s [%s]", req.Method, req.URL.Path, err)
+			respondWithError(w, http.StatusInternalServerError, "server_error")
+			return
+		}
+		refreshTokenDb, err = cfg.dbQueries.StoreOAuthRefreshToken(req.Context(), database.StoreOAuthRefreshTokenParams{
+			Token:    refreshToken,
+			UserID:   codeDb.UserID,
+			ClientID: sql.NullString{String: clientDb.ID, Valid: true},
+			Scope:    codeDb.Scope,
+		})
+		if err != nil {
+			log.Printf("Unable to store new refresh token %s %s [%s]", req.Method, req.URL.Path, err)
+			respondWithError(w, http.StatusInternalServerError, "server_error")
+			return
+		}
+
+	case "refresh_token":
+		refreshTokenDb, err = cfg.dbQueries.GetOAuthRefreshToken(req.Context(), database.GetOAuthRefreshTokenParams{
+			Token:    req.PostForm.Get("refresh_token"),
+			ClientID: sql.NullString{String: clientDb.ID, Valid: true},
+		})
+		if err != nil {
+			log.Printf("Unable to get user by refresh token: %s %s [%s]", req.Method, req.URL.Path, err)
+			respondWithError(w, http.StatusBadRequest, "invalid_grant")
+			return
+		}
+
+	default:
+		log.Printf("Unsupported grant type: %s %s [%s]", req.Method, req.URL.Path, req.PostForm.Get("grant_type"))
+		respondWithError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+
+	token, err := auth.MakeScopedJWT(refreshTokenDb.UserID, cfg.secret, cfg.authExpiry, clientDb.ID, refreshTokenDb.Scope)
+	if err != nil {
+		log.Printf("Unable to create token for user: %s", refreshTokenDb.UserID)
+		respondWithError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, http.StatusOK, OAuthToken{
+		AccessToken:  token,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(cfg.authExpiry.Seconds()),
+		RefreshToken: refreshTokenDb.Token,
+		Scope:        refreshTokenDb.Scope,
+	})
+}
+
+// introspectHandler implements RFC 7662. Clients may only introspect tokens
+// that were issued to them; anything else is reported as inactive.
+func (cfg *apiConfig) introspectHandler(w http.ResponseWriter, req *http.Request) {
+	err := req.ParseForm()
+	if err != nil {
+		log.Printf("Unable to parse the form: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	clientDb, err := cfg.authenticateOAuthClient(req)
+	if err != nil {
+		log.Printf("Unable to authenticate OAuth client: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	token := req.PostForm.Get("token")
+
+	claims, err := auth.ParseScopedJWT(token, cfg.secret)
+	if err == nil && claims.ClientID == clientDb.ID {
+		respondWithJSON(w, http.StatusOK, Introspection{
+			Active:    true,
+			Scope:     claims.Scope,
+			ClientID:  claims.ClientID,
+			Subject:   claims.Subject,
+			ExpiresAt: claims.ExpiresAt.Unix(),
+			TokenType: "access_token",
+		})
+		return
+	}
+
+	refreshTokenDb, err := cfg.dbQueries.GetOAuthRefreshToken(req.Context(), database.GetOAuthRefreshTokenParams{
+		Token:    token,
+		ClientID: sql.NullString{String: clientDb.ID, Valid: true},
+	})
+	if err == nil {
+		respondWithJSON(w, http.StatusOK, Introspection{
+			Active:    true,
+			Scope:     refreshTokenDb.Scope,
+			ClientID:  refreshTokenDb.ClientID.String,
+			Subject:   refreshTokenDb.UserID.String(),
+			ExpiresAt: refreshTokenDb.ExpiresAt.Unix(),
+			TokenType: "refresh_token",
+		})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Introspection{Active: false})
+}
+
+func (cfg *apiConfig) validateAuthorizeRequest(req *http.Request, params url.Values) (database.OauthClient, error) {
+	if params.Get("response_type") != "code" {
+		return database.OauthClient{}, fmt.Errorf("unsupported_response_type")
+	}
+
+	clientDb, err := cfg.dbQueries.GetOAuthClient(req.Context(), params.Get("client_id"))
+	if err != nil {
+		return database.OauthClient{}, fmt.Errorf("invalid_client")
+	}
+
+	if params.Get("redirect_uri") != "" && params.Get("redirect_uri") != clientDb.RedirectUri {
+		return database.OauthClient{}, fmt.Errorf("invalid_redirect_uri")
+	}
+
+	if params.Get("code_challenge") == "" || params.Get("code_challenge_method") != "S256" {
+		return database.OauthClient{}, fmt.Errorf("invalid_request")
+	}
+
+	return clientDb, nil
+}
+
+// authenticateOAuthClient accepts client credentials either through HTTP
+// Basic auth or as form fields. Public clients only send their client_id.
+// Secrets are hashed like passwords and upgraded the same way.
+func (cfg *apiConfig) authenticateOAuthClient(req *http.Request) (database.OauthClient, error) {
+	clientID, clientSecret, ok := req.BasicAuth()
+	if !ok {
+		clientID = req.PostForm.Get("client_id")
+		clientSecret = req.PostForm.Get("client_secret")
+	}
+
+	clientDb, err := cfg.dbQueries.GetOAuthClient(req.Context(), clientID)
+	if err != nil {
+		return database.OauthClient{}, err
+	}
+
+	if clientDb.HashedSecret.Valid {
+		err = auth.CheckPasswordHash(clientDb.HashedSecret.String, clientSecret)
+		if err != nil {
+			return database.OauthClient{}, err
+		}
+		if cfg.hasher.NeedsRehash(clientDb.HashedSecret.String) {
+			cfg.rehashClientSecret(req, clientDb.ID, clientSecret)
+		}
+	}
+
+	return clientDb, nil
+}
+
+// rehashClientSecret upgrades the hash of a client secret like
+// rehashPassword does for passwords.
+func (cfg *apiConfig) rehashClientSecret(req *http.Request, clientID, clientSecret string) {
+	hashedSecret, err := cfg.hasher.Hash(clientSecret)
+	if err != nil {
+		log.Printf("Unable to rehash the client secret: %s", err)
+		return
+	}
+
+	err = cfg.dbQueries.UpdateOAuthClientSecret(req.Context(), database.UpdateOAuthClientSecretParams{
+		HashedSecret: sql.NullString{String: hashedSecret, Valid: true},
+		ID:           clientID,
+	})
+	if err != nil {
+		log.Printf("Unable to store rehashed client secret: %s %s [%s]", req.Method, req.URL.Path, err)
+	}
+}
+
+func redirectWithParams(w http.ResponseWriter, req *http.Request, target string, params url.Values) {
+	redirectURL, err := url.Parse(target)
+	if err != nil {
+		log.Printf("Unable to parse redirect URI: %s", target)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	query := redirectURL.Query()
+	for key, values := range params {
+		if len(values) > 0 && values[0] != "" {
+			query.Set(key, values[0])
+		}
+	}
+	redirectURL.RawQuery = query.Encode()
+
+	http.Redirect(w, req, redirectURL.String(), http.StatusFound)
+}
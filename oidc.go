@@ -0,0 +1,154 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/lighthoof/Chirpy/internal/auth"
+	"github.com/lighthoof/Chirpy/internal/database"
+	"github.com/lighthoof/Chirpy/internal/oauth"
+	"github.com/lighthoof/Chirpy/internal/oidc"
+)
+
+const (
+	oidcStateCookie = "chirpy_oidc_state"
+	oidcCookiePath  = "/api/login/oidc"
+)
+
+// oidcLoginHandler starts the authorization code flow with the external
+// provider. State and nonce are kept in a short-lived cookie until the
+// provider redirects back to oidcCallbackHandler.
+func (cfg *apiConfig) oidcLoginHandler(w http.ResponseWriter, req *http.Request) {
+	if cfg.oidcProvider == nil {
+		respondWithError(w, http.StatusNotFound, "")
+		return
+	}
+
+	state, err := oauth.MakeRandomString(16)
+	if err != nil {
+		log.Printf("Unable to create state: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+	nonce, err := oauth.MakeRandomString(16)
+	if err != nil {
+		log.Printf("Unable to create nonce: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state + "." + nonce,
+		Path:     oidcCookiePath,
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   cfg.platform != "dev",
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, req, cfg.oidcProvider.AuthCodeURL(state, nonce), http.StatusFound)
+}
+
+func (cfg *apiConfig) oidcCallbackHandler(w http.ResponseWriter, req *http.Request) {
+	if cfg.oidcProvider == nil {
+		respondWithError(w, http.StatusNotFound, "")
+		return
+	}
+
+	cookie, err := req.Cookie(oidcStateCookie)
+	if err != nil {
+		log.Printf("Missing OIDC state cookie: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusBadRequest, "Login session expired")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: oidcCookiePath, MaxAge: -1})
+
+	state, nonce, _ := strings.Cut(cookie.Value, ".")
+	if state == "" || req.URL.Query().Get("state") != state {
+		log.Printf("OIDC state mismatch: %s %s", req.Method, req.URL.Path)
+		respondWithError(w, http.StatusBadRequest, "Login session expired")
+		return
+	}
+
+	if req.URL.Query().Get("error") != "" {
+		log.Printf("OIDC provider returned an error: %s %s [%s]", req.Method, req.URL.Path, req.URL.Query().Get("error"))
+		respondWithError(w, http.StatusUnauthorized, req.URL.Query().Get("error"))
+		return
+	}
+
+	claims, err := cfg.oidcProvider.Exchange(req.Context(), req.URL.Query().Get("code"), nonce)
+	if err != nil {
+		log.Printf("Unable to verify OIDC login: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusUnauthorized, "")
+		return
+	}
+
+	userDb, err := cfg.userFromIdentity(req, claims)
+	if err != nil {
+		log.Printf("Unable to resolve external identity: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusForbidden, "Unable to sign in with this identity")
+		return
+	}
+
+	user, err := cfg.makeSession(req, userDb)
+	if err != nil {
+		log.Printf("Unable to create session: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, user)
+}
+
+// userFromIdentity returns the user linked to the external identity. Unknown
+// identities are linked to the user with the same e-mail, or to a new user,
+// but only when the provider has verified that e-mail.
+func (cfg *apiConfig) userFromIdentity(req *http.Request, claims oidc.IDTokenClaims) (database.User, error) {
+	provider := cfg.oidcProvider.Issuer
+
+	userDb, err := cfg.dbQueries.GetUserByIdentity(req.Context(), database.GetUserByIdentityParams{
+		Provider: provider,
+		Subject:  claims.Subject,
+	})
+	if err != sql.ErrNoRows {
+		return userDb, err
+	}
+
+	if !claims.EmailVerified || claims.Email == "" {
+		return database.User{}, fmt.Errorf("e-mail not verified by the provider: %s", claims.Email)
+	}
+
+	userDb, err = cfg.dbQueries.GetUserByEmail(req.Context(), claims.Email)
+	if err == sql.ErrNoRows {
+		// The random password is never shown to anyone, so the new user can
+		// only sign in through the provider until they set a password.
+		randomPassword, _ := auth.MakeRefreshToken()
+		hashedPassword, err := cfg.hasher.Hash(randomPassword)
+		if err != nil {
+			return database.User{}, err
+		}
+		userDb, err = cfg.dbQueries.CreateUser(req.Context(),
+			database.CreateUserParams{Email: claims.Email, HashedPassword: hashedPassword})
+		if err != nil {
+			return database.User{}, err
+		}
+	} else if err != nil {
+		return database.User{}, err
+	}
+
+	_, err = cfg.dbQueries.CreateUserIdentity(req.Context(), database.CreateUserIdentityParams{
+		Provider: provider,
+		Subject:  claims.Subject,
+		UserID:   userDb.ID,
+		Email:    claims.Email,
+	})
+	if err != nil {
+		return database.User{}, err
+	}
+
+	return userDb, nil
+}
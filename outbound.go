@@ -0,0 +1,393 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lighthoof/Chirpy/internal/database"
+	"github.com/lighthoof/Chirpy/internal/events"
+	"github.com/lighthoof/Chirpy/internal/oauth"
+	"github.com/lighthoof/Chirpy/internal/webhooks"
+)
+
+// Event types that can be delivered to outbound webhooks.
+var outboundEventTypes = []string{eventChirpCreated, eventChirpUpdated, eventChirpDeleted, eventChirpRestored, eventUserUpgraded}
+
+const (
+	// Deliveries claimed by a single run of runWebhookDispatcher.
+	dispatchBatchSize = 20
+	// A claimed delivery is offered again after the lease if the instance
+	// that claimed it never reported back.
+	dispatchLease = time.Minute
+)
+
+// enqueueWebhookDeliveries is the outbox subscriber of the outbound event
+// types. It stores a delivery of the event for every endpoint that subscribed
+// to its type: endpoints of the user the event concerns and the admin
+// endpoints. A delivery exists at most once per endpoint and event, so the
+// event may be relayed again safely.
+func (cfg *apiConfig) enqueueWebhookDeliveries(ctx context.Context, event events.Event) error {
+	payload, err := json.Marshal(OutboundEvent{
+		ID:        event.ID,
+		Type:      event.Type,
+		CreatedAt: event.CreatedAt,
+		Data:      json.RawMessage(event.Payload),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = cfg.dbQueries.EnqueueWebhookDeliveries(ctx, database.EnqueueWebhookDeliveriesParams{
+		EventID:   event.ID,
+		EventType: event.Type,
+		Payload:   string(payload),
+		UserID:    event.UserID,
+	})
+	return err
+}
+
+// runWebhookDispatcher sends due deliveries. Failed deliveries are retried
+// with exponential backoff until webhooks.MaxAttempts, after which they are
+// marked dead and only sent again when retried by hand.
+func (cfg *apiConfig) runWebhookDispatcher(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			deliveriesDb, err := cfg.dbQueries.ClaimWebhookDeliveries(ctx, database.ClaimWebhookDeliveriesParams{
+				LeaseSeconds: int32(dispatchLease.Seconds()),
+				Limit:        dispatchBatchSize,
+			})
+			if err != nil {
+				log.Printf("Unable to claim webhook deliveries: %s", err)
+				break
+			}
+
+			wg := sync.WaitGroup{}
+			for _, deliveryDb := range deliveriesDb {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					cfg.deliverWebhook(ctx, deliveryDb)
+				}()
+			}
+			wg.Wait()
+
+			if len(deliveriesDb) < dispatchBatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// deliverWebhook sends a claimed delivery. Endpoints of users are sent with
+// cfg.webhookSender, which cannot reach internal addresses; admin endpoints
+// may point at a local receiver.
+func (cfg *apiConfig) deliverWebhook(ctx context.Context, deliveryDb database.ClaimWebhookDeliveriesRow) {
+	sender := cfg.webhookSender
+	if !deliveryDb.UserID.Valid {
+		sender = cfg.adminWebhookSender
+	}
+
+	status, err := sender.Send(ctx, webhooks.Delivery{
+		ID:        deliveryDb.ID.String(),
+		URL:       deliveryDb.Url,
+		Secret:    deliveryDb.Secret,
+		EventType: deliveryDb.EventType,
+		Payload:   []byte(deliveryDb.Payload),
+	})
+	responseStatus := sql.NullInt32{Int32: int32(status), Valid: status != 0}
+
+	if err == nil {
+		err = cfg.dbQueries.MarkWebhookDelivered(ctx, database.MarkWebhookDeliveredParams{
+			ResponseStatus: responseStatus,
+			ID:             deliveryDb.ID,
+		})
+		if err != nil {
+			log.Printf("Unable to record webhook delivery %s: %s", deliveryDb.ID, err)
+		}
+		return
+	}
+
+	attempts := int(deliveryDb.Attempts) + 1
+	retry := sql.NullInt32{}
+	if attempts < webhooks.MaxAttempts {
+		retry = sql.NullInt32{Int32: int32(webhooks.Backoff(attempts).Seconds()), Valid: true}
+	} else {
+		log.Printf("Webhook delivery %s is dead after %d attempts: %s", deliveryDb.ID, attempts, err)
+	}
+
+	err = cfg.dbQueries.MarkWebhookFailed(ctx, database.MarkWebhookFailedParams{
+		RetrySeconds:   retry,
+		ResponseStatus: responseStatus,
+		LastError:      webhooks.ErrorCategory(status, err),
+		ID:             deliveryDb.ID,
+	})
+	if err != nil {
+		log.Printf("Unable to record webhook delivery %s: %s", deliveryDb.ID, err)
+	}
+}
+
+// webhookHandler serves the webhook endpoints of an owner: a user, or the
+// admins when owner is null.
+type webhookHandler func(w http.ResponseWriter, req *http.Request, owner uuid.NullUUID)
+
+func (cfg *apiConfig) userWebhooks(handler webhookHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		userID, ok := cfg.authenticate(w, req, oauth.ScopeWebhooks)
+		if !ok {
+			return
+		}
+		handler(w, req, uuid.NullUUID{UUID: userID, Valid: true})
+	}
+}
+
+func (cfg *apiConfig) adminWebhooks(handler webhookHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !cfg.authenticateAdmin(w, req) {
+			return
+		}
+		handler(w, req, uuid.NullUUID{})
+	}
+}
+
+// createWebhookEndpoint registers a receiver for the given event types. The
+// signing secret is only returned here.
+func (cfg *apiConfig) createWebhookEndpoint(w http.ResponseWriter, req *http.Request, owner uuid.NullUUID) {
+	reqBody := WebhookEndpoint{}
+
+	err := unmarshalType(req, &reqBody)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Malformed request body")
+		return
+	}
+
+	err = validateWebhookEndpoint(reqBody, !owner.Valid)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	secret, err := oauth.MakeRandomString(32)
+	if err != nil {
+		log.Printf("Unable to generate webhook secret: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	endpointDb, err := cfg.dbQueries.CreateWebhookEndpoint(req.Context(), database.CreateWebhookEndpointParams{
+		UserID:     owner,
+		Url:        reqBody.URL,
+		Secret:     "whsec_" + secret,
+		EventTypes: reqBody.Events,
+	})
+	if err != nil {
+		log.Printf("Unable to create webhook endpoint: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respBody := webhookEndpointFromDb(endpointDb)
+	respBody.Secret = endpointDb.Secret
+	respondWithJSON(w, http.StatusCreated, respBody)
+}
+
+func (cfg *apiConfig) getWebhookEndpoints(w http.ResponseWriter, req *http.Request, owner uuid.NullUUID) {
+	endpointsDb, err := cfg.dbQueries.GetWebhookEndpoints(req.Context(), owner)
+	if err != nil {
+		log.Printf("Unable to retrieve webhook endpoints: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respBody := []WebhookEndpoint{}
+	for _, endpointDb := range endpointsDb {
+		respBody = append(respBody, webhookEndpointFromDb(endpointDb))
+	}
+
+	respondWithJSON(w, http.StatusOK, respBody)
+}
+
+func (cfg *apiConfig) deleteWebhookEndpoint(w http.ResponseWriter, req *http.Request, owner uuid.NullUUID) {
+	webhookID, err := uuid.Parse(req.PathValue("webhookID"))
+	if err != nil {
+		log.Printf("Unable to parse webhookID: %s", req.PathValue("webhookID"))
+		respondWithError(w, http.StatusBadRequest, "")
+		return
+	}
+
+	deleted, err := cfg.dbQueries.DeleteWebhookEndpoint(req.Context(),
+		database.DeleteWebhookEndpointParams{ID: webhookID, UserID: owner})
+	if err != nil {
+		log.Printf("Unable to delete webhook endpoint: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+	if deleted == 0 {
+		respondWithError(w, http.StatusNotFound, "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
+
+// getWebhookDeliveries is the delivery log of an endpoint, newest first,
+// optionally filtered with ?status=dead.
+func (cfg *apiConfig) getWebhookDeliveries(w http.ResponseWriter, req *http.Request, owner uuid.NullUUID) {
+	endpointDb, ok := cfg.getOwnWebhookEndpoint(w, req, owner)
+	if !ok {
+		return
+	}
+
+	limit, offset, err := parsePagination(req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	params := database.GetWebhookDeliveriesParams{EndpointID: endpointDb.ID, Limit: limit, Offset: offset}
+	if status := req.URL.Query().Get("status"); status != "" {
+		params.Status = sql.NullString{String: status, Valid: true}
+	}
+
+	deliveriesDb, err := cfg.dbQueries.GetWebhookDeliveries(req.Context(), params)
+	if err != nil {
+		log.Printf("Unable to retrieve webhook deliveries: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respBody := []WebhookDelivery{}
+	for _, deliveryDb := range deliveriesDb {
+		respBody = append(respBody, webhookDeliveryFromDb(deliveryDb))
+	}
+
+	respondWithJSON(w, http.StatusOK, respBody)
+}
+
+// retryWebhookDelivery moves a dead delivery back to the queue with a fresh
+// set of attempts.
+func (cfg *apiConfig) retryWebhookDelivery(w http.ResponseWriter, req *http.Request, owner uuid.NullUUID) {
+	endpointDb, ok := cfg.getOwnWebhookEndpoint(w, req, owner)
+	if !ok {
+		return
+	}
+
+	deliveryID, err := uuid.Parse(req.PathValue("deliveryID"))
+	if err != nil {
+		log.Printf("Unable to parse deliveryID: %s", req.PathValue("deliveryID"))
+		respondWithError(w, http.StatusBadRequest, "")
+		return
+	}
+
+	deliveryDb, err := cfg.dbQueries.RetryWebhookDelivery(req.Context(),
+		database.RetryWebhookDeliveryParams{ID: deliveryID, EndpointID: endpointDb.ID})
+	if err == sql.ErrNoRows {
+		respondWithError(w, http.StatusConflict, "Only dead deliveries can be retried")
+		return
+	} else if err != nil {
+		log.Printf("Unable to retry webhook delivery: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, webhookDeliveryFromDb(deliveryDb))
+}
+
+func (cfg *apiConfig) getOwnWebhookEndpoint(w http.ResponseWriter, req *http.Request, owner uuid.NullUUID) (database.WebhookEndpoint, bool) {
+	webhookID, err := uuid.Parse(req.PathValue("webhookID"))
+	if err != nil {
+		log.Printf("Unable to parse webhookID: %s", req.PathValue("webhookID"))
+		respondWithError(w, http.StatusBadRequest, "")
+		return database.WebhookEndpoint{}, false
+	}
+
+	endpointDb, err := cfg.dbQueries.GetWebhookEndpoint(req.Context(),
+		database.GetWebhookEndpointParams{ID: webhookID, UserID: owner})
+	if err == sql.ErrNoRows {
+		log.Printf("Webhook endpoint not found")
+		respondWithError(w, http.StatusNotFound, "")
+		return database.WebhookEndpoint{}, false
+	} else if err != nil {
+		log.Printf("Unable to retrieve webhook endpoint: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return database.WebhookEndpoint{}, false
+	}
+
+	return endpointDb, true
+}
+
+// validateWebhookEndpoint checks a new endpoint. Users must use https and a
+// public address; plain http and internal addresses are left to admins, e.g.
+// for a local receiver. The error is meant for the client.
+func validateWebhookEndpoint(endpoint WebhookEndpoint, admin bool) error {
+	target, err := url.Parse(endpoint.URL)
+	if err != nil || target.Host == "" {
+		return fmt.Errorf("url must be an absolute URL")
+	}
+	if target.Scheme != "https" && !(admin && target.Scheme == "http") {
+		return fmt.Errorf("url must use https")
+	}
+	// Host names can only be checked when the delivery is dialed.
+	if addr, err := netip.ParseAddr(target.Hostname()); err == nil && !admin && !webhooks.AllowedAddress(addr) {
+		return fmt.Errorf("url must not point to a private address")
+	}
+	if len(endpoint.Events) == 0 {
+		return fmt.Errorf("events must list at least one event type")
+	}
+	for _, eventType := range endpoint.Events {
+		if !slices.Contains(outboundEventTypes, eventType) {
+			return fmt.Errorf("unknown event type: %s", eventType)
+		}
+	}
+	return nil
+}
+
+func webhookEndpointFromDb(endpointDb database.WebhookEndpoint) WebhookEndpoint {
+	return WebhookEndpoint{
+		ID:        endpointDb.ID,
+		CreatedAt: endpointDb.CreatedAt,
+		URL:       endpointDb.Url,
+		Events:    endpointDb.EventTypes,
+	}
+}
+
+func webhookDeliveryFromDb(deliveryDb database.WebhookDelivery) WebhookDelivery {
+	delivery := WebhookDelivery{
+		ID:        deliveryDb.ID,
+		CreatedAt: deliveryDb.CreatedAt,
+		EventID:   deliveryDb.EventID,
+		EventType: deliveryDb.EventType,
+		Status:    deliveryDb.Status,
+		Attempts:  deliveryDb.Attempts,
+		LastError: deliveryDb.LastError,
+		Payload:   json.RawMessage(deliveryDb.Payload),
+	}
+	if deliveryDb.Status == "pending" {
+		delivery.NextAttemptAt = &deliveryDb.NextAttemptAt
+	}
+	if deliveryDb.ResponseStatus.Valid {
+		delivery.ResponseStatus = &deliveryDb.ResponseStatus.Int32
+	}
+	if deliveryDb.DeliveredAt.Valid {
+		delivery.DeliveredAt = &deliveryDb.DeliveredAt.Time
+	}
+	return delivery
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lighthoof/Chirpy/internal/database"
+	"github.com/lighthoof/Chirpy/internal/events"
+)
+
+// Domain event types recorded in the outbox.
+const (
+	eventChirpCreated        = "chirp.created"
+	eventChirpDeleted        = "chirp.deleted"
+	eventChirpUpdated        = "chirp.updated"
+	eventChirpRestored       = "chirp.restored"
+	eventUserUpgraded        = "user.upgraded"
+	eventUserFollowed        = "user.followed"
+	eventSubscriptionChanged = "subscription.changed"
+	eventNotificationCreated = "notification.created"
+)
+
+const (
+	// Events relayed by a single claim of runOutboxRelay.
+	outboxBatchSize = 100
+	// A claimed event is claimed again after the lease if the instance that
+	// claimed it never reported back.
+	outboxLease = time.Minute
+	// An event that failed this many times is marked dead and no longer
+	// retried.
+	outboxMaxAttempts = 10
+	// Relayed events are kept this long before they are purged.
+	outboxRetention   = 7 * 24 * time.Hour
+	outboxPurgeEvery  = time.Hour
+	outboxMaxRetryGap = time.Hour
+)
+
+// recordEvent writes a domain event to the outbox. Callers pass the queries
+// of the transaction that makes the change, so the event exists exactly when
+// the change is committed. userID is the user the event concerns.
+func recordEvent(ctx context.Context, queries *database.Queries, eventType string, userID uuid.UUID, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	_, err = queries.WriteOutboxEvent(ctx, database.WriteOutboxEventParams{
+		EventType: eventType,
+		UserID:    userID,
+		Payload:   string(payload),
+	})
+	return err
+}
+
+// runOutboxRelay publishes outbox events to the subscribers of cfg.events in
+// the order they were recorded; an event that failed is retried later without
+// holding back the ones after it, until it is dead after outboxMaxAttempts.
+// An event is marked published only after every subscriber handled it, so a
+// crash or a failing subscriber means it is delivered again: subscribers see
+// every event at least once. Events are claimed for outboxLease in a short
+// transaction of their own, so each event is relayed by a single instance at
+// a time without holding row locks while subscribers run.
+func (cfg *apiConfig) runOutboxRelay(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lastPurge := time.Time{}
+	for {
+		for {
+			relayed, err := cfg.relayOutbox(ctx)
+			if err != nil {
+				log.Printf("Unable to relay outbox events: %s", err)
+				break
+			}
+			if relayed < outboxBatchSize {
+				break
+			}
+		}
+
+		if time.Since(lastPurge) > outboxPurgeEvery {
+			purged, err := cfg.dbQueries.PurgePublishedOutbox(ctx, int32(outboxRetention.Seconds()))
+			if err != nil {
+				log.Printf("Unable to purge outbox events: %s", err)
+			} else if purged > 0 {
+				log.Printf("Purged %d outbox events", purged)
+			}
+			lastPurge = time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (cfg *apiConfig) relayOutbox(ctx context.Context) (int, error) {
+	eventsDb, err := cfg.dbQueries.ClaimOutboxEvents(ctx, database.ClaimOutboxEventsParams{
+		LeaseSeconds: durationSeconds(outboxLease),
+		Limit:        outboxBatchSize,
+	})
+	if err != nil {
+		return 0, err
+	}
+	// RETURNING does not keep the order of the claim.
+	slices.SortFunc(eventsDb, func(a, b database.Outbox) int { return cmp.Compare(a.Seq, b.Seq) })
+
+	for _, eventDb := range eventsDb {
+		err = cfg.events.Publish(ctx, eventFromOutbox(eventDb))
+		if err != nil {
+			log.Printf("Unable to handle event %s (%s): %s", eventDb.ID, eventDb.EventType, err)
+			retry := outboxRetry(eventDb.Attempts + 1)
+			if !retry.Valid {
+				log.Printf("Event %s is dead after %d attempts", eventDb.ID, eventDb.Attempts+1)
+			}
+			err = cfg.dbQueries.MarkOutboxFailed(ctx, database.MarkOutboxFailedParams{
+				RetrySeconds: retry,
+				LastError:    err.Error(),
+				ID:           eventDb.ID,
+			})
+		} else {
+			err = cfg.markOutboxPublished(ctx, eventDb)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(eventsDb), nil
+}
+
+// markOutboxPublished marks the event published and gives it the next
+// publish sequence number, which stream clients resume from. The numbers are
+// handed out under a lock held until commit, so they follow commit order and
+// a client never sees an event before one with a lower number commits. Stream
+// events are announced in the same transaction; Postgres delivers the
+// notification on commit.
+func (cfg *apiConfig) markOutboxPublished(ctx context.Context, eventDb database.Outbox) error {
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	err = qtx.LockOutboxPublish(ctx)
+	if err != nil {
+		return err
+	}
+
+	publishSeq, err := qtx.MarkOutboxPublished(ctx, eventDb.ID)
+	if err != nil {
+		return err
+	}
+
+	if slices.Contains(streamEventTypes, eventDb.EventType) {
+		err = broadcastStreamEvent(ctx, qtx, publishSeq)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// outboxRetry is the delay before the next try of an event after the given
+// number of failed attempts, or null once the event is dead.
+func outboxRetry(attempts int32) sql.NullInt32 {
+	if attempts >= outboxMaxAttempts {
+		return sql.NullInt32{}
+	}
+	return sql.NullInt32{Int32: durationSeconds(outboxRetryDelay(attempts)), Valid: true}
+}
+
+// outboxRetryDelay grows linearly with the failed attempts, so a failing
+// subscriber is retried soon but does not keep the relay busy.
+func outboxRetryDelay(attempts int32) time.Duration {
+	delay := time.Duration(attempts) * 10 * time.Second
+	if delay > outboxMaxRetryGap {
+		return outboxMaxRetryGap
+	}
+	return delay
+}
+
+func eventFromOutbox(eventDb database.Outbox) events.Event {
+	return events.Event{
+		ID:        eventDb.ID,
+		Seq:       eventDb.Seq,
+		Type:      eventDb.EventType,
+		UserID:    eventDb.UserID,
+		CreatedAt: eventDb.CreatedAt,
+		Payload:   []byte(eventDb.Payload),
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOutboxRetry(t *testing.T) {
+	cases := []struct {
+		attempts int32
+		want     time.Duration
+		dead     bool
+	}{
+		{attempts: 1, want: 10 * time.Second},
+		{attempts: 3, want: 30 * time.Second},
+		{attempts: outboxMaxAttempts - 1, want: time.Duration(outboxMaxAttempts-1) * 10 * time.Second},
+		{attempts: outboxMaxAttempts, dead: true},
+		{attempts: outboxMaxAttempts + 5, dead: true},
+	}
+
+	for _, c := range cases {
+		retry := outboxRetry(c.attempts)
+		if c.dead {
+			if retry.Valid {
+				t.Errorf("Event was retried after %d attempts", c.attempts)
+			}
+			continue
+		}
+		if !retry.Valid || time.Duration(retry.Int32)*time.Second != c.want {
+			t.Errorf("Expected a retry in %s after %d attempts, got %v", c.want, c.attempts, retry)
+		}
+	}
+}
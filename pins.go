@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/lighthoof/Chirpy/internal/database"
+	"github.com/lighthoof/Chirpy/internal/oauth"
+)
+
+// pinChirpHandler pins one of the user's own chirps to their profile,
+// replacing any earlier pin. Pinning is a Chirpy Red feature.
+func (cfg *apiConfig) pinChirpHandler(w http.ResponseWriter, req *http.Request) {
+	reqBody := Pin{}
+
+	err := unmarshalType(req, &reqBody)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Malformed request body")
+		return
+	}
+
+	userID, ok := cfg.authenticate(w, req, oauth.ScopeChirpsWrite)
+	if !ok {
+		return
+	}
+
+	ent, err := cfg.userEntitlements(req.Context(), userID)
+	if err != nil {
+		log.Printf("Unable to retrieve entitlements: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+	if !ent.PinChirps {
+		respondWithError(w, http.StatusForbidden, "Pinning chirps requires Chirpy Red")
+		return
+	}
+
+	chirpDb, err := cfg.dbQueries.GetChirpById(req.Context(),
+		database.GetChirpByIdParams{ID: reqBody.ChirpID, ViewerID: uuid.NullUUID{UUID: userID, Valid: true}})
+	if err == sql.ErrNoRows {
+		log.Printf("Chirp not found")
+		respondWithError(w, http.StatusNotFound, "")
+		return
+	} else if err != nil {
+		log.Printf("Unable to retrieve chirp: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	if chirpDb.UserID != userID {
+		log.Printf("Unable to validate user")
+		respondWithError(w, http.StatusForbidden, "")
+		return
+	}
+	if !chirpDb.PublishedAt.Valid {
+		respondWithError(w, http.StatusBadRequest, "Scheduled chirps cannot be pinned")
+		return
+	}
+
+	err = cfg.dbQueries.PinChirp(req.Context(),
+		database.PinChirpParams{UserID: userID, ChirpID: chirpDb.ID})
+	if err != nil {
+		log.Printf("Unable to pin chirp: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
+
+func (cfg *apiConfig) unpinChirpHandler(w http.ResponseWriter, req *http.Request) {
+	userID, ok := cfg.authenticate(w, req, oauth.ScopeChirpsWrite)
+	if !ok {
+		return
+	}
+
+	err := cfg.dbQueries.UnpinChirp(req.Context(), userID)
+	if err != nil {
+		log.Printf("Unable to unpin chirp: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
+
+// pinFirst moves the author's pinned chirp to the front of the chirps and
+// marks it as pinned. Nothing changes if the pinned chirp is not among them.
+func (cfg *apiConfig) pinFirst(ctx context.Context, authorID uuid.UUID, chirps []Chirp) error {
+	pinnedID, err := cfg.dbQueries.GetPinnedChirpID(ctx, authorID)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for i := range chirps {
+		if chirps[i].ID == pinnedID {
+			pinned := chirps[i]
+			pinned.Pinned = true
+			copy(chirps[1:i+1], chirps[:i])
+			chirps[0] = pinned
+			return nil
+		}
+	}
+	return nil
+}
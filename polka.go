@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lighthoof/Chirpy/internal/auth"
+	"github.com/lighthoof/Chirpy/internal/database"
+)
+
+const polkaProvider = "polka"
+
+// Polka event types.
+const (
+	polkaUserUpgraded         = "user.upgraded"
+	polkaUserDowngraded       = "user.downgraded"
+	polkaSubscriptionRenewed  = "subscription.renewed"
+	polkaSubscriptionCanceled = "subscription.canceled"
+	polkaPaymentFailed        = "payment.failed"
+)
+
+// Webhook event statuses. Events are stored as "received" until processed.
+// An event still "received" after webhookStaleAfter was abandoned mid-way,
+// for example by a crash, and may be claimed again.
+const (
+	webhookProcessed = "processed"
+	webhookIgnored   = "ignored"
+	webhookFailed    = "failed"
+
+	webhookStaleAfter = 5 * time.Minute
+)
+
+var (
+	errWebhookIgnored      = errors.New("event type is not handled")
+	errWebhookUnknownUser  = errors.New("user not found")
+	errWebhookInvalidEvent = errors.New("invalid event data")
+)
+
+// polkaWebhookHandler records every Polka webhook in webhook_events before
+// processing it. A delivery of an event that was already handled is
+// acknowledged without being processed again; a failed or abandoned event is
+// retried.
+// With POLKA_WEBHOOK_SECRETS set, requests must carry a valid
+// Polka-Signature header; otherwise the legacy static API key is accepted.
+func (cfg *apiConfig) polkaWebhookHandler(w http.ResponseWriter, req *http.Request) {
+	reqBody := Event{}
+
+	payload, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxWebhookSize))
+	if err != nil {
+		log.Printf("Unable to read the webhook: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusBadRequest, "")
+		return
+	}
+
+	if !cfg.verifyPolkaRequest(req, payload) {
+		respondWithError(w, http.StatusUnauthorized, "")
+		return
+	}
+
+	err = json.Unmarshal(payload, &reqBody)
+	if err != nil {
+		log.Printf("Unable to unmarshal the webhook: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusBadRequest, "")
+		return
+	}
+
+	eventID := polkaEventKey(req, reqBody, payload)
+
+	eventDb, err := cfg.dbQueries.RecordWebhookEvent(req.Context(), database.RecordWebhookEventParams{
+		Provider:  polkaProvider,
+		EventID:   eventID,
+		EventType: reqBody.Event,
+		Payload:   string(payload),
+	})
+	if err == sql.ErrNoRows {
+		eventDb, err = cfg.claimDuplicateEvent(req.Context(), eventID)
+		if err == sql.ErrNoRows {
+			log.Printf("Duplicate webhook event %s acknowledged", eventID)
+			respondWithJSON(w, http.StatusNoContent, "")
+			return
+		}
+	}
+	if err != nil {
+		log.Printf("Unable to record webhook event: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	err = cfg.processWebhookEvent(req.Context(), eventDb)
+	if errors.Is(err, errWebhookUnknownUser) {
+		respondWithError(w, http.StatusNotFound, "")
+		return
+	} else if errors.Is(err, errWebhookInvalidEvent) {
+		respondWithError(w, http.StatusBadRequest, "")
+		return
+	} else if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
+
+// polkaEventKey identifies a delivery in webhook_events. Polka's event ID is
+// used when there is one. Otherwise a signed delivery is keyed by its
+// signature timestamp and body, so a retried delivery is recognised but a new
+// event with the same body, like a second renewal, is not mistaken for it.
+// Unsigned deliveries without an ID cannot be told apart and are never
+// treated as duplicates.
+func polkaEventKey(req *http.Request, event Event, payload []byte) string {
+	if event.ID != "" {
+		return event.ID
+	}
+
+	timestamp, err := auth.WebhookTimestamp(req.Header.Get("Polka-Signature"))
+	if err != nil {
+		return "unkeyed:" + uuid.NewString()
+	}
+	signed := strconv.FormatInt(timestamp.Unix(), 10) + "." + string(payload)
+	return "sha256:" + auth.HashToken(signed)
+}
+
+// claimDuplicateEvent returns the stored event if it failed or was abandoned
+// and may be processed again, or sql.ErrNoRows if it was handled already or
+// is still being processed.
+func (cfg *apiConfig) claimDuplicateEvent(ctx context.Context, eventID string) (database.WebhookEvent, error) {
+	eventDb, err := cfg.dbQueries.GetWebhookEvent(ctx,
+		database.GetWebhookEventParams{Provider: polkaProvider, EventID: eventID})
+	if err != nil {
+		return database.WebhookEvent{}, err
+	}
+	return cfg.dbQueries.ClaimWebhookEvent(ctx, database.ClaimWebhookEventParams{
+		ID:           eventDb.ID,
+		StaleSeconds: durationSeconds(webhookStaleAfter),
+	})
+}
+
+// processWebhookEvent applies the stored event and records the outcome. A
+// processed or ignored outcome is written in the same transaction as the
+// changes of the event, so the event cannot be applied without being marked
+// as done. A failure is recorded after the changes are rolled back.
+func (cfg *apiConfig) processWebhookEvent(ctx context.Context, eventDb database.WebhookEvent) error {
+	err := cfg.applyWebhookEvent(ctx, eventDb)
+	if err == nil {
+		return nil
+	}
+
+	log.Printf("Unable to process webhook event %s: %s", eventDb.EventID, err)
+	finishErr := cfg.dbQueries.FinishWebhookEvent(ctx, database.FinishWebhookEventParams{
+		ID:     eventDb.ID,
+		Status: webhookFailed,
+		Error:  err.Error(),
+	})
+	if finishErr != nil {
+		// The event stays received and is claimed again once stale.
+		log.Printf("Unable to record webhook event %s: %s", eventDb.EventID, finishErr)
+	}
+	return err
+}
+
+func (cfg *apiConfig) applyWebhookEvent(ctx context.Context, eventDb database.WebhookEvent) error {
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	userID, err := applyPolkaEvent(ctx, qtx, []byte(eventDb.Payload))
+	finish := database.FinishWebhookEventParams{ID: eventDb.ID, Status: webhookProcessed, UserID: userID}
+	if errors.Is(err, errWebhookIgnored) {
+		finish.Status = webhookIgnored
+	} else if err != nil {
+		return err
+	}
+
+	err = qtx.FinishWebhookEvent(ctx, finish)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// applyPolkaEvent carries out a Polka event with the queries of a transaction
+// and returns the user it concerns.
+func applyPolkaEvent(ctx context.Context, queries *database.Queries, payload []byte) (uuid.NullUUID, error) {
+	event := Event{}
+	err := json.Unmarshal(payload, &event)
+	if err != nil {
+		return uuid.NullUUID{}, fmt.Errorf("%w: %s", errWebhookInvalidEvent, err)
+	}
+
+	switch event.Event {
+	case polkaUserUpgraded, polkaUserDowngraded, polkaSubscriptionRenewed, polkaSubscriptionCanceled, polkaPaymentFailed:
+	default:
+		return uuid.NullUUID{}, errWebhookIgnored
+	}
+
+	userID, err := uuid.Parse(event.Data.User_id)
+	if err != nil {
+		return uuid.NullUUID{}, fmt.Errorf("%w: %s", errWebhookInvalidEvent, err)
+	}
+
+	_, err = queries.GetUserById(ctx, userID)
+	if err == sql.ErrNoRows {
+		return uuid.NullUUID{}, fmt.Errorf("%w: %s", errWebhookUnknownUser, userID)
+	} else if err != nil {
+		return uuid.NullUUID{}, err
+	}
+
+	user := uuid.NullUUID{UUID: userID, Valid: true}
+	return user, applySubscriptionEvent(ctx, queries, userID, event)
+}
+
+// verifyPolkaRequest requires a valid signature. The legacy ApiKey header,
+// which carries no timestamp and can be replayed, is only accepted when no
+// secrets are configured and POLKA_ALLOW_API_KEY opts in.
+func (cfg *apiConfig) verifyPolkaRequest(req *http.Request, payload []byte) bool {
+	if len(cfg.polkaVerifier.Secrets) > 0 {
+		err := cfg.polkaVerifier.Verify(req.Header.Get("Polka-Signature"), payload)
+		if err != nil {
+			log.Printf("Invalid Polka signature: %s %s [%s]", req.Method, req.URL.Path, err)
+			return false
+		}
+		return true
+	}
+	if !cfg.polkaAllowKey {
+		log.Printf("Unable to verify Polka webhook without signing secrets: %s %s", req.Method, req.URL.Path)
+		return false
+	}
+
+	reqAPIKey, err := auth.GetAPIKey(req.Header)
+	if err != nil {
+		log.Printf("Unable to parse Polka API Key: %s %s [%s]", req.Method, req.URL.Path, err)
+		return false
+	}
+	if cfg.polkaAPIKey == "" || subtle.ConstantTimeCompare([]byte(reqAPIKey), []byte(cfg.polkaAPIKey)) != 1 {
+		log.Printf("Incorrect Polka API Key: %s %s", req.Method, req.URL.Path)
+		return false
+	}
+	return true
+}
+
+// getWebhookEventsHandler lists recorded webhook events, optionally filtered
+// with ?status=failed.
+func (cfg *apiConfig) getWebhookEventsHandler(w http.ResponseWriter, req *http.Request) {
+	if !cfg.authenticateAdmin(w, req) {
+		return
+	}
+
+	limit, offset, err := parsePagination(req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	params := database.ListWebhookEventsParams{Limit: limit, Offset: offset}
+	if status := req.URL.Query().Get("status"); status != "" {
+		params.Status = sql.NullString{String: status, Valid: true}
+	}
+
+	eventsDb, err := cfg.dbQueries.ListWebhookEvents(req.Context(), params)
+	if err != nil {
+		log.Printf("Unable to retrieve webhook events: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respBody := []WebhookEvent{}
+	for _, eventDb := range eventsDb {
+		respBody = append(respBody, webhookEventFromDb(eventDb))
+	}
+
+	respondWithJSON(w, http.StatusOK, respBody)
+}
+
+// replayWebhookEventHandler processes a failed or abandoned event again from
+// its stored payload and returns the event with the new outcome.
+func (cfg *apiConfig) replayWebhookEventHandler(w http.ResponseWriter, req *http.Request) {
+	if !cfg.authenticateAdmin(w, req) {
+		return
+	}
+
+	eventID, err := uuid.Parse(req.PathValue("eventID"))
+	if err != nil {
+		log.Printf("Unable to parse eventID: %s", req.PathValue("eventID"))
+		respondWithError(w, http.StatusBadRequest, "")
+		return
+	}
+
+	eventDb, err := cfg.dbQueries.ClaimWebhookEvent(req.Context(), database.ClaimWebhookEventParams{
+		ID:           eventID,
+		StaleSeconds: durationSeconds(webhookStaleAfter),
+	})
+	if err == sql.ErrNoRows {
+		respondWithError(w, http.StatusConflict, "Only failed or abandoned events can be replayed")
+		return
+	} else if err != nil {
+		log.Printf("Unable to claim webhook event: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	_ = cfg.processWebhookEvent(req.Context(), eventDb)
+
+	eventDb, err = cfg.dbQueries.GetWebhookEventById(req.Context(), eventID)
+	if err != nil {
+		log.Printf("Unable to retrieve webhook event: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, webhookEventFromDb(eventDb))
+}
+
+func webhookEventFromDb(eventDb database.WebhookEvent) WebhookEvent {
+	event := WebhookEvent{
+		ID:        eventDb.ID,
+		CreatedAt: eventDb.CreatedAt,
+		UpdatedAt: eventDb.UpdatedAt,
+		Provider:  eventDb.Provider,
+		EventID:   eventDb.EventID,
+		EventType: eventDb.EventType,
+		Status:    eventDb.Status,
+		Error:     eventDb.Error,
+		Attempts:  eventDb.Attempts,
+		Payload:   json.RawMessage(eventDb.Payload),
+	}
+	if eventDb.ProcessedAt.Valid {
+		event.ProcessedAt = &eventDb.ProcessedAt.Time
+	}
+	return event
+}
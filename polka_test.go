@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lighthoof/Chirpy/internal/auth"
+)
+
+func TestPolkaEventKey(t *testing.T) {
+	payload := []byte(`{"event":"subscription.renewed","data":{"user_id":"60a9b112-00f4-46bb-9e33-9b4004349d62"}}`)
+	now := time.Unix(1700000000, 0)
+
+	signed := func(timestamp time.Time) string {
+		req := httptest.NewRequest("POST", "/api/polka/webhooks", nil)
+		req.Header.Set("Polka-Signature", auth.SignWebhook("s3cret", timestamp, payload))
+		return polkaEventKey(req, Event{}, payload)
+	}
+	unsigned := func() string {
+		req := httptest.NewRequest("POST", "/api/polka/webhooks", nil)
+		return polkaEventKey(req, Event{}, payload)
+	}
+
+	if key := polkaEventKey(httptest.NewRequest("POST", "/", nil), Event{ID: "evt_1"}, payload); key != "evt_1" {
+		t.Errorf("Expected the provider event ID, got %s", key)
+	}
+	if signed(now) != signed(now) {
+		t.Errorf("Retried delivery got a different key")
+	}
+	if signed(now) == signed(now.Add(time.Hour)) {
+		t.Errorf("Repeated event got the key of the earlier one")
+	}
+	if unsigned() == unsigned() {
+		t.Errorf("Unsigned deliveries without an ID were treated as duplicates")
+	}
+}
+
+func TestVerifyPolkaRequest(t *testing.T) {
+	payload := []byte(`{"event":"user.upgraded","data":{"user_id":"60a9b112-00f4-46bb-9e33-9b4004349d62"}}`)
+	signature := auth.SignWebhook("s3cret", time.Now(), payload)
+	verifier := auth.WebhookVerifier{Secrets: []string{"s3cret"}, Tolerance: 5 * time.Minute}
+
+	cases := []struct {
+		name      string
+		verifier  auth.WebhookVerifier
+		allowKey  bool
+		signature string
+		apiKey    string
+		want      bool
+	}{
+		{name: "signed", verifier: verifier, signature: signature, want: true},
+		{name: "bad signature", verifier: verifier, signature: auth.SignWebhook("other", time.Now(), payload)},
+		{name: "API key with secrets", verifier: verifier, allowKey: true, apiKey: "polka"},
+		{name: "API key without opt-in", apiKey: "polka"},
+		{name: "API key with opt-in", allowKey: true, apiKey: "polka", want: true},
+		{name: "wrong API key with opt-in", allowKey: true, apiKey: "wrong"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cfg := &apiConfig{polkaAPIKey: "polka", polkaVerifier: c.verifier, polkaAllowKey: c.allowKey}
+			req := httptest.NewRequest("POST", "/api/polka/webhooks", nil)
+			if c.signature != "" {
+				req.Header.Set("Polka-Signature", c.signature)
+			}
+			if c.apiKey != "" {
+				req.Header.Set("Authorization", "ApiKey "+c.apiKey)
+			}
+
+			if got := cfg.verifyPolkaRequest(req, payload); got != c.want {
+				t.Errorf("Expected %v, got %v", c.want, got)
+			}
+		})
+	}
+}
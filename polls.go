@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lighthoof/Chirpy/internal/auth"
+	"github.com/lighthoof/Chirpy/internal/database"
+	"github.com/lighthoof/Chirpy/internal/oauth"
+)
+
+const (
+	minPollOptions      = 2
+	maxPollOptions      = 4
+	maxPollOptionLength = 25
+	maxPollDuration     = 7 * 24 * time.Hour
+)
+
+// validatePoll checks the poll part of a new chirp. The error is meant for the
+// client.
+func validatePoll(poll *Poll) error {
+	if len(poll.Options) < minPollOptions || len(poll.Options) > maxPollOptions {
+		return fmt.Errorf("a poll needs %d to %d options", minPollOptions, maxPollOptions)
+	}
+	seen := map[string]bool{}
+	for _, option := range poll.Options {
+		text := strings.TrimSpace(option.Text)
+		if text == "" {
+			return fmt.Errorf("poll options cannot be empty")
+		}
+		if len([]rune(text)) > maxPollOptionLength {
+			return fmt.Errorf("poll option is too long")
+		}
+		if seen[strings.ToLower(text)] {
+			return fmt.Errorf("poll options must be different")
+		}
+		seen[strings.ToLower(text)] = true
+	}
+
+	duration := time.Until(poll.ClosesAt)
+	if duration <= 0 {
+		return fmt.Errorf("poll must close in the future")
+	}
+	if duration > maxPollDuration {
+		return fmt.Errorf("poll cannot stay open longer than %s", maxPollDuration)
+	}
+	return nil
+}
+
+// createPoll stores the poll of a chirp with the given queries, so it is
+// created in the same transaction as the chirp.
+func createPoll(ctx context.Context, queries *database.Queries, chirpID uuid.UUID, poll *Poll) error {
+	pollDb, err := queries.CreatePoll(ctx, database.CreatePollParams{
+		ChirpID:         chirpID,
+		DurationSeconds: int32(time.Until(poll.ClosesAt).Seconds()),
+	})
+	if err != nil {
+		return err
+	}
+
+	for position, option := range poll.Options {
+		_, err = queries.CreatePollOption(ctx, database.CreatePollOptionParams{
+			PollID:   pollDb.ID,
+			Position: int32(position),
+			Text:     strings.TrimSpace(option.Text),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// voteHandler records the vote of the user on the poll of a chirp. Every user
+// gets a single vote, which cannot be changed.
+func (cfg *apiConfig) voteHandler(w http.ResponseWriter, req *http.Request) {
+	reqBody := PollVote{}
+
+	err := unmarshalType(req, &reqBody)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Malformed request body")
+		return
+	}
+
+	stringToken, err := auth.GetBearerToken(req.Header)
+	if err != nil {
+		log.Printf("Unable to get the token from request header: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusUnauthorized, "")
+		return
+	}
+
+	userID, err := auth.ValidateJWTScope(stringToken, cfg.secret, oauth.ScopeChirpsWrite)
+	if err != nil {
+		log.Printf("Unable to validate the token: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusUnauthorized, "")
+		return
+	}
+
+	chirpID, err := uuid.Parse(req.PathValue("chirpID"))
+	if err != nil {
+		log.Printf("Unable to parse chirpID: %s", req.PathValue("chirpID"))
+		respondWithError(w, http.StatusBadRequest, "")
+		return
+	}
+
+	pollDb, err := cfg.dbQueries.GetPollByChirp(req.Context(), chirpID)
+	if err == sql.ErrNoRows {
+		log.Printf("Poll not found")
+		respondWithError(w, http.StatusNotFound, "")
+		return
+	} else if err != nil {
+		log.Printf("Unable to retrieve poll: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	if pollDb.Closed {
+		respondWithError(w, http.StatusBadRequest, "Poll is closed")
+		return
+	}
+
+	chirpDb, err := cfg.dbQueries.GetChirpById(req.Context(),
+		database.GetChirpByIdParams{ID: pollDb.ChirpID, ViewerID: uuid.NullUUID{UUID: userID, Valid: true}})
+	if err == sql.ErrNoRows || (err == nil && !chirpDb.PublishedAt.Valid) {
+		log.Printf("Chirp not found")
+		respondWithError(w, http.StatusNotFound, "")
+		return
+	} else if err != nil {
+		log.Printf("Unable to retrieve chirp: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	err = cfg.checkInteraction(req.Context(), userID, chirpDb.UserID)
+	if errors.Is(err, errBlocked) {
+		respondWithError(w, http.StatusForbidden, "Unable to vote on this chirp")
+		return
+	} else if err != nil {
+		log.Printf("Unable to check blocks: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	voted, err := cfg.dbQueries.CreatePollVote(req.Context(), database.CreatePollVoteParams{
+		UserID:   userID,
+		OptionID: reqBody.OptionID,
+		PollID:   pollDb.ID,
+	})
+	if isForeignKeyViolation(err) {
+		log.Printf("Unknown poll option: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusBadRequest, "Unknown poll option")
+		return
+	} else if err != nil {
+		log.Printf("Unable to record vote: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	// Nothing was inserted, either because the user already voted or because
+	// the poll closed in the meantime.
+	if voted == 0 {
+		respondWithError(w, http.StatusConflict, "Already voted or poll closed")
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
+
+// loadChirpPolls fills in the polls of the chirps. The counts are only shown
+// to a viewer who has voted, or to everyone once the poll is closed.
+func (cfg *apiConfig) loadChirpPolls(ctx context.Context, queries *database.Queries, chirps []*Chirp, viewer uuid.NullUUID) error {
+	if len(chirps) == 0 {
+		return nil
+	}
+
+	chirpIDs := []uuid.UUID{}
+	byChirpID := map[uuid.UUID]*Chirp{}
+	for _, chirp := range chirps {
+		chirpIDs = append(chirpIDs, chirp.ID)
+		byChirpID[chirp.ID] = chirp
+		chirp.Poll = nil
+	}
+
+	pollsDb, err := queries.GetPollsForChirps(ctx, chirpIDs)
+	if err != nil {
+		return err
+	}
+	if len(pollsDb) == 0 {
+		return nil
+	}
+
+	pollIDs := []uuid.UUID{}
+	byPollID := map[uuid.UUID]*Poll{}
+	for _, pollDb := range pollsDb {
+		poll := &Poll{
+			ID:       pollDb.ID,
+			ClosesAt: pollDb.ClosesAt,
+			Closed:   pollDb.Closed,
+			Options:  []PollOption{},
+		}
+		pollIDs = append(pollIDs, pollDb.ID)
+		byPollID[pollDb.ID] = poll
+		byChirpID[pollDb.ChirpID].Poll = poll
+	}
+
+	if viewer.Valid {
+		votesDb, err := queries.GetUserVotes(ctx, database.GetUserVotesParams{
+			UserID:  viewer.UUID,
+			PollIds: pollIDs,
+		})
+		if err != nil {
+			return err
+		}
+		for _, voteDb := range votesDb {
+			optionID := voteDb.OptionID
+			byPollID[voteDb.PollID].VotedOptionID = &optionID
+		}
+	}
+
+	resultsDb, err := queries.GetPollResults(ctx, pollIDs)
+	if err != nil {
+		return err
+	}
+
+	for _, resultDb := range resultsDb {
+		poll := byPollID[resultDb.PollID]
+		option := PollOption{ID: resultDb.ID, Text: resultDb.Text}
+		if poll.Closed || poll.VotedOptionID != nil {
+			votes := resultDb.Votes
+			option.Votes = &votes
+			if poll.TotalVotes == nil {
+				poll.TotalVotes = new(int64)
+			}
+			*poll.TotalVotes += votes
+		}
+		poll.Options = append(poll.Options, option)
+	}
+
+	return nil
+}
+
+// chirpViewer returns the user behind an optional bearer token. Requests
+// without a valid token are treated as anonymous.
+func (cfg *apiConfig) chirpViewer(req *http.Request) uuid.NullUUID {
+	stringToken, err := auth.GetBearerToken(req.Header)
+	if err != nil {
+		return uuid.NullUUID{}
+	}
+
+	userID, err := auth.ValidateJWTScope(stringToken, cfg.secret, oauth.ScopeChirpsRead)
+	if err != nil {
+		return uuid.NullUUID{}
+	}
+
+	return uuid.NullUUID{UUID: userID, Valid: true}
+}
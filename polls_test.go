@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidatePoll(t *testing.T) {
+	options := func(texts ...string) []PollOption {
+		pollOptions := []PollOption{}
+		for _, text := range texts {
+			pollOptions = append(pollOptions, PollOption{Text: text})
+		}
+		return pollOptions
+	}
+	tomorrow := time.Now().Add(24 * time.Hour)
+
+	cases := []struct {
+		name  string
+		poll  Poll
+		valid bool
+	}{
+		{
+			name:  "valid",
+			poll:  Poll{Options: options("Yes", "No"), ClosesAt: tomorrow},
+			valid: true,
+		},
+		{
+			name:  "most options",
+			poll:  Poll{Options: options("A", "B", "C", "D"), ClosesAt: tomorrow},
+			valid: true,
+		},
+		{
+			name: "single option",
+			poll: Poll{Options: options("Yes"), ClosesAt: tomorrow},
+		},
+		{
+			name: "too many options",
+			poll: Poll{Options: options("A", "B", "C", "D", "E"), ClosesAt: tomorrow},
+		},
+		{
+			name: "blank option",
+			poll: Poll{Options: options("Yes", "  "), ClosesAt: tomorrow},
+		},
+		{
+			name: "option too long",
+			poll: Poll{Options: options("Yes", strings.Repeat("x", maxPollOptionLength+1)), ClosesAt: tomorrow},
+		},
+		{
+			name: "duplicate options",
+			poll: Poll{Options: options("Yes", " yes"), ClosesAt: tomorrow},
+		},
+		{
+			name: "closes in the past",
+			poll: Poll{Options: options("Yes", "No"), ClosesAt: time.Now().Add(-time.Minute)},
+		},
+		{
+			name: "open too long",
+			poll: Poll{Options: options("Yes", "No"), ClosesAt: time.Now().Add(maxPollDuration + time.Hour)},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := validatePoll(&c.poll)
+			if c.valid && err != nil {
+				t.Errorf("Valid poll was rejected: %v", err)
+			}
+			if !c.valid && err == nil {
+				t.Errorf("Invalid poll was accepted!")
+			}
+		})
+	}
+}
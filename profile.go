@@ -0,0 +1,256 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/lighthoof/Chirpy/internal/auth"
+	"github.com/lighthoof/Chirpy/internal/database"
+	"github.com/lighthoof/Chirpy/internal/oauth"
+)
+
+const (
+	maxDisplayNameLength = 50
+	maxBioLength         = 160
+)
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_]{3,30}$`)
+
+// patchUserHandler updates only the fields present in the request. Changing
+// the e-mail or password requires the current password, and a password
+// change revokes every existing session before a fresh one is issued.
+func (cfg *apiConfig) patchUserHandler(w http.ResponseWriter, req *http.Request) {
+	reqBody := UserPatch{}
+
+	err := unmarshalType(req, &reqBody)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Malformed request body")
+		return
+	}
+
+	stringToken, err := auth.GetBearerToken(req.Header)
+	if err != nil {
+		log.Printf("Unable to get the token from request header: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusUnauthorized, "")
+		return
+	}
+
+	userID, err := auth.ValidateJWTScope(stringToken, cfg.secret, oauth.ScopeProfile)
+	if err != nil {
+		log.Printf("Unable to validate the token: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusUnauthorized, "")
+		return
+	}
+
+	userDb, err := cfg.dbQueries.GetUserById(req.Context(), userID)
+	if err != nil {
+		log.Printf("Unable to retrieve user: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusUnauthorized, "")
+		return
+	}
+
+	patch := database.PatchUserParams{ID: userID}
+
+	if reqBody.Email != nil || reqBody.Password != nil {
+		err = auth.CheckPasswordHash(userDb.HashedPassword, reqBody.CurrentPassword)
+		if err != nil {
+			log.Printf("Incorrect current password: %s %s [%s]", req.Method, req.URL.Path, err)
+			respondWithError(w, http.StatusForbidden, "Current password is incorrect")
+			return
+		}
+	}
+
+	if reqBody.Email != nil {
+		if len(strings.Split(*reqBody.Email, "@")) < 2 {
+			log.Printf("Invalid e-mail: %s", *reqBody.Email)
+			respondWithError(w, http.StatusBadRequest, "Invalid e-mail")
+			return
+		}
+		patch.Email = sql.NullString{String: *reqBody.Email, Valid: true}
+	}
+
+	if reqBody.Password != nil {
+		err = cfg.passwordPolicy.Check(*reqBody.Password)
+		if err != nil {
+			log.Printf("Password rejected by policy: %s %s [%s]", req.Method, req.URL.Path, err)
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		hashedPassword, err := cfg.hasher.Hash(*reqBody.Password)
+		if err != nil {
+			log.Printf("Unable to hash the password: %s", err)
+			respondWithError(w, http.StatusInternalServerError, "")
+			return
+		}
+		patch.HashedPassword = sql.NullString{String: hashedPassword, Valid: true}
+	}
+
+	if reqBody.Username != nil {
+		if !usernamePattern.MatchString(*reqBody.Username) {
+			respondWithError(w, http.StatusBadRequest, "Username must be 3-30 letters, digits or underscores")
+			return
+		}
+		patch.Username = sql.NullString{String: *reqBody.Username, Valid: true}
+	}
+
+	if reqBody.DisplayName != nil {
+		if len([]rune(*reqBody.DisplayName)) > maxDisplayNameLength {
+			respondWithError(w, http.StatusBadRequest, "Display name is too long")
+			return
+		}
+		patch.DisplayName = sql.NullString{String: *reqBody.DisplayName, Valid: true}
+	}
+
+	if reqBody.Bio != nil {
+		if len([]rune(*reqBody.Bio)) > maxBioLength {
+			respondWithError(w, http.StatusBadRequest, "Bio is too long")
+			return
+		}
+		patch.Bio = sql.NullString{String: *reqBody.Bio, Valid: true}
+	}
+
+	if reqBody.AvatarURL != nil {
+		avatarURL, err := url.Parse(*reqBody.AvatarURL)
+		if *reqBody.AvatarURL != "" && (err != nil || (avatarURL.Scheme != "http" && avatarURL.Scheme != "https")) {
+			respondWithError(w, http.StatusBadRequest, "Avatar URL must be an http or https URL")
+			return
+		}
+		patch.AvatarUrl = sql.NullString{String: *reqBody.AvatarURL, Valid: true}
+	}
+
+	tx, err := cfg.db.BeginTx(req.Context(), nil)
+	if err != nil {
+		log.Printf("Unable to begin transaction: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	userDb, err = qtx.PatchUser(req.Context(), patch)
+	if isUniqueViolation(err) {
+		log.Printf("E-mail or username already taken: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusConflict, "E-mail or username already taken")
+		return
+	} else if err != nil {
+		log.Printf("Unable to update user: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	if patch.HashedPassword.Valid {
+		err = qtx.RevokeUserRefreshTokens(req.Context(), userID)
+		if err != nil {
+			log.Printf("Unable to revoke sessions: %s %s [%s]", req.Method, req.URL.Path, err)
+			respondWithError(w, http.StatusInternalServerError, "")
+			return
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("Unable to commit transaction: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	if patch.HashedPassword.Valid {
+		user, err := cfg.makeSession(req, userDb)
+		if err != nil {
+			log.Printf("Unable to create session: %s %s [%s]", req.Method, req.URL.Path, err)
+			respondWithError(w, http.StatusInternalServerError, "")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, user)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, userFromDb(userDb))
+}
+
+func userFromDb(userDb database.User) User {
+	return User{
+		ID:          userDb.ID,
+		CreatedAt:   userDb.CreatedAt,
+		UpdatedAt:   userDb.UpdatedAt,
+		Email:       userDb.Email,
+		IsChirpyRed: userDb.IsChirpyRed,
+		Username:    userDb.Username.String,
+		DisplayName: userDb.DisplayName,
+		Bio:         userDb.Bio,
+		AvatarURL:   userDb.AvatarUrl,
+	}
+}
+
+func isUniqueViolation(err error) bool {
+	pqErr := &pq.Error{}
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+func isForeignKeyViolation(err error) bool {
+	pqErr := &pq.Error{}
+	return errors.As(err, &pqErr) && pqErr.Code == "23503"
+}
+
+// getPublicProfileHandler serves the profile anyone may see. It is built from
+// its own query and response type so private fields of User cannot leak.
+func (cfg *apiConfig) getPublicProfileHandler(w http.ResponseWriter, req *http.Request) {
+	userID, err := uuid.Parse(req.PathValue("userID"))
+	if err != nil {
+		log.Printf("Unable to parse userID: %s", req.PathValue("userID"))
+		respondWithError(w, http.StatusBadRequest, "")
+		return
+	}
+
+	profileDb, err := cfg.dbQueries.GetPublicProfile(req.Context(), userID)
+	if err == sql.ErrNoRows {
+		log.Printf("User not found")
+		respondWithError(w, http.StatusNotFound, "")
+		return
+	} else if err != nil {
+		log.Printf("Unable to retrieve profile: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, publicUserFromDb(profileDb))
+}
+
+func (cfg *apiConfig) getPublicProfileByUsernameHandler(w http.ResponseWriter, req *http.Request) {
+	username := sql.NullString{String: req.PathValue("username"), Valid: true}
+
+	profileDb, err := cfg.dbQueries.GetPublicProfileByUsername(req.Context(), username)
+	if err == sql.ErrNoRows {
+		log.Printf("User not found")
+		respondWithError(w, http.StatusNotFound, "")
+		return
+	} else if err != nil {
+		log.Printf("Unable to retrieve profile: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, publicUserFromDb(database.GetPublicProfileRow(profileDb)))
+}
+
+func publicUserFromDb(profileDb database.GetPublicProfileRow) PublicUser {
+	return PublicUser{
+		ID:             profileDb.ID,
+		JoinedAt:       profileDb.CreatedAt,
+		Username:       profileDb.Username.String,
+		DisplayName:    profileDb.DisplayName,
+		Bio:            profileDb.Bio,
+		AvatarURL:      profileDb.AvatarUrl,
+		IsChirpyRed:    profileDb.IsChirpyRed,
+		ChirpCount:     profileDb.ChirpCount,
+		FollowerCount:  profileDb.FollowerCount,
+		FollowingCount: profileDb.FollowingCount,
+	}
+}
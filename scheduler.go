@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Chirps published by a single claim of runChirpPublisher.
+const publishBatchSize = 100
+
+// How far ahead chirps can be scheduled. It also keeps the delay within the
+// int32 seconds the database is handed.
+const maxScheduleAhead = 365 * 24 * time.Hour
+
+// runChirpPublisher publishes scheduled chirps once their publish_at has
+// passed. The state lives in the database, so chirps that fell due while the
+// server was down are published on the next run. Rows are claimed with
+// FOR UPDATE SKIP LOCKED, which lets several instances share the work without
+// publishing a chirp twice.
+func (cfg *apiConfig) runChirpPublisher(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		published, err := publishInBatches(ctx, cfg.publishDueChirps)
+		if err != nil {
+			log.Printf("Unable to publish scheduled chirps: %s", err)
+		}
+		if published > 0 {
+			log.Printf("Published %d scheduled chirps", published)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// publishInBatches calls publish until it returns a partial batch, so a
+// backlog of due chirps is cleared in one run, and returns how many were
+// published.
+func publishInBatches(ctx context.Context, publish func(ctx context.Context) (int, error)) (int, error) {
+	total := 0
+	for {
+		published, err := publish(ctx)
+		total += published
+		if err != nil {
+			return total, err
+		}
+		if published < publishBatchSize || ctx.Err() != nil {
+			return total, nil
+		}
+	}
+}
+
+// publishDueChirps publishes a batch of due chirps and records their
+// chirp.created events in the same transaction.
+func (cfg *apiConfig) publishDueChirps(ctx context.Context) (int, error) {
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	chirpsDb, err := qtx.PublishDueChirps(ctx, publishBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	chirps := make([]Chirp, len(chirpsDb))
+	chirpPtrs := []*Chirp{}
+	for i, chirpDb := range chirpsDb {
+		chirps[i] = chirpFromDb(chirpDb)
+		chirpPtrs = append(chirpPtrs, &chirps[i])
+	}
+
+	err = cfg.loadChirpMedia(ctx, qtx, chirpPtrs)
+	if err != nil {
+		return 0, err
+	}
+	for _, chirp := range chirps {
+		err = recordEvent(ctx, qtx, eventChirpCreated, chirp.UserID, chirp)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	return len(chirps), nil
+}
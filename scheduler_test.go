@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lighthoof/Chirpy/internal/entitlements"
+)
+
+func TestPublishInBatches(t *testing.T) {
+	// Two full batches and a partial one are published in a single run.
+	batches := []int{publishBatchSize, publishBatchSize, 3, publishBatchSize}
+	calls := 0
+	published, err := publishInBatches(context.Background(), func(ctx context.Context) (int, error) {
+		calls++
+		return batches[calls-1], nil
+	})
+	if err != nil || published != 2*publishBatchSize+3 || calls != 3 {
+		t.Errorf("got %d published in %d calls: %v", published, calls, err)
+	}
+}
+
+func TestPublishInBatchesStopsOnError(t *testing.T) {
+	calls := 0
+	published, err := publishInBatches(context.Background(), func(ctx context.Context) (int, error) {
+		calls++
+		if calls == 2 {
+			return 0, errors.New("connection lost")
+		}
+		return publishBatchSize, nil
+	})
+	if err == nil || published != publishBatchSize || calls != 2 {
+		t.Errorf("got %d published in %d calls: %v", published, calls, err)
+	}
+}
+
+func TestPublishInBatchesStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	_, err := publishInBatches(ctx, func(ctx context.Context) (int, error) {
+		calls++
+		cancel()
+		return publishBatchSize, nil
+	})
+	if err != nil || calls != 1 {
+		t.Errorf("got %d calls after cancel: %v", calls, err)
+	}
+}
+
+func TestValidateChirpPublishAt(t *testing.T) {
+	ent := entitlements.ForPlan(entitlements.PlanRed)
+
+	cases := []struct {
+		name      string
+		publishAt time.Time
+		valid     bool
+	}{
+		{name: "in an hour", publishAt: time.Now().Add(time.Hour), valid: true},
+		{name: "in the past", publishAt: time.Now().Add(-time.Minute)},
+		{name: "beyond the maximum", publishAt: time.Now().Add(maxScheduleAhead + time.Hour)},
+		{name: "beyond int32 seconds", publishAt: time.Now().Add(70 * 365 * 24 * time.Hour)},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := validateChirp(Chirp{Body: "Later", PublishAt: &c.publishAt}, ent)
+			if c.valid && err != nil {
+				t.Errorf("Valid publish_at was rejected: %v", err)
+			}
+			if !c.valid && err == nil {
+				t.Errorf("Invalid publish_at was accepted!")
+			}
+		})
+	}
+
+	err := validateChirp(Chirp{Body: "Later", PublishAt: &cases[0].publishAt}, entitlements.ForPlan(entitlements.PlanFree))
+	if err == nil {
+		t.Errorf("Scheduled chirp was accepted on the free plan!")
+	}
+}
@@ -0,0 +1,476 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lighthoof/Chirpy/internal/auth"
+	"github.com/lighthoof/Chirpy/internal/database"
+	"github.com/lighthoof/Chirpy/internal/oauth"
+	"github.com/lighthoof/Chirpy/internal/stream"
+	"github.com/lighthoof/Chirpy/internal/websocket"
+)
+
+// Socket channels. Hashtag and thread channels are followed by the tag or the
+// chirp ID, as in "hashtag:golang" or "thread:<chirpID>".
+const (
+	channelHome    = "home"
+	channelHashtag = "hashtag:"
+	channelThread  = "thread:"
+)
+
+const (
+	socketAuthTimeout  = 10 * time.Second
+	socketPingInterval = 30 * time.Second
+	// Sockets that send nothing, not even a pong, for this long are closed.
+	socketIdleTimeout  = 75 * time.Second
+	socketWriteTimeout = 10 * time.Second
+	socketCloseGrace   = time.Second
+	socketMaxMessage   = 4096
+	socketMaxChannels  = 50
+)
+
+// hashtagPattern matches #tag where the # does not follow a word character or
+// an ampersand, so URL fragments and HTML entities are not hashtags.
+var hashtagPattern = regexp.MustCompile(`(?:^|[^A-Za-z0-9_&])#([A-Za-z0-9_]{1,50})`)
+
+var hashtagName = regexp.MustCompile(`^[A-Za-z0-9_]{1,50}$`)
+
+// Errors sent back when a subscription fails.
+var (
+	errUnknownChannel  = errors.New("Unknown channel")
+	errThreadNotFound  = errors.New("Chirp not found")
+	errSubscribeFailed = errors.New("Unable to subscribe")
+)
+
+// parseHashtags returns the distinct hashtags in the body, in lower case.
+func parseHashtags(body string) []string {
+	hashtags := []string{}
+	for _, match := range hashtagPattern.FindAllStringSubmatch(body, -1) {
+		hashtag := strings.ToLower(match[1])
+		if !slices.Contains(hashtags, hashtag) {
+			hashtags = append(hashtags, hashtag)
+		}
+	}
+	return hashtags
+}
+
+// socketRegistry counts open sockets to enforce the connection limits and
+// closes them when the server shuts down.
+type socketRegistry struct {
+	maxTotal   int
+	maxPerUser int
+
+	mu       sync.Mutex
+	total    int
+	perUser  map[uuid.UUID]int
+	closing  chan struct{}
+	shutDown bool
+	open     sync.WaitGroup
+}
+
+func newSocketRegistry(maxTotal, maxPerUser int) *socketRegistry {
+	return &socketRegistry{
+		maxTotal:   maxTotal,
+		maxPerUser: maxPerUser,
+		perUser:    map[uuid.UUID]int{},
+		closing:    make(chan struct{}),
+	}
+}
+
+// acquire reserves a connection; it fails when the server is full or
+// shutting down. Every successful acquire must be released.
+func (r *socketRegistry) acquire() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.shutDown || r.total >= r.maxTotal {
+		return false
+	}
+	r.total++
+	r.open.Add(1)
+	return true
+}
+
+// claim assigns an acquired connection to the user, if the user has a
+// connection left.
+func (r *socketRegistry) claim(userID uuid.UUID) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.perUser[userID] >= r.maxPerUser {
+		return false
+	}
+	r.perUser[userID]++
+	return true
+}
+
+// release frees an acquired connection and, if it was claimed, the user's.
+func (r *socketRegistry) release(userID uuid.NullUUID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.total--
+	if userID.Valid {
+		r.perUser[userID.UUID]--
+		if r.perUser[userID.UUID] <= 0 {
+			delete(r.perUser, userID.UUID)
+		}
+	}
+	r.open.Done()
+}
+
+// shutdown tells every open socket to close and refuses new ones.
+func (r *socketRegistry) shutdown() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.shutDown {
+		r.shutDown = true
+		close(r.closing)
+	}
+}
+
+// wait blocks until every socket was released or the context ends.
+func (r *socketRegistry) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		r.open.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// socketHandler upgrades to a WebSocket that pushes chirp events for the
+// channels the client subscribes to. Browsers cannot set headers on
+// WebSocket requests, so the access token may come either in the
+// Authorization header or in a first {"type": "auth", "token": ...} message.
+func (cfg *apiConfig) socketHandler(w http.ResponseWriter, req *http.Request) {
+	if !cfg.sockets.acquire() {
+		log.Printf("Socket limit reached: %s %s", req.Method, req.URL.Path)
+		respondWithError(w, http.StatusServiceUnavailable, "Too many connections")
+		return
+	}
+	userID := uuid.NullUUID{}
+	defer func() { cfg.sockets.release(userID) }()
+
+	if req.Header.Get("Authorization") != "" {
+		headerUserID, ok := cfg.authenticate(w, req, oauth.ScopeChirpsRead)
+		if !ok {
+			return
+		}
+		if !cfg.sockets.claim(headerUserID) {
+			respondWithError(w, http.StatusTooManyRequests, "Too many connections")
+			return
+		}
+		userID = uuid.NullUUID{UUID: headerUserID, Valid: true}
+	}
+
+	conn, err := websocket.Upgrade(w, req)
+	if err != nil {
+		log.Printf("Unable to upgrade to WebSocket: %s %s [%s]", req.Method, req.URL.Path, err)
+		return
+	}
+	defer conn.Close()
+	conn.SetReadLimit(socketMaxMessage)
+	conn.SetWriteTimeout(socketWriteTimeout)
+
+	if !userID.Valid {
+		messageUserID, err := cfg.authenticateSocket(conn)
+		if err != nil {
+			log.Printf("Unable to authenticate socket: %s %s [%s]", req.Method, req.URL.Path, err)
+			conn.WriteClose(websocket.ClosePolicyViolation, "Unauthorized")
+			return
+		}
+		if !cfg.sockets.claim(messageUserID) {
+			conn.WriteClose(websocket.ClosePolicyViolation, "Too many connections")
+			return
+		}
+		userID = uuid.NullUUID{UUID: messageUserID, Valid: true}
+	}
+
+	hidden, err := cfg.hiddenUserSet(req.Context(), userID.UUID)
+	if err != nil {
+		log.Printf("Unable to retrieve hidden users: %s %s [%s]", req.Method, req.URL.Path, err)
+		conn.WriteClose(websocket.CloseInternalError, "")
+		return
+	}
+
+	session := &socketSession{
+		cfg:      cfg,
+		conn:     conn,
+		userID:   userID.UUID,
+		hidden:   hidden,
+		channels: map[string]bool{},
+	}
+	session.run()
+}
+
+// authenticateSocket reads the auth message a socket must start with when
+// the token was not in the Authorization header.
+func (cfg *apiConfig) authenticateSocket(conn *websocket.Conn) (uuid.UUID, error) {
+	conn.SetReadDeadline(time.Now().Add(socketAuthTimeout))
+	opcode, data, err := conn.ReadMessage()
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+
+	command := SocketCommand{}
+	err = json.Unmarshal(data, &command)
+	if opcode != websocket.OpText || err != nil || command.Type != "auth" {
+		return uuid.UUID{}, errors.New("first message is not auth")
+	}
+
+	return auth.ValidateJWTScope(command.Token, cfg.secret, oauth.ScopeChirpsRead)
+}
+
+// socketSession is an authenticated socket and the channels it subscribed
+// to.
+type socketSession struct {
+	cfg    *apiConfig
+	conn   *websocket.Conn
+	userID uuid.UUID
+	// Blocks and mutes made later apply once the client reconnects.
+	hidden map[uuid.UUID]bool
+
+	mu       sync.Mutex
+	channels map[string]bool
+	// The users whose chirps the home channel carries, loaded when it is
+	// subscribed to. Follows made later apply once the client subscribes
+	// again.
+	following map[uuid.UUID]bool
+}
+
+// run pushes events until the client leaves, falls behind or the server
+// shuts down. Commands are read on a separate goroutine.
+func (s *socketSession) run() {
+	client := s.cfg.streamHub.Subscribe(streamBuffer, func(msg stream.Message) bool {
+		return len(s.matchingChannels(msg)) > 0
+	})
+	defer s.cfg.streamHub.Unsubscribe(client)
+
+	s.conn.SetIdleTimeout(socketIdleTimeout)
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- s.readCommands()
+	}()
+
+	err := s.send(SocketMessage{Type: "ready"})
+	if err != nil {
+		return
+	}
+
+	ping := time.NewTicker(socketPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-s.cfg.sockets.closing:
+			s.close(readErr, websocket.CloseGoingAway, "Server shutting down")
+			return
+		case <-client.Dropped():
+			select {
+			case <-s.cfg.sockets.closing:
+				s.close(readErr, websocket.CloseGoingAway, "Server shutting down")
+			default:
+				log.Printf("Dropped slow socket of user %s", s.userID)
+				s.close(readErr, websocket.CloseTryAgainLater, "Too slow")
+			}
+			return
+		case err := <-readErr:
+			closeErr := &websocket.CloseError{}
+			if errors.As(err, &closeErr) && closeErr.Code != websocket.CloseNormal &&
+				closeErr.Code != websocket.CloseGoingAway && closeErr.Code != websocket.CloseNoStatus {
+				log.Printf("Socket of user %s closed: %s", s.userID, err)
+			} else if !errors.As(err, &closeErr) && !errors.Is(err, io.EOF) {
+				log.Printf("Unable to read from socket of user %s: %s", s.userID, err)
+			}
+			return
+		case <-ping.C:
+			err = s.conn.WriteMessage(websocket.OpPing, nil)
+		case msg := <-client.Messages():
+			channels := s.matchingChannels(msg)
+			if len(channels) == 0 {
+				continue
+			}
+			err = s.send(SocketMessage{
+				Type:     msg.Type,
+				ID:       msg.ID,
+				Channels: channels,
+				Data:     json.RawMessage(msg.Data),
+			})
+		}
+		if err != nil {
+			log.Printf("Unable to write to socket of user %s: %s", s.userID, err)
+			return
+		}
+	}
+}
+
+// close starts the closing handshake and gives the client a moment to answer
+// before the connection is torn down.
+func (s *socketSession) close(readErr <-chan error, code int, reason string) {
+	err := s.conn.WriteClose(code, reason)
+	if err != nil {
+		return
+	}
+	select {
+	case <-readErr:
+	case <-time.After(socketCloseGrace):
+	}
+}
+
+func (s *socketSession) readCommands() error {
+	for {
+		opcode, data, err := s.conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		command := SocketCommand{}
+		if opcode != websocket.OpText || json.Unmarshal(data, &command) != nil {
+			err = s.send(SocketMessage{Type: "error", Error: "Malformed message"})
+		} else {
+			err = s.send(s.handleCommand(command))
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *socketSession) handleCommand(command SocketCommand) SocketMessage {
+	switch command.Type {
+	case "subscribe":
+		channel, err := s.cfg.checkSocketChannel(s.userID, command.Channel)
+		if err != nil {
+			return SocketMessage{Type: "error", Channel: command.Channel, Error: err.Error()}
+		}
+
+		var following map[uuid.UUID]bool
+		if channel == channelHome {
+			following, err = s.cfg.followingSet(s.userID)
+			if err != nil {
+				log.Printf("Unable to retrieve follows of user %s for socket: %s", s.userID, err)
+				return SocketMessage{Type: "error", Channel: command.Channel, Error: errSubscribeFailed.Error()}
+			}
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if !s.channels[channel] && len(s.channels) >= socketMaxChannels {
+			return SocketMessage{Type: "error", Channel: command.Channel, Error: "Too many channels"}
+		}
+		s.channels[channel] = true
+		if following != nil {
+			s.following = following
+		}
+		return SocketMessage{Type: "subscribed", Channel: channel}
+	case "unsubscribe":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.channels, strings.ToLower(command.Channel))
+		return SocketMessage{Type: "unsubscribed", Channel: strings.ToLower(command.Channel)}
+	case "auth":
+		return SocketMessage{Type: "error", Error: "Already authenticated"}
+	default:
+		return SocketMessage{Type: "error", Error: "Unknown message type"}
+	}
+}
+
+// matchingChannels returns the subscribed channels the message belongs to,
+// or none if its author is hidden from the user. Every chirp has the home
+// topic, but the home channel only carries chirps of the user and of the
+// users they follow.
+func (s *socketSession) matchingChannels(msg stream.Message) []string {
+	if s.hidden[msg.UserID] {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	channels := []string{}
+	for _, topic := range msg.Topics {
+		if !s.channels[topic] {
+			continue
+		}
+		if topic == channelHome && msg.UserID != s.userID && !s.following[msg.UserID] {
+			continue
+		}
+		channels = append(channels, topic)
+	}
+	return channels
+}
+
+func (s *socketSession) send(msg SocketMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return s.conn.WriteMessage(websocket.OpText, data)
+}
+
+// followingSet returns the users the user follows.
+func (cfg *apiConfig) followingSet(userID uuid.UUID) (map[uuid.UUID]bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), socketWriteTimeout)
+	defer cancel()
+
+	followeeIDs, err := cfg.dbQueries.GetFolloweeIDs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	following := map[uuid.UUID]bool{}
+	for _, followeeID := range followeeIDs {
+		following[followeeID] = true
+	}
+	return following, nil
+}
+
+// checkSocketChannel validates a channel name and returns it in canonical
+// form. A thread can only be followed while its chirp is visible to the user.
+func (cfg *apiConfig) checkSocketChannel(userID uuid.UUID, channel string) (string, error) {
+	switch {
+	case channel == channelHome:
+		return channel, nil
+	case strings.HasPrefix(channel, channelHashtag):
+		hashtag := strings.TrimPrefix(channel, channelHashtag)
+		if !hashtagName.MatchString(hashtag) {
+			return "", errUnknownChannel
+		}
+		return channelHashtag + strings.ToLower(hashtag), nil
+	case strings.HasPrefix(channel, channelThread):
+		chirpID, err := uuid.Parse(strings.TrimPrefix(channel, channelThread))
+		if err != nil {
+			return "", errUnknownChannel
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), socketWriteTimeout)
+		defer cancel()
+
+		chirpDb, err := cfg.dbQueries.GetChirpById(ctx,
+			database.GetChirpByIdParams{ID: chirpID, ViewerID: uuid.NullUUID{UUID: userID, Valid: true}})
+		if err == sql.ErrNoRows || (err == nil && !chirpDb.PublishedAt.Valid && chirpDb.UserID != userID) {
+			return "", errThreadNotFound
+		} else if err != nil {
+			log.Printf("Unable to retrieve chirp %s for socket: %s", chirpID, err)
+			return "", errSubscribeFailed
+		}
+		return channelThread + chirpID.String(), nil
+	default:
+		return "", errUnknownChannel
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lighthoof/Chirpy/internal/stream"
+)
+
+func TestParseHashtags(t *testing.T) {
+	cases := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{name: "single", body: "Learning #golang today", want: []string{"golang"}},
+		{name: "lower case and distinct", body: "#Go #go #GO_lang", want: []string{"go", "go_lang"}},
+		{name: "punctuation", body: "(#chirpy), #boot.dev", want: []string{"chirpy", "boot"}},
+		{name: "url fragment", body: "see https://example.com/page#section", want: []string{}},
+		{name: "html entity", body: "it&#39;s fine", want: []string{}},
+		{name: "lone hash", body: "# not a tag", want: []string{}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := parseHashtags(c.body)
+			if !slices.Equal(got, c.want) {
+				t.Errorf("Expected %v, got %v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestSocketRegistryLimits(t *testing.T) {
+	registry := newSocketRegistry(3, 2)
+	alice, bob := uuid.New(), uuid.New()
+
+	for i := 0; i < 3; i++ {
+		if !registry.acquire() {
+			t.Fatalf("Connection %d was refused below the limit", i+1)
+		}
+	}
+	if registry.acquire() {
+		t.Errorf("Connection above the total limit was accepted")
+	}
+
+	if !registry.claim(alice) || !registry.claim(alice) {
+		t.Fatalf("Connections of a user were refused below the limit")
+	}
+	if registry.claim(alice) {
+		t.Errorf("Connection above the per-user limit was accepted")
+	}
+	if !registry.claim(bob) {
+		t.Errorf("Limit of one user applied to another")
+	}
+
+	registry.release(uuid.NullUUID{UUID: alice, Valid: true})
+	if !registry.acquire() {
+		t.Errorf("Released connection was not freed")
+	}
+	if !registry.claim(alice) {
+		t.Errorf("Released connection of the user was not freed")
+	}
+}
+
+func TestSocketRegistryShutdown(t *testing.T) {
+	registry := newSocketRegistry(10, 10)
+	if !registry.acquire() {
+		t.Fatalf("Connection was refused")
+	}
+
+	registry.shutdown()
+	registry.shutdown()
+	select {
+	case <-registry.closing:
+	default:
+		t.Errorf("Open sockets were not told to close")
+	}
+	if registry.acquire() {
+		t.Errorf("Connection was accepted while shutting down")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if registry.wait(ctx) == nil {
+		t.Errorf("Wait returned with a socket still open")
+	}
+
+	registry.release(uuid.NullUUID{})
+	if err := registry.wait(context.Background()); err != nil {
+		t.Errorf("Wait failed after every socket was released: %v", err)
+	}
+}
+
+func TestSocketHomeChannel(t *testing.T) {
+	userID, followed, stranger, muted := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	session := &socketSession{
+		userID:    userID,
+		hidden:    map[uuid.UUID]bool{muted: true},
+		channels:  map[string]bool{channelHome: true, channelHashtag + "golang": true},
+		following: map[uuid.UUID]bool{followed: true, muted: true},
+	}
+
+	cases := []struct {
+		name     string
+		authorID uuid.UUID
+		topics   []string
+		want     []string
+	}{
+		{name: "followed author", authorID: followed, topics: []string{channelHome}, want: []string{channelHome}},
+		{name: "own chirp", authorID: userID, topics: []string{channelHome}, want: []string{channelHome}},
+		{name: "not followed", authorID: stranger, topics: []string{channelHome}, want: []string{}},
+		{
+			name:     "not followed but subscribed hashtag",
+			authorID: stranger,
+			topics:   []string{channelHome, channelHashtag + "golang"},
+			want:     []string{channelHashtag + "golang"},
+		},
+		{name: "followed but muted", authorID: muted, topics: []string{channelHome}, want: nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := session.matchingChannels(stream.Message{UserID: c.authorID, Topics: c.topics})
+			if !slices.Equal(got, c.want) {
+				t.Errorf("Expected %v, got %v", c.want, got)
+			}
+		})
+	}
+}
@@ -15,10 +15,24 @@ SELECT user_id
 FROM refresh_token 
 WHERE token = $1
 AND expires_at > NOW()
-AND revoked_at IS NULL;
+AND revoked_at IS NULL
+AND client_id IS NULL;
 
 -- name: RevokeRefershToken :exec
 UPDATE refresh_token
 SET updated_at = NOW(),
     revoked_at = NOW()
 WHERE token = $1;
+
+-- name: RevokeUserRefreshTokens :exec
+UPDATE refresh_token
+SET updated_at = NOW(),
+    revoked_at = NOW()
+WHERE user_id = $1
+AND revoked_at IS NULL;
+
+-- name: GetUserSessions :many
+SELECT created_at, expires_at, revoked_at, client_id
+FROM refresh_token
+WHERE user_id = $1
+ORDER BY created_at;
@@ -0,0 +1,72 @@
+-- name: BlockUser :exec
+INSERT INTO user_blocks (blocker_id, blocked_id, created_at)
+VALUES (
+    $1,
+    $2,
+    NOW()
+)
+ON CONFLICT DO NOTHING;
+
+-- name: UnblockUser :exec
+DELETE
+FROM user_blocks
+WHERE blocker_id = $1
+AND blocked_id = $2;
+
+-- name: MuteUser :exec
+INSERT INTO user_mutes (muter_id, muted_id, created_at)
+VALUES (
+    $1,
+    $2,
+    NOW()
+)
+ON CONFLICT DO NOTHING;
+
+-- name: UnmuteUser :exec
+DELETE
+FROM user_mutes
+WHERE muter_id = $1
+AND muted_id = $2;
+
+-- name: RemoveFollowsBetween :exec
+DELETE
+FROM follows
+WHERE (follower_id = $1 AND followee_id = $2)
+OR (follower_id = $2 AND followee_id = $1);
+
+-- name: IsBlockedBetween :one
+SELECT EXISTS (
+    SELECT 1
+    FROM user_blocks
+    WHERE (blocker_id = $1 AND blocked_id = $2)
+    OR (blocker_id = $2 AND blocked_id = $1)
+);
+
+-- name: HasBlocksAmong :one
+SELECT EXISTS (
+    SELECT 1
+    FROM user_blocks
+    WHERE blocker_id = ANY(sqlc.arg('user_ids')::uuid[])
+    AND blocked_id = ANY(sqlc.arg('user_ids')::uuid[])
+);
+
+-- name: GetHiddenUsers :many
+SELECT blocked_id AS user_id
+FROM user_blocks
+WHERE blocker_id = sqlc.arg('viewer_id')
+UNION
+SELECT muted_id AS user_id
+FROM user_mutes
+WHERE muter_id = sqlc.arg('viewer_id')
+AND sqlc.arg('include_muted')::bool;
+
+-- name: IsHiddenFrom :one
+SELECT (
+    hidden_from(sqlc.arg('viewer_id')::uuid, sqlc.arg('author_id')::uuid, sqlc.arg('include_muted')::bool)
+    OR EXISTS (
+        SELECT 1
+        FROM user_blocks
+        WHERE blocker_id = sqlc.arg('author_id')
+        AND blocked_id = sqlc.arg('viewer_id')
+    )
+) AS hidden;
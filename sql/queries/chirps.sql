@@ -1,11 +1,13 @@
 -- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, publish_at, published_at)
 VALUES (
     gen_random_uuid(),
     NOW(),
     NOW(),
-    $1,
-    $2
+    sqlc.arg('body'),
+    sqlc.arg('user_id'),
+    NOW() + (sqlc.narg('delay_seconds')::int * interval '1 second'),
+    CASE WHEN sqlc.narg('delay_seconds')::int IS NULL THEN NOW() END
 )
 RETURNING *;
 
@@ -15,8 +17,15 @@ SELECT
     created_at, 
     updated_at, 
     body, 
-    user_id 
+    user_id,
+    publish_at,
+    published_at,
+    deleted_at,
+    edited_at
 FROM chirps
+WHERE published_at IS NOT NULL
+AND deleted_at IS NULL
+AND NOT hidden_from(sqlc.narg('viewer_id')::uuid, user_id, TRUE)
 ORDER BY created_at;
 
 -- name: GetChirpsByAuthor :many
@@ -25,22 +34,106 @@ SELECT
     created_at, 
     updated_at, 
     body, 
-    user_id 
+    user_id,
+    publish_at,
+    published_at,
+    deleted_at,
+    edited_at
 FROM chirps
-WHERE user_id = $1
+WHERE user_id = sqlc.arg('user_id')
+AND published_at IS NOT NULL
+AND deleted_at IS NULL
+AND NOT hidden_from(sqlc.narg('viewer_id')::uuid, user_id, FALSE)
 ORDER BY created_at;
 
+-- name: GetAllChirpsByAuthor :many
+SELECT *
+FROM chirps
+WHERE user_id = $1
+ORDER BY created_at, id;
+
 -- name: GetChirpById :one
 SELECT 
     id, 
     created_at, 
     updated_at, 
     body, 
-    user_id 
+    user_id,
+    publish_at,
+    published_at,
+    deleted_at,
+    edited_at
+FROM chirps
+WHERE id = sqlc.arg('id')
+AND deleted_at IS NULL
+AND NOT hidden_from(sqlc.narg('viewer_id')::uuid, user_id, FALSE);
+
+-- name: GetChirpByIdWithDeleted :one
+SELECT *
 FROM chirps
 WHERE id = $1;
 
--- name: DeleteChirpById :exec
+-- name: GetDeletedChirps :many
+SELECT *
+FROM chirps
+WHERE deleted_at IS NOT NULL
+ORDER BY deleted_at DESC
+LIMIT $1
+OFFSET $2;
+
+-- name: SoftDeleteChirp :one
+UPDATE chirps
+SET deleted_at = NOW(), updated_at = NOW()
+WHERE id = $1
+AND deleted_at IS NULL
+RETURNING *;
+
+-- name: RestoreChirp :one
+UPDATE chirps
+SET deleted_at = NULL, updated_at = NOW()
+WHERE id = sqlc.arg('id')
+AND deleted_at > NOW() - (sqlc.arg('window_seconds')::int * interval '1 second')
+RETURNING *;
+
+-- name: PurgeDeletedChirps :execrows
 DELETE
 FROM chirps
-WHERE id = $1;
\ No newline at end of file
+WHERE deleted_at < NOW() - (sqlc.arg('retention_seconds')::int * interval '1 second');
+
+-- name: CountChirpsByAuthor :one
+SELECT COUNT(*)
+FROM chirps
+WHERE user_id = $1
+AND deleted_at IS NULL;
+
+-- name: PublishDueChirps :many
+UPDATE chirps
+SET created_at = NOW(), updated_at = NOW(), published_at = NOW()
+WHERE id IN (
+    SELECT id
+    FROM chirps
+    WHERE published_at IS NULL
+    AND deleted_at IS NULL
+    AND publish_at <= NOW()
+    ORDER BY publish_at
+    LIMIT $1
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- name: EditChirp :one
+UPDATE chirps
+SET body = sqlc.arg('body'), edited_at = NOW(), updated_at = NOW()
+WHERE id = sqlc.arg('id')
+AND deleted_at IS NULL
+AND (published_at IS NULL OR published_at > NOW() - (sqlc.arg('window_seconds')::int * interval '1 second'))
+RETURNING *;
+
+-- name: CountRecentChirps :one
+SELECT COUNT(*)
+FROM chirps
+WHERE user_id = sqlc.arg('user_id')
+AND created_at > NOW() - (sqlc.arg('window_seconds')::int * interval '1 second');
+
+-- name: LockChirpRate :exec
+SELECT pg_advisory_xact_lock(hashtext('chirp_rate'), hashtext(sqlc.arg('user_id')::text));
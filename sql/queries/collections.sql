@@ -0,0 +1,82 @@
+-- name: CreateCollection :one
+INSERT INTO collections (id, created_at, updated_at, user_id, name)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2
+)
+RETURNING *;
+
+-- name: GetCollections :many
+SELECT
+    collections.id,
+    collections.created_at,
+    collections.updated_at,
+    collections.user_id,
+    collections.name,
+    COUNT(bookmarks.chirp_id) AS bookmark_count
+FROM collections
+LEFT JOIN bookmarks ON bookmarks.collection_id = collections.id
+WHERE collections.user_id = $1
+GROUP BY collections.id
+ORDER BY collections.name;
+
+-- name: GetCollection :one
+SELECT * FROM collections
+WHERE id = $1
+AND user_id = $2;
+
+-- name: RenameCollection :one
+UPDATE collections
+SET name = $3, updated_at = NOW()
+WHERE id = $1
+AND user_id = $2
+RETURNING *;
+
+-- name: DeleteCollection :execrows
+DELETE FROM collections
+WHERE id = $1
+AND user_id = $2;
+
+-- name: AddBookmark :exec
+INSERT INTO bookmarks (collection_id, chirp_id, created_at)
+VALUES ($1, $2, NOW())
+ON CONFLICT DO NOTHING;
+
+-- name: RemoveBookmark :execrows
+DELETE FROM bookmarks
+WHERE collection_id = $1
+AND chirp_id = $2;
+
+-- name: GetBookmarkedChirps :many
+SELECT
+    chirps.id,
+    chirps.created_at,
+    chirps.updated_at,
+    chirps.body,
+    chirps.user_id,
+    chirps.publish_at,
+    chirps.published_at,
+    chirps.deleted_at,
+    chirps.edited_at
+FROM bookmarks
+JOIN chirps ON chirps.id = bookmarks.chirp_id
+WHERE bookmarks.collection_id = sqlc.arg('collection_id')
+AND chirps.published_at IS NOT NULL
+AND chirps.deleted_at IS NULL
+AND NOT hidden_from(sqlc.arg('viewer_id')::uuid, chirps.user_id, FALSE)
+ORDER BY bookmarks.created_at DESC, chirps.id
+LIMIT sqlc.arg('limit')
+OFFSET sqlc.arg('offset');
+
+-- name: CountBookmarks :one
+SELECT COUNT(*)
+FROM bookmarks
+WHERE collection_id = $1;
+
+-- name: RemoveChirpBookmarks :exec
+DELETE
+FROM bookmarks
+WHERE chirp_id = $1;
@@ -0,0 +1,108 @@
+-- name: CreateConversation :one
+INSERT INTO conversations (id, created_at, updated_at, created_by, direct_key)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2
+)
+ON CONFLICT (direct_key) DO NOTHING
+RETURNING *;
+
+-- name: GetConversationByDirectKey :one
+SELECT *
+FROM conversations
+WHERE direct_key = $1;
+
+-- name: AddConversationMembers :exec
+INSERT INTO conversation_members (conversation_id, user_id, joined_at)
+SELECT sqlc.arg('conversation_id'), member_id, NOW()
+FROM unnest(sqlc.arg('user_ids')::uuid[]) AS member_id
+ON CONFLICT (conversation_id, user_id) DO NOTHING;
+
+-- name: GetConversation :one
+SELECT conversations.*,
+    (
+        SELECT COUNT(*)
+        FROM messages
+        WHERE messages.conversation_id = conversations.id
+        AND messages.sender_id <> sqlc.arg('user_id')
+        AND (conversation_members.last_read_at IS NULL OR messages.created_at > conversation_members.last_read_at)
+        AND messages.sender_id NOT IN (SELECT blocked_id FROM user_blocks WHERE blocker_id = sqlc.arg('user_id'))
+    ) AS unread_count
+FROM conversations
+JOIN conversation_members ON conversation_members.conversation_id = conversations.id
+WHERE conversations.id = sqlc.arg('id')
+AND conversation_members.user_id = sqlc.arg('user_id');
+
+-- name: GetConversations :many
+SELECT conversations.*,
+    (
+        SELECT COUNT(*)
+        FROM messages
+        WHERE messages.conversation_id = conversations.id
+        AND messages.sender_id <> sqlc.arg('user_id')
+        AND (conversation_members.last_read_at IS NULL OR messages.created_at > conversation_members.last_read_at)
+        AND messages.sender_id NOT IN (SELECT blocked_id FROM user_blocks WHERE blocker_id = sqlc.arg('user_id'))
+    ) AS unread_count
+FROM conversations
+JOIN conversation_members ON conversation_members.conversation_id = conversations.id
+WHERE conversation_members.user_id = sqlc.arg('user_id')
+ORDER BY conversations.updated_at DESC, conversations.id
+LIMIT sqlc.arg('limit')
+OFFSET sqlc.arg('offset');
+
+-- name: GetConversationMembers :many
+SELECT *
+FROM conversation_members
+WHERE conversation_id = ANY(sqlc.arg('conversation_ids')::uuid[])
+ORDER BY joined_at, user_id;
+
+-- name: IsBlockedInConversation :one
+SELECT EXISTS (
+    SELECT 1
+    FROM user_blocks
+    JOIN conversation_members ON conversation_members.conversation_id = sqlc.arg('conversation_id')
+    WHERE (user_blocks.blocker_id = sqlc.arg('user_id') AND user_blocks.blocked_id = conversation_members.user_id)
+    OR (user_blocks.blocked_id = sqlc.arg('user_id') AND user_blocks.blocker_id = conversation_members.user_id)
+);
+
+-- name: TouchConversation :exec
+UPDATE conversations
+SET updated_at = NOW()
+WHERE id = $1;
+
+-- name: MarkConversationRead :exec
+UPDATE conversation_members
+SET last_read_at = NOW()
+WHERE conversation_id = $1
+AND user_id = $2;
+
+-- name: CreateMessage :one
+INSERT INTO messages (id, created_at, conversation_id, sender_id, body)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    $1,
+    $2,
+    $3
+)
+RETURNING *;
+
+-- name: GetMessages :many
+SELECT *
+FROM messages
+WHERE conversation_id = sqlc.arg('conversation_id')
+AND sender_id NOT IN (SELECT blocked_id FROM user_blocks WHERE blocker_id = sqlc.arg('viewer_id'))
+ORDER BY created_at DESC, id
+LIMIT sqlc.arg('limit')
+OFFSET sqlc.arg('offset');
+
+-- name: GetUserMessages :many
+SELECT messages.*
+FROM messages
+JOIN conversation_members ON conversation_members.conversation_id = messages.conversation_id
+WHERE conversation_members.user_id = sqlc.arg('user_id')
+AND messages.sender_id NOT IN (SELECT blocked_id FROM user_blocks WHERE blocker_id = sqlc.arg('user_id'))
+ORDER BY messages.conversation_id, messages.created_at, messages.id;
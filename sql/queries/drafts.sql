@@ -0,0 +1,38 @@
+-- name: CreateDraft :one
+INSERT INTO drafts (id, created_at, updated_at, user_id, body)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2
+)
+RETURNING *;
+
+-- name: GetDrafts :many
+SELECT * FROM drafts
+WHERE user_id = $1
+ORDER BY updated_at DESC;
+
+-- name: GetDraft :one
+SELECT * FROM drafts
+WHERE id = $1
+AND user_id = $2;
+
+-- name: LockDraft :one
+SELECT * FROM drafts
+WHERE id = $1
+AND user_id = $2
+FOR UPDATE;
+
+-- name: UpdateDraft :one
+UPDATE drafts
+SET body = $3, updated_at = NOW()
+WHERE id = $1
+AND user_id = $2
+RETURNING *;
+
+-- name: DeleteDraft :execrows
+DELETE FROM drafts
+WHERE id = $1
+AND user_id = $2;
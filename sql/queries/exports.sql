@@ -0,0 +1,35 @@
+-- name: CreateDataExport :one
+INSERT INTO data_exports (id, created_at, updated_at, user_id, status)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    'pending'
+)
+RETURNING *;
+
+-- name: GetDataExport :one
+SELECT * FROM data_exports WHERE id = $1 AND user_id = $2;
+
+-- name: FinishDataExport :exec
+UPDATE data_exports
+SET status = $2,
+    file_path = $3,
+    error = $4,
+    updated_at = NOW()
+WHERE id = $1;
+
+-- name: ClaimDataExport :one
+UPDATE data_exports
+SET status = 'running',
+    updated_at = NOW()
+WHERE id = (
+    SELECT id FROM data_exports
+    WHERE status = 'pending'
+    OR (status = 'running' AND updated_at < NOW() - make_interval(secs => sqlc.arg(stale_seconds)::int))
+    ORDER BY created_at
+    LIMIT 1
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
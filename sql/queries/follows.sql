@@ -0,0 +1,24 @@
+-- name: FollowUser :execrows
+INSERT INTO follows (follower_id, followee_id, created_at)
+VALUES (
+    $1,
+    $2,
+    NOW()
+)
+ON CONFLICT DO NOTHING;
+
+-- name: UnfollowUser :exec
+DELETE
+FROM follows
+WHERE follower_id = $1
+AND followee_id = $2;
+
+-- name: GetUsersByUsernames :many
+SELECT id, username
+FROM users
+WHERE username = ANY(sqlc.arg('usernames')::text[]);
+
+-- name: GetFolloweeIDs :many
+SELECT followee_id
+FROM follows
+WHERE follower_id = $1;
@@ -0,0 +1,17 @@
+-- name: CreateUserIdentity :one
+INSERT INTO user_identities (provider, subject, created_at, user_id, email)
+VALUES (
+    $1,
+    $2,
+    NOW(),
+    $3,
+    $4
+)
+RETURNING *;
+
+-- name: GetUserByIdentity :one
+SELECT users.*
+FROM users
+JOIN user_identities ON user_identities.user_id = users.id
+WHERE user_identities.provider = $1
+AND user_identities.subject = $2;
@@ -0,0 +1,34 @@
+-- name: CreateMagicLink :one
+INSERT INTO magic_links (token_hash, created_at, user_id, expires_at, used_at)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    NOW() + interval '15 minutes',
+    NULL
+)
+RETURNING *;
+
+-- name: ConsumeMagicLink :one
+UPDATE magic_links
+SET used_at = NOW()
+WHERE token_hash = $1
+AND expires_at > NOW()
+AND used_at IS NULL
+RETURNING *;
+
+-- name: CountMagicLinkRequest :one
+INSERT INTO magic_link_requests (email_hash, window_start, requests)
+VALUES (sqlc.arg('email_hash'), NOW(), 1)
+ON CONFLICT (email_hash) DO UPDATE
+SET requests = CASE
+        WHEN magic_link_requests.window_start > NOW() - make_interval(secs => sqlc.arg('window_seconds')::int)
+        THEN magic_link_requests.requests + 1
+        ELSE 1
+    END,
+    window_start = CASE
+        WHEN magic_link_requests.window_start > NOW() - make_interval(secs => sqlc.arg('window_seconds')::int)
+        THEN magic_link_requests.window_start
+        ELSE NOW()
+    END
+RETURNING requests;
@@ -0,0 +1,55 @@
+-- name: CreateMedia :one
+INSERT INTO media (id, created_at, user_id, content_type, width, height, blob_key, thumbnail_key)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7
+)
+RETURNING *;
+
+-- name: GetMedia :one
+SELECT * FROM media
+WHERE id = $1
+AND NOT EXISTS (
+    SELECT 1 FROM chirps
+    WHERE chirps.id = media.chirp_id
+    AND chirps.deleted_at IS NOT NULL
+);
+
+-- name: AttachMedia :execrows
+UPDATE media
+SET chirp_id = $1,
+    position = $2,
+    alt_text = $3
+WHERE id = $4
+AND user_id = $5
+AND chirp_id IS NULL;
+
+-- name: GetMediaForChirps :many
+SELECT *
+FROM media
+WHERE chirp_id = ANY(sqlc.arg('chirp_ids')::uuid[])
+ORDER BY chirp_id, position;
+
+-- name: GetPurgeableMedia :many
+SELECT media.*
+FROM media
+JOIN chirps ON chirps.id = media.chirp_id
+WHERE chirps.deleted_at < NOW() - (sqlc.arg('retention_seconds')::int * interval '1 second');
+
+-- name: GetMediaOfPurgeableUsers :many
+SELECT media.*
+FROM media
+JOIN users ON users.id = media.user_id
+WHERE users.deletion_requested_at < NOW() - (sqlc.arg('grace_seconds')::int * interval '1 second');
+
+-- name: DeleteUnattachedMedia :many
+DELETE FROM media
+WHERE chirp_id IS NULL
+AND created_at < NOW() - (sqlc.arg('stale_seconds')::int * interval '1 second')
+RETURNING *;
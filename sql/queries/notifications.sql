@@ -0,0 +1,58 @@
+-- name: CreateNotification :one
+INSERT INTO notifications (id, created_at, user_id, type, event_id, actor_id, chirp_id, data)
+SELECT
+    gen_random_uuid(),
+    NOW(),
+    sqlc.arg('user_id'),
+    sqlc.arg('type')::text,
+    sqlc.arg('event_id'),
+    sqlc.narg('actor_id'),
+    sqlc.narg('chirp_id'),
+    sqlc.arg('data')
+WHERE NOT EXISTS (
+    SELECT 1 FROM notification_preferences
+    WHERE notification_preferences.user_id = sqlc.arg('user_id')
+    AND notification_preferences.type = sqlc.arg('type')::text
+    AND NOT notification_preferences.enabled
+)
+ON CONFLICT (user_id, event_id) DO NOTHING
+RETURNING *;
+
+-- name: GetNotifications :many
+SELECT *
+FROM notifications
+WHERE user_id = sqlc.arg('user_id')
+AND (NOT sqlc.arg('unread_only')::bool OR read_at IS NULL)
+ORDER BY created_at DESC, id
+LIMIT sqlc.arg('limit')
+OFFSET sqlc.arg('offset');
+
+-- name: CountUnreadNotifications :one
+SELECT COUNT(*)
+FROM notifications
+WHERE user_id = $1
+AND read_at IS NULL;
+
+-- name: MarkNotificationsRead :execrows
+UPDATE notifications
+SET read_at = NOW()
+WHERE user_id = sqlc.arg('user_id')
+AND read_at IS NULL
+AND (sqlc.arg('all')::bool OR id = ANY(sqlc.arg('ids')::uuid[]));
+
+-- name: GetNotificationPreferences :many
+SELECT *
+FROM notification_preferences
+WHERE user_id = $1;
+
+-- name: SetNotificationPreference :exec
+INSERT INTO notification_preferences (user_id, type, enabled, updated_at)
+VALUES (
+    $1,
+    $2,
+    $3,
+    NOW()
+)
+ON CONFLICT (user_id, type) DO UPDATE
+SET enabled = EXCLUDED.enabled,
+    updated_at = NOW();
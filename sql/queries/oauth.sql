@@ -0,0 +1,67 @@
+-- name: CreateOAuthClient :one
+INSERT INTO oauth_clients (id, created_at, updated_at, name, hashed_secret, redirect_uri, scope, user_id)
+VALUES (
+    $1,
+    NOW(),
+    NOW(),
+    $2,
+    $3,
+    $4,
+    $5,
+    $6
+)
+RETURNING *;
+
+-- name: GetOAuthClient :one
+SELECT * FROM oauth_clients WHERE id = $1;
+
+-- name: CreateOAuthCode :one
+INSERT INTO oauth_codes (code, created_at, client_id, user_id, redirect_uri, scope, code_challenge, expires_at, used_at)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    NOW() + interval '1 minute',
+    NULL
+)
+RETURNING *;
+
+-- name: ConsumeOAuthCode :one
+UPDATE oauth_codes
+SET used_at = NOW()
+WHERE code = $1
+AND expires_at > NOW()
+AND used_at IS NULL
+RETURNING *;
+
+-- name: StoreOAuthRefreshToken :one
+INSERT INTO refresh_token (token, created_at, updated_at, user_id, expires_at, revoked_at, client_id, scope)
+VALUES (
+    $1,
+    NOW(),
+    NOW(),
+    $2,
+    NOW() + interval '60 days',
+    NULL,
+    $3,
+    $4
+    )
+RETURNING *;
+
+-- name: GetOAuthRefreshToken :one
+SELECT *
+FROM refresh_token
+WHERE token = $1
+AND client_id = $2
+AND expires_at > NOW()
+AND revoked_at IS NULL;
+
+-- name: UpdateOAuthClientSecret :exec
+UPDATE oauth_clients
+SET hashed_secret = $1,
+    updated_at = NOW()
+WHERE id = $2;
@@ -0,0 +1,94 @@
+-- name: CreateWebhookEndpoint :one
+INSERT INTO webhook_endpoints (id, created_at, updated_at, user_id, url, secret, event_types)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    sqlc.narg('user_id'),
+    sqlc.arg('url'),
+    sqlc.arg('secret'),
+    sqlc.arg('event_types')::text[]
+)
+RETURNING *;
+
+-- name: GetWebhookEndpoints :many
+SELECT *
+FROM webhook_endpoints
+WHERE user_id IS NOT DISTINCT FROM sqlc.narg('user_id')
+ORDER BY created_at;
+
+-- name: GetWebhookEndpoint :one
+SELECT *
+FROM webhook_endpoints
+WHERE id = sqlc.arg('id')
+AND user_id IS NOT DISTINCT FROM sqlc.narg('user_id');
+
+-- name: DeleteWebhookEndpoint :execrows
+DELETE FROM webhook_endpoints
+WHERE id = sqlc.arg('id')
+AND user_id IS NOT DISTINCT FROM sqlc.narg('user_id');
+
+-- name: EnqueueWebhookDeliveries :execrows
+INSERT INTO webhook_deliveries (id, created_at, updated_at, endpoint_id, event_id, event_type, payload, status, next_attempt_at)
+SELECT gen_random_uuid(), NOW(), NOW(), webhook_endpoints.id, sqlc.arg('event_id'), sqlc.arg('event_type')::text, sqlc.arg('payload'), 'pending', NOW()
+FROM webhook_endpoints
+WHERE sqlc.arg('event_type')::text = ANY(webhook_endpoints.event_types)
+AND (webhook_endpoints.user_id IS NULL OR webhook_endpoints.user_id = sqlc.arg('user_id'))
+ON CONFLICT (endpoint_id, event_id) DO NOTHING;
+
+-- name: ClaimWebhookDeliveries :many
+UPDATE webhook_deliveries
+SET next_attempt_at = NOW() + (sqlc.arg('lease_seconds')::int * interval '1 second'),
+    updated_at = NOW()
+FROM webhook_endpoints
+WHERE webhook_endpoints.id = webhook_deliveries.endpoint_id
+AND webhook_deliveries.id IN (
+    SELECT id
+    FROM webhook_deliveries
+    WHERE status = 'pending'
+    AND next_attempt_at <= NOW()
+    ORDER BY next_attempt_at
+    LIMIT sqlc.arg('limit')
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING webhook_deliveries.id, webhook_deliveries.event_type, webhook_deliveries.payload, webhook_deliveries.attempts, webhook_endpoints.url, webhook_endpoints.secret, webhook_endpoints.user_id;
+
+-- name: MarkWebhookDelivered :exec
+UPDATE webhook_deliveries
+SET status = 'delivered',
+    attempts = attempts + 1,
+    response_status = sqlc.arg('response_status'),
+    last_error = '',
+    delivered_at = NOW(),
+    updated_at = NOW()
+WHERE id = sqlc.arg('id');
+
+-- name: MarkWebhookFailed :exec
+UPDATE webhook_deliveries
+SET status = CASE WHEN sqlc.narg('retry_seconds')::int IS NULL THEN 'dead' ELSE 'pending' END,
+    attempts = attempts + 1,
+    response_status = sqlc.narg('response_status'),
+    last_error = sqlc.arg('last_error'),
+    next_attempt_at = NOW() + (COALESCE(sqlc.narg('retry_seconds')::int, 0) * interval '1 second'),
+    updated_at = NOW()
+WHERE id = sqlc.arg('id');
+
+-- name: RetryWebhookDelivery :one
+UPDATE webhook_deliveries
+SET status = 'pending',
+    attempts = 0,
+    next_attempt_at = NOW(),
+    updated_at = NOW()
+WHERE id = sqlc.arg('id')
+AND endpoint_id = sqlc.arg('endpoint_id')
+AND status = 'dead'
+RETURNING *;
+
+-- name: GetWebhookDeliveries :many
+SELECT *
+FROM webhook_deliveries
+WHERE endpoint_id = sqlc.arg('endpoint_id')
+AND (sqlc.narg('status')::text IS NULL OR status = sqlc.narg('status'))
+ORDER BY created_at DESC
+LIMIT sqlc.arg('limit')
+OFFSET sqlc.arg('offset');
@@ -0,0 +1,66 @@
+-- name: WriteOutboxEvent :one
+INSERT INTO outbox (id, created_at, event_type, user_id, payload, next_attempt_at)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    NOW()
+)
+RETURNING *;
+
+-- name: ClaimOutboxEvents :many
+UPDATE outbox
+SET next_attempt_at = NOW() + (sqlc.arg('lease_seconds')::int * interval '1 second')
+WHERE id IN (
+    SELECT id
+    FROM outbox
+    WHERE status = 'pending'
+    AND next_attempt_at <= NOW()
+    ORDER BY seq
+    LIMIT sqlc.arg('limit')
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- name: LockOutboxPublish :exec
+SELECT pg_advisory_xact_lock(hashtext('outbox_publish'));
+
+-- name: MarkOutboxPublished :one
+UPDATE outbox
+SET status = 'published',
+    published_at = NOW(),
+    publish_seq = nextval('outbox_publish_seq'),
+    attempts = attempts + 1,
+    last_error = ''
+WHERE id = $1
+RETURNING publish_seq;
+
+-- name: MarkOutboxFailed :exec
+UPDATE outbox
+SET status = CASE WHEN sqlc.narg('retry_seconds')::int IS NULL THEN 'dead' ELSE 'pending' END,
+    attempts = attempts + 1,
+    last_error = sqlc.arg('last_error'),
+    next_attempt_at = NOW() + (COALESCE(sqlc.narg('retry_seconds')::int, 0) * interval '1 second')
+WHERE id = sqlc.arg('id');
+
+-- name: PurgePublishedOutbox :execrows
+DELETE FROM outbox
+WHERE published_at < NOW() - (sqlc.arg('retention_seconds')::int * interval '1 second');
+
+-- name: GetOutboxEventByPublishSeq :one
+SELECT *
+FROM outbox
+WHERE publish_seq = $1;
+
+-- name: GetPublishedOutboxEvents :many
+SELECT *
+FROM outbox
+WHERE publish_seq > sqlc.arg('after_publish_seq')
+AND event_type = ANY(sqlc.arg('event_types')::text[])
+ORDER BY publish_seq
+LIMIT sqlc.arg('limit');
+
+-- name: NotifyChannel :exec
+SELECT pg_notify(sqlc.arg('channel')::text, sqlc.arg('payload')::text);
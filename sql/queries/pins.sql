@@ -0,0 +1,24 @@
+-- name: PinChirp :exec
+INSERT INTO pinned_chirps (user_id, chirp_id, created_at)
+VALUES (
+    $1,
+    $2,
+    NOW()
+)
+ON CONFLICT (user_id) DO UPDATE
+SET chirp_id = EXCLUDED.chirp_id, created_at = NOW();
+
+-- name: UnpinChirp :exec
+DELETE
+FROM pinned_chirps
+WHERE user_id = $1;
+
+-- name: GetPinnedChirpID :one
+SELECT chirp_id
+FROM pinned_chirps
+WHERE user_id = $1;
+
+-- name: UnpinChirpEverywhere :exec
+DELETE
+FROM pinned_chirps
+WHERE chirp_id = $1;
@@ -0,0 +1,56 @@
+-- name: CreatePoll :one
+INSERT INTO polls (id, created_at, chirp_id, closes_at)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    sqlc.arg('chirp_id'),
+    NOW() + (sqlc.arg('duration_seconds')::int * interval '1 second')
+)
+RETURNING *;
+
+-- name: CreatePollOption :one
+INSERT INTO poll_options (id, poll_id, position, text)
+VALUES (
+    gen_random_uuid(),
+    $1,
+    $2,
+    $3
+)
+RETURNING *;
+
+-- name: GetPollByChirp :one
+SELECT id, created_at, chirp_id, closes_at, closes_at <= NOW() AS closed
+FROM polls
+WHERE chirp_id = $1;
+
+-- name: GetPollsForChirps :many
+SELECT id, created_at, chirp_id, closes_at, closes_at <= NOW() AS closed
+FROM polls
+WHERE chirp_id = ANY(sqlc.arg('chirp_ids')::uuid[]);
+
+-- name: GetPollResults :many
+SELECT
+    poll_options.id,
+    poll_options.poll_id,
+    poll_options.position,
+    poll_options.text,
+    COUNT(poll_votes.user_id) AS votes
+FROM poll_options
+LEFT JOIN poll_votes ON poll_votes.option_id = poll_options.id
+WHERE poll_options.poll_id = ANY(sqlc.arg('poll_ids')::uuid[])
+GROUP BY poll_options.id
+ORDER BY poll_options.poll_id, poll_options.position;
+
+-- name: GetUserVotes :many
+SELECT poll_id, option_id
+FROM poll_votes
+WHERE user_id = sqlc.arg('user_id')
+AND poll_id = ANY(sqlc.arg('poll_ids')::uuid[]);
+
+-- name: CreatePollVote :execrows
+INSERT INTO poll_votes (poll_id, user_id, option_id, created_at)
+SELECT polls.id, sqlc.arg('user_id')::uuid, sqlc.arg('option_id')::uuid, NOW()
+FROM polls
+WHERE polls.id = sqlc.arg('poll_id')
+AND polls.closes_at > NOW()
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,31 @@
+-- name: GetPublicProfile :one
+SELECT
+    users.id,
+    users.created_at,
+    users.username,
+    users.display_name,
+    users.bio,
+    users.avatar_url,
+    users.is_chirpy_red,
+    (SELECT COUNT(*) FROM chirps WHERE chirps.user_id = users.id AND chirps.published_at IS NOT NULL AND chirps.deleted_at IS NULL) AS chirp_count,
+    (SELECT COUNT(*) FROM follows WHERE follows.followee_id = users.id) AS follower_count,
+    (SELECT COUNT(*) FROM follows WHERE follows.follower_id = users.id) AS following_count
+FROM users
+WHERE users.id = $1
+AND users.deletion_requested_at IS NULL;
+
+-- name: GetPublicProfileByUsername :one
+SELECT
+    users.id,
+    users.created_at,
+    users.username,
+    users.display_name,
+    users.bio,
+    users.avatar_url,
+    users.is_chirpy_red,
+    (SELECT COUNT(*) FROM chirps WHERE chirps.user_id = users.id AND chirps.published_at IS NOT NULL AND chirps.deleted_at IS NULL) AS chirp_count,
+    (SELECT COUNT(*) FROM follows WHERE follows.followee_id = users.id) AS follower_count,
+    (SELECT COUNT(*) FROM follows WHERE follows.follower_id = users.id) AS following_count
+FROM users
+WHERE users.username = $1
+AND users.deletion_requested_at IS NULL;
@@ -0,0 +1,75 @@
+-- name: StartSubscription :one
+INSERT INTO subscriptions (id, created_at, updated_at, user_id, plan, status, current_period_start, current_period_end)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    sqlc.arg('user_id'),
+    sqlc.arg('plan'),
+    'active',
+    NOW(),
+    COALESCE(sqlc.narg('period_end')::timestamp, NOW() + (sqlc.arg('period_seconds')::int * interval '1 second'))
+)
+ON CONFLICT (user_id) DO UPDATE
+SET updated_at = NOW(),
+    plan = EXCLUDED.plan,
+    status = 'active',
+    current_period_start = NOW(),
+    current_period_end = EXCLUDED.current_period_end
+RETURNING *;
+
+-- name: RenewSubscription :one
+UPDATE subscriptions
+SET updated_at = NOW(),
+    status = 'active',
+    current_period_start = NOW(),
+    current_period_end = COALESCE(
+        sqlc.narg('period_end')::timestamp,
+        GREATEST(current_period_end, NOW()) + (sqlc.arg('period_seconds')::int * interval '1 second')
+    )
+WHERE user_id = sqlc.arg('user_id')
+RETURNING *;
+
+-- name: SetSubscriptionStatus :one
+UPDATE subscriptions
+SET status = sqlc.arg('status'),
+    updated_at = NOW()
+WHERE user_id = sqlc.arg('user_id')
+AND status <> 'expired'
+RETURNING *;
+
+-- name: EndSubscription :one
+UPDATE subscriptions
+SET status = 'expired',
+    current_period_end = LEAST(current_period_end, NOW()),
+    updated_at = NOW()
+WHERE user_id = $1
+RETURNING *;
+
+-- name: ExpireSubscriptions :many
+UPDATE subscriptions
+SET status = 'expired',
+    updated_at = NOW()
+WHERE status <> 'expired'
+AND current_period_end <= NOW()
+RETURNING *;
+
+-- name: GetSubscription :one
+SELECT * FROM subscriptions WHERE user_id = $1;
+
+-- name: RefreshChirpyRed :exec
+UPDATE users
+SET is_chirpy_red = EXISTS (
+    SELECT 1 FROM subscriptions
+    WHERE subscriptions.user_id = users.id
+    AND subscriptions.status <> 'expired'
+    AND subscriptions.current_period_end > NOW()
+)
+WHERE users.id = ANY(sqlc.arg('user_ids')::uuid[]);
+
+-- name: GetActivePlan :one
+SELECT plan
+FROM subscriptions
+WHERE user_id = $1
+AND status <> 'expired'
+AND current_period_end > NOW();
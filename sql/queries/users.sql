@@ -10,7 +10,7 @@ VALUES (
 RETURNING *;
 
 -- name: GetUserByEmail :one
-SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red FROM users WHERE email = $1;
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, username, display_name, bio, avatar_url, deletion_requested_at FROM users WHERE email = $1;
 
 -- name: UpdateUser :one
 UPDATE users
@@ -19,11 +19,45 @@ SET email = $1,
 WHERE id = $3
 RETURNING *;
 
--- name: UpgradeUser :one
+-- name: ClearUsers :exec
+DELETE FROM users;
+
+-- name: GetUserById :one
+SELECT * FROM users WHERE id = $1;
+
+-- name: UpdateUserPassword :exec
 UPDATE users
-SET is_chirpy_red = true
-WHERE id = $1
+SET hashed_password = $1,
+    updated_at = NOW()
+WHERE id = $2;
+
+-- name: PatchUser :one
+UPDATE users
+SET email = COALESCE(sqlc.narg('email'), email),
+    hashed_password = COALESCE(sqlc.narg('hashed_password'), hashed_password),
+    username = COALESCE(sqlc.narg('username'), username),
+    display_name = COALESCE(sqlc.narg('display_name'), display_name),
+    bio = COALESCE(sqlc.narg('bio'), bio),
+    avatar_url = COALESCE(sqlc.narg('avatar_url'), avatar_url),
+    updated_at = NOW()
+WHERE id = sqlc.arg('id')
 RETURNING *;
 
--- name: ClearUsers :exec
-DELETE FROM users;
\ No newline at end of file
+-- name: RequestUserDeletion :exec
+UPDATE users
+SET deletion_requested_at = NOW(),
+    updated_at = NOW()
+WHERE id = $1;
+
+-- name: CancelUserDeletion :exec
+UPDATE users
+SET deletion_requested_at = NULL,
+    updated_at = NOW()
+WHERE id = $1
+AND deletion_requested_at IS NOT NULL;
+
+-- name: PurgeDeletedUsers :many
+DELETE
+FROM users
+WHERE deletion_requested_at < NOW() - (sqlc.arg('grace_seconds')::int * interval '1 second')
+RETURNING id;
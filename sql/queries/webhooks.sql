@@ -0,0 +1,55 @@
+-- name: RecordWebhookEvent :one
+INSERT INTO webhook_events (id, created_at, updated_at, provider, event_id, event_type, payload, status)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4,
+    'received'
+)
+ON CONFLICT (provider, event_id) DO NOTHING
+RETURNING *;
+
+-- name: GetWebhookEvent :one
+SELECT * FROM webhook_events
+WHERE provider = $1
+AND event_id = $2;
+
+-- name: GetWebhookEventById :one
+SELECT * FROM webhook_events WHERE id = $1;
+
+-- name: ClaimWebhookEvent :one
+UPDATE webhook_events
+SET status = 'received', updated_at = NOW()
+WHERE id = sqlc.arg('id')
+AND (
+    status = 'failed'
+    OR (status = 'received' AND updated_at < NOW() - (sqlc.arg('stale_seconds')::int * interval '1 second'))
+)
+RETURNING *;
+
+-- name: FinishWebhookEvent :exec
+UPDATE webhook_events
+SET status = sqlc.arg('status'),
+    error = sqlc.arg('error'),
+    user_id = sqlc.narg('user_id'),
+    attempts = attempts + 1,
+    processed_at = CASE WHEN sqlc.arg('status') = 'processed' THEN NOW() END,
+    updated_at = NOW()
+WHERE id = sqlc.arg('id');
+
+-- name: ListWebhookEvents :many
+SELECT * FROM webhook_events
+WHERE sqlc.narg('status')::text IS NULL
+OR status = sqlc.narg('status')
+ORDER BY created_at DESC
+LIMIT sqlc.arg('limit')
+OFFSET sqlc.arg('offset');
+
+-- name: GetUserBillingEvents :many
+SELECT * FROM webhook_events
+WHERE user_id = $1
+ORDER BY created_at;
@@ -0,0 +1,32 @@
+-- +goose Up
+CREATE TABLE oauth_clients(
+    id TEXT PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    name TEXT NOT NULL,
+    hashed_secret TEXT,
+    redirect_uri TEXT NOT NULL,
+    scope TEXT NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE oauth_codes(
+    code TEXT PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    client_id TEXT NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    redirect_uri TEXT NOT NULL,
+    scope TEXT NOT NULL,
+    code_challenge TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP
+);
+
+ALTER TABLE refresh_token ADD client_id TEXT REFERENCES oauth_clients(id) ON DELETE CASCADE;
+ALTER TABLE refresh_token ADD scope TEXT NOT NULL DEFAULT '';
+
+-- +goose Down
+ALTER TABLE refresh_token DROP COLUMN scope;
+ALTER TABLE refresh_token DROP COLUMN client_id;
+DROP TABLE oauth_codes;
+DROP TABLE oauth_clients;
@@ -0,0 +1,12 @@
+-- +goose Up
+CREATE TABLE user_identities(
+    provider TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    email TEXT NOT NULL,
+    PRIMARY KEY (provider, subject)
+);
+
+-- +goose Down
+DROP TABLE user_identities;
@@ -0,0 +1,9 @@
+-- +goose Up
+ALTER TABLE users ADD username TEXT UNIQUE;
+ALTER TABLE users ADD display_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD bio TEXT NOT NULL DEFAULT '';
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN bio;
+ALTER TABLE users DROP COLUMN display_name;
+ALTER TABLE users DROP COLUMN username;
@@ -0,0 +1,16 @@
+-- +goose Up
+ALTER TABLE users ADD deletion_requested_at TIMESTAMP;
+
+CREATE TABLE data_exports(
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status TEXT NOT NULL,
+    file_path TEXT NOT NULL DEFAULT '',
+    error TEXT NOT NULL DEFAULT ''
+);
+
+-- +goose Down
+DROP TABLE data_exports;
+ALTER TABLE users DROP COLUMN deletion_requested_at;
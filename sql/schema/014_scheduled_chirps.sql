@@ -0,0 +1,10 @@
+-- +goose Up
+ALTER TABLE chirps ADD COLUMN publish_at TIMESTAMP;
+ALTER TABLE chirps ADD COLUMN published_at TIMESTAMP;
+UPDATE chirps SET published_at = created_at;
+CREATE INDEX chirps_scheduled_idx ON chirps(publish_at) WHERE published_at IS NULL;
+
+-- +goose Down
+DROP INDEX chirps_scheduled_idx;
+ALTER TABLE chirps DROP COLUMN published_at;
+ALTER TABLE chirps DROP COLUMN publish_at;
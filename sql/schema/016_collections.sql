@@ -0,0 +1,22 @@
+-- +goose Up
+CREATE TABLE collections(
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    UNIQUE (user_id, name)
+);
+
+-- Bookmarks go away with their chirp through ON DELETE CASCADE.
+CREATE TABLE bookmarks(
+    collection_id UUID NOT NULL REFERENCES collections(id) ON DELETE CASCADE,
+    chirp_id UUID NOT NULL REFERENCES chirps(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (collection_id, chirp_id)
+);
+CREATE INDEX bookmarks_chirp_id_idx ON bookmarks(chirp_id);
+
+-- +goose Down
+DROP TABLE bookmarks;
+DROP TABLE collections;
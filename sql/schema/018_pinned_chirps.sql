@@ -0,0 +1,9 @@
+-- +goose Up
+CREATE TABLE pinned_chirps(
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    chirp_id UUID NOT NULL UNIQUE REFERENCES chirps(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL
+);
+
+-- +goose Down
+DROP TABLE pinned_chirps;
@@ -0,0 +1,21 @@
+-- +goose Up
+CREATE TABLE webhook_events(
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    provider TEXT NOT NULL,
+    event_id TEXT NOT NULL,
+    event_type TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    status TEXT NOT NULL,
+    error TEXT NOT NULL DEFAULT '',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    processed_at TIMESTAMP,
+    user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    UNIQUE (provider, event_id)
+);
+CREATE INDEX webhook_events_status_idx ON webhook_events(status, created_at);
+CREATE INDEX webhook_events_user_id_idx ON webhook_events(user_id);
+
+-- +goose Down
+DROP TABLE webhook_events;
@@ -0,0 +1,22 @@
+-- +goose Up
+CREATE TABLE subscriptions(
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL UNIQUE REFERENCES users(id) ON DELETE CASCADE,
+    plan TEXT NOT NULL,
+    status TEXT NOT NULL,
+    current_period_start TIMESTAMP NOT NULL,
+    current_period_end TIMESTAMP NOT NULL
+);
+CREATE INDEX subscriptions_period_end_idx ON subscriptions(current_period_end) WHERE status <> 'expired';
+
+-- Existing Chirpy Red users get a subscription for one billing period; the
+-- next renewal event from Polka extends it.
+INSERT INTO subscriptions (id, created_at, updated_at, user_id, plan, status, current_period_start, current_period_end)
+SELECT gen_random_uuid(), NOW(), NOW(), id, 'red', 'active', NOW(), NOW() + interval '30 days'
+FROM users
+WHERE is_chirpy_red;
+
+-- +goose Down
+DROP TABLE subscriptions;
@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE chirps ADD edited_at TIMESTAMP;
+CREATE INDEX chirps_user_id_created_at_idx ON chirps(user_id, created_at);
+
+-- +goose Down
+DROP INDEX chirps_user_id_created_at_idx;
+ALTER TABLE chirps DROP COLUMN edited_at;
@@ -0,0 +1,23 @@
+-- +goose Up
+CREATE TABLE outbox(
+    id UUID PRIMARY KEY,
+    seq BIGINT GENERATED ALWAYS AS IDENTITY UNIQUE,
+    created_at TIMESTAMP NOT NULL,
+    event_type TEXT NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    payload TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT '',
+    next_attempt_at TIMESTAMP NOT NULL,
+    published_at TIMESTAMP
+);
+CREATE INDEX outbox_pending_idx ON outbox(seq) WHERE published_at IS NULL;
+CREATE INDEX outbox_published_at_idx ON outbox(published_at);
+
+-- Webhook deliveries are created by an outbox subscriber, which may see an
+-- event more than once.
+ALTER TABLE webhook_deliveries ADD CONSTRAINT webhook_deliveries_endpoint_event_key UNIQUE (endpoint_id, event_id);
+
+-- +goose Down
+ALTER TABLE webhook_deliveries DROP CONSTRAINT webhook_deliveries_endpoint_event_key;
+DROP TABLE outbox;
@@ -0,0 +1,27 @@
+-- +goose Up
+CREATE TABLE notifications(
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    type TEXT NOT NULL,
+    event_id UUID NOT NULL,
+    actor_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    chirp_id UUID REFERENCES chirps(id) ON DELETE CASCADE,
+    data TEXT NOT NULL DEFAULT '{}',
+    read_at TIMESTAMP,
+    UNIQUE (user_id, event_id)
+);
+CREATE INDEX notifications_user_id_idx ON notifications(user_id, created_at);
+CREATE INDEX notifications_unread_idx ON notifications(user_id) WHERE read_at IS NULL;
+
+CREATE TABLE notification_preferences(
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    type TEXT NOT NULL,
+    enabled BOOLEAN NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (user_id, type)
+);
+
+-- +goose Down
+DROP TABLE notification_preferences;
+DROP TABLE notifications;
@@ -0,0 +1,29 @@
+-- +goose Up
+-- hidden_from is the block and mute rule of every query that reads chirps for
+-- a viewer: a chirp is hidden if the viewer blocked its author or, where
+-- include_muted is set (feeds), muted them. Anonymous viewers see everything.
+-- +goose StatementBegin
+CREATE FUNCTION hidden_from(viewer_id UUID, author_id UUID, include_muted BOOLEAN)
+RETURNS BOOLEAN
+LANGUAGE sql
+STABLE
+AS $$
+    SELECT viewer_id IS NOT NULL AND (
+        EXISTS (
+            SELECT 1
+            FROM user_blocks
+            WHERE blocker_id = viewer_id
+            AND blocked_id = author_id
+        )
+        OR (include_muted AND EXISTS (
+            SELECT 1
+            FROM user_mutes
+            WHERE muter_id = viewer_id
+            AND muted_id = author_id
+        ))
+    )
+$$;
+-- +goose StatementEnd
+
+-- +goose Down
+DROP FUNCTION hidden_from(UUID, UUID, BOOLEAN);
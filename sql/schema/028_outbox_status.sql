@@ -0,0 +1,10 @@
+-- +goose Up
+ALTER TABLE outbox ADD COLUMN status TEXT NOT NULL DEFAULT 'pending';
+UPDATE outbox SET status = 'published' WHERE published_at IS NOT NULL;
+DROP INDEX outbox_pending_idx;
+CREATE INDEX outbox_pending_idx ON outbox(next_attempt_at) WHERE status = 'pending';
+
+-- +goose Down
+DROP INDEX outbox_pending_idx;
+CREATE INDEX outbox_pending_idx ON outbox(seq) WHERE published_at IS NULL;
+ALTER TABLE outbox DROP COLUMN status;
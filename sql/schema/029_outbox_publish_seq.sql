@@ -0,0 +1,12 @@
+-- +goose Up
+-- publish_seq is handed out by the relay as it marks events published, in
+-- commit order, so it is safe to resume the stream from. Events published
+-- before keep their seq, which stays valid as a Last-Event-ID.
+CREATE SEQUENCE outbox_publish_seq;
+ALTER TABLE outbox ADD COLUMN publish_seq BIGINT UNIQUE;
+UPDATE outbox SET publish_seq = seq WHERE published_at IS NOT NULL;
+SELECT setval('outbox_publish_seq', COALESCE(MAX(seq), 0) + 1, false) FROM outbox;
+
+-- +goose Down
+ALTER TABLE outbox DROP COLUMN publish_seq;
+DROP SEQUENCE outbox_publish_seq;
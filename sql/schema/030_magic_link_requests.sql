@@ -0,0 +1,11 @@
+-- +goose Up
+-- Magic links requested per e-mail address in the current window, whether
+-- or not the address is registered. Addresses are stored hashed.
+CREATE TABLE magic_link_requests(
+    email_hash TEXT PRIMARY KEY,
+    window_start TIMESTAMP NOT NULL,
+    requests INTEGER NOT NULL
+);
+
+-- +goose Down
+DROP TABLE magic_link_requests;
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/lighthoof/Chirpy/internal/database"
+	"github.com/lighthoof/Chirpy/internal/oauth"
+	"github.com/lighthoof/Chirpy/internal/stream"
+)
+
+// Postgres channel the outbox relay notifies every instance on.
+const streamChannel = "chirpy_stream"
+
+const (
+	// Messages buffered per connection before it is dropped as too slow.
+	streamBuffer = 64
+	// Events replayed at most when a client resumes.
+	streamReplayLimit = 1000
+	streamHeartbeat   = 15 * time.Second
+	// A write that takes longer than this ends the connection.
+	streamWriteTimeout = 10 * time.Second
+	// How long clients wait before reconnecting, in milliseconds.
+	streamRetry        = 3000
+	streamListenerPing = 90 * time.Second
+)
+
+// Outbox events pushed to stream clients.
+var streamEventTypes = []string{eventChirpCreated, eventChirpUpdated, eventChirpDeleted, eventChirpRestored, eventNotificationCreated}
+
+// broadcastStreamEvent notifies every instance, including this one, of a
+// published outbox event for its stream clients. Only the publish sequence
+// number is sent, as NOTIFY payloads are limited to 8000 bytes.
+func broadcastStreamEvent(ctx context.Context, queries *database.Queries, publishSeq int64) error {
+	return queries.NotifyChannel(ctx, database.NotifyChannelParams{
+		Channel: streamChannel,
+		Payload: strconv.FormatInt(publishSeq, 10),
+	})
+}
+
+// runStreamListener listens for broadcastStreamEvent notifications and
+// publishes the events to the clients connected to this instance.
+// Notifications sent while the connection to Postgres is down are lost;
+// clients catch up when they reconnect with Last-Event-ID.
+func (cfg *apiConfig) runStreamListener(ctx context.Context, dbURL string) {
+	listener := pq.NewListener(dbURL, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Stream listener connection problem: %s", err)
+		}
+	})
+	defer listener.Close()
+
+	err := listener.Listen(streamChannel)
+	if err != nil {
+		log.Printf("Unable to listen for stream events: %s", err)
+		return
+	}
+
+	ping := time.NewTicker(streamListenerPing)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case notification := <-listener.Notify:
+			// A nil notification means the connection was re-established.
+			if notification == nil {
+				continue
+			}
+			err = cfg.dispatchStreamEvent(ctx, notification.Extra)
+			if err != nil {
+				log.Printf("Unable to dispatch stream event %s: %s", notification.Extra, err)
+			}
+		case <-ping.C:
+			go listener.Ping()
+		}
+	}
+}
+
+func (cfg *apiConfig) dispatchStreamEvent(ctx context.Context, payload string) error {
+	publishSeq, err := strconv.ParseInt(payload, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	eventDb, err := cfg.dbQueries.GetOutboxEventByPublishSeq(ctx, publishSeq)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	msg, err := streamMessageFromOutbox(eventDb)
+	if err != nil {
+		return err
+	}
+	cfg.streamHub.Publish(msg)
+	return nil
+}
+
+// streamHandler pushes new chirps, chirp deletions and the notifications of
+// the user as server-sent events. Event IDs are the publish sequence numbers
+// of the outbox, which follow commit order: a client reconnecting with
+// Last-Event-ID first gets the events it missed, up to streamReplayLimit of
+// them, as long as they are still in the outbox.
+// Clients that fall behind are disconnected and are expected to resume.
+func (cfg *apiConfig) streamHandler(w http.ResponseWriter, req *http.Request) {
+	userID, ok := cfg.authenticate(w, req, oauth.ScopeNotifications)
+	if !ok {
+		return
+	}
+
+	lastEventID := int64(0)
+	if header := req.Header.Get("Last-Event-ID"); header != "" {
+		var err error
+		lastEventID, err = strconv.ParseInt(header, 10, 64)
+		if err != nil || lastEventID < 0 {
+			respondWithError(w, http.StatusBadRequest, "Invalid Last-Event-ID")
+			return
+		}
+	}
+
+	if _, ok := w.(http.Flusher); !ok {
+		log.Printf("Streaming unsupported: %s %s", req.Method, req.URL.Path)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	filter, err := cfg.streamFilter(req.Context(), userID)
+	if err != nil {
+		log.Printf("Unable to retrieve hidden users: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	// Subscribe before reading the missed events, so none published in
+	// between are lost.
+	client := cfg.streamHub.Subscribe(streamBuffer, filter)
+	defer cfg.streamHub.Unsubscribe(client)
+
+	missed := []database.Outbox{}
+	if lastEventID > 0 {
+		missed, err = cfg.dbQueries.GetPublishedOutboxEvents(req.Context(), database.GetPublishedOutboxEventsParams{
+			AfterPublishSeq: lastEventID,
+			EventTypes:      streamEventTypes,
+			Limit:           streamReplayLimit,
+		})
+		if err != nil {
+			log.Printf("Unable to retrieve missed events: %s %s [%s]", req.Method, req.URL.Path, err)
+			respondWithError(w, http.StatusInternalServerError, "")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	send := func(write func() error) bool {
+		rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		err := write()
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			log.Printf("Unable to write to stream: %s %s [%s]", req.Method, req.URL.Path, err)
+			return false
+		}
+		return true
+	}
+
+	replayed := map[int64]bool{}
+	ok = send(func() error {
+		err := stream.WriteRetry(w, streamRetry)
+		if err != nil {
+			return err
+		}
+		for _, eventDb := range missed {
+			msg, err := streamMessageFromOutbox(eventDb)
+			if err != nil {
+				log.Printf("Unable to read event %d: %s", eventDb.PublishSeq.Int64, err)
+				continue
+			}
+			replayed[msg.ID] = true
+			if !filter(msg) {
+				continue
+			}
+			err = stream.WriteEvent(w, msg)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if !ok {
+		return
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for ok {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-client.Dropped():
+			log.Printf("Dropped slow stream client: %s %s", req.Method, req.URL.Path)
+			return
+		case <-heartbeat.C:
+			ok = send(func() error { return stream.WriteComment(w, "ping") })
+		case msg := <-client.Messages():
+			if replayed[msg.ID] {
+				continue
+			}
+			ok = send(func() error { return stream.WriteEvent(w, msg) })
+		}
+	}
+}
+
+// streamFilter lets through the notifications of the user and the chirp
+// events of everyone the user has not blocked or muted, as in the feed.
+// Blocks and mutes made later apply once the client reconnects.
+func (cfg *apiConfig) streamFilter(ctx context.Context, userID uuid.UUID) (stream.Filter, error) {
+	hidden, err := cfg.hiddenUserSet(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(msg stream.Message) bool {
+		if msg.Type == eventNotificationCreated {
+			return msg.UserID == userID
+		}
+		return !hidden[msg.UserID]
+	}, nil
+}
+
+// streamMessageFromOutbox turns an outbox event into a stream message.
+// Deletions carry only the IDs of the chirp and its author, so a deleted body
+// is not sent out again, but keep the topics of the chirp.
+func streamMessageFromOutbox(eventDb database.Outbox) (stream.Message, error) {
+	msg := stream.Message{
+		ID:     eventDb.PublishSeq.Int64,
+		Type:   eventDb.EventType,
+		UserID: eventDb.UserID,
+		Data:   []byte(eventDb.Payload),
+	}
+
+	switch eventDb.EventType {
+	case eventNotificationCreated:
+	case eventChirpCreated, eventChirpUpdated, eventChirpDeleted, eventChirpRestored:
+		chirp := Chirp{}
+		err := json.Unmarshal(msg.Data, &chirp)
+		if err != nil {
+			return stream.Message{}, err
+		}
+		msg.Topics = chirpTopics(chirp)
+		if eventDb.EventType == eventChirpDeleted {
+			msg.Data, err = json.Marshal(ChirpRef{ID: chirp.ID, UserID: chirp.UserID})
+			if err != nil {
+				return stream.Message{}, err
+			}
+		}
+	default:
+		return stream.Message{}, errors.New("not a stream event: " + eventDb.EventType)
+	}
+	return msg, nil
+}
+
+// chirpTopics returns the socket channels a chirp event is published on: the
+// home timeline, the thread of the chirp and each of its hashtags. Sessions
+// narrow the home timeline down to the authors they follow.
+func chirpTopics(chirp Chirp) []string {
+	topics := []string{channelHome, channelThread + chirp.ID.String()}
+	for _, hashtag := range parseHashtags(chirp.Body) {
+		topics = append(topics, channelHashtag+hashtag)
+	}
+	return topics
+}
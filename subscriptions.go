@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lighthoof/Chirpy/internal/database"
+	"github.com/lighthoof/Chirpy/internal/entitlements"
+	"github.com/lighthoof/Chirpy/internal/oauth"
+)
+
+const (
+	defaultPlan        = entitlements.PlanRed
+	subscriptionPeriod = 30 * 24 * time.Hour
+)
+
+// Subscription statuses besides "active". Past due and canceled
+// subscriptions keep Chirpy Red until the end of the current period;
+// runSubscriptionSweeper then marks them expired.
+const (
+	subscriptionPastDue  = "past_due"
+	subscriptionCanceled = "canceled"
+	subscriptionExpired  = "expired"
+)
+
+// applySubscriptionEvent updates the subscription of the user for a Polka
+// event, refreshes is_chirpy_red from it and records subscription.changed.
+// The queries should belong to a transaction so all changes are applied
+// together.
+func applySubscriptionEvent(ctx context.Context, queries *database.Queries, userID uuid.UUID, event Event) error {
+	periodEnd := sql.NullTime{}
+	if event.Data.PeriodEnd != nil {
+		periodEnd = sql.NullTime{Time: event.Data.PeriodEnd.UTC(), Valid: true}
+	}
+	plan := event.Data.Plan
+	if plan == "" {
+		plan = defaultPlan
+	}
+	if !entitlements.IsPaidPlan(plan) {
+		return fmt.Errorf("%w: unknown plan %s", errWebhookInvalidEvent, plan)
+	}
+	start := database.StartSubscriptionParams{
+		UserID:        userID,
+		Plan:          plan,
+		PeriodEnd:     periodEnd,
+		PeriodSeconds: int32(subscriptionPeriod.Seconds()),
+	}
+
+	var subscriptionDb database.Subscription
+	var err error
+	switch event.Event {
+	case polkaUserUpgraded:
+		subscriptionDb, err = queries.StartSubscription(ctx, start)
+		if err != nil {
+			return err
+		}
+		err = recordEvent(ctx, queries, eventUserUpgraded, userID, UserUpgrade{
+			UserID:       userID,
+			Subscription: subscriptionFromDb(subscriptionDb),
+		})
+	case polkaSubscriptionRenewed:
+		subscriptionDb, err = queries.RenewSubscription(ctx, database.RenewSubscriptionParams{
+			PeriodEnd:     periodEnd,
+			PeriodSeconds: int32(subscriptionPeriod.Seconds()),
+			UserID:        userID,
+		})
+		// Users upgraded before subscriptions were tracked may only send
+		// renewals.
+		if err == sql.ErrNoRows {
+			subscriptionDb, err = queries.StartSubscription(ctx, start)
+		}
+	case polkaSubscriptionCanceled:
+		subscriptionDb, err = queries.SetSubscriptionStatus(ctx, database.SetSubscriptionStatusParams{
+			Status: subscriptionCanceled,
+			UserID: userID,
+		})
+	case polkaPaymentFailed:
+		subscriptionDb, err = queries.SetSubscriptionStatus(ctx, database.SetSubscriptionStatusParams{
+			Status: subscriptionPastDue,
+			UserID: userID,
+		})
+	case polkaUserDowngraded:
+		subscriptionDb, err = queries.EndSubscription(ctx, userID)
+	default:
+		return errWebhookIgnored
+	}
+	if err == sql.ErrNoRows {
+		return errWebhookIgnored
+	} else if err != nil {
+		return err
+	}
+
+	err = queries.RefreshChirpyRed(ctx, []uuid.UUID{userID})
+	if err != nil {
+		return err
+	}
+
+	return recordEvent(ctx, queries, eventSubscriptionChanged, userID, subscriptionFromDb(subscriptionDb))
+}
+
+// runSubscriptionSweeper expires subscriptions whose period has ended and
+// takes Chirpy Red away from their users.
+func (cfg *apiConfig) runSubscriptionSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := cfg.expireSubscriptions(ctx)
+		if err != nil {
+			log.Printf("Unable to expire subscriptions: %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (cfg *apiConfig) expireSubscriptions(ctx context.Context) error {
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	subscriptionsDb, err := qtx.ExpireSubscriptions(ctx)
+	if err != nil {
+		return err
+	}
+	if len(subscriptionsDb) == 0 {
+		return nil
+	}
+
+	userIDs := []uuid.UUID{}
+	for _, subscriptionDb := range subscriptionsDb {
+		userIDs = append(userIDs, subscriptionDb.UserID)
+		err = recordEvent(ctx, qtx, eventSubscriptionChanged, subscriptionDb.UserID, subscriptionFromDb(subscriptionDb))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = qtx.RefreshChirpyRed(ctx, userIDs)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	log.Printf("Expired %d subscriptions", len(subscriptionsDb))
+	return nil
+}
+
+func (cfg *apiConfig) getSubscriptionHandler(w http.ResponseWriter, req *http.Request) {
+	userID, ok := cfg.authenticate(w, req, oauth.ScopeProfile)
+	if !ok {
+		return
+	}
+
+	subscriptionDb, err := cfg.dbQueries.GetSubscription(req.Context(), userID)
+	if err == sql.ErrNoRows {
+		respondWithError(w, http.StatusNotFound, "No subscription")
+		return
+	} else if err != nil {
+		log.Printf("Unable to retrieve subscription: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, subscriptionFromDb(subscriptionDb))
+}
+
+func subscriptionFromDb(subscriptionDb database.Subscription) Subscription {
+	return Subscription{
+		Plan:               subscriptionDb.Plan,
+		Status:             subscriptionDb.Status,
+		Active:             subscriptionDb.Status != subscriptionExpired,
+		CurrentPeriodStart: subscriptionDb.CurrentPeriodStart,
+		CurrentPeriodEnd:   subscriptionDb.CurrentPeriodEnd,
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lighthoof/Chirpy/internal/database"
+)
+
+// fakeSubscriptions answers the subscription queries used by
+// applySubscriptionEvent and expireSubscriptions from memory, following the
+// SQL in sql/queries, so the transitions can be tested without Postgres.
+type fakeSubscriptions struct {
+	*fakeDB
+	now           time.Time
+	subscriptions map[uuid.UUID]database.Subscription
+	chirpyRed     map[uuid.UUID]bool
+}
+
+func newFakeSubscriptions() *fakeSubscriptions {
+	f := &fakeSubscriptions{
+		fakeDB:        newFakeDB(),
+		now:           time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		subscriptions: map[uuid.UUID]database.Subscription{},
+		chirpyRed:     map[uuid.UUID]bool{},
+	}
+
+	f.handle("StartSubscription", func(args []driver.Value) (*fakeRows, error) {
+		userID := fakeUUID(args[0])
+		subscription, ok := f.subscriptions[userID]
+		if !ok {
+			subscription = database.Subscription{ID: uuid.New(), CreatedAt: f.now, UserID: userID}
+		}
+		subscription.Plan = args[1].(string)
+		subscription.Status = "active"
+		subscription.CurrentPeriodStart = f.now
+		subscription.CurrentPeriodEnd = f.periodEnd(args[2], args[3], f.now)
+		return fakeSubscriptionRows(f.save(subscription)), nil
+	})
+	f.handle("RenewSubscription", func(args []driver.Value) (*fakeRows, error) {
+		subscription, ok := f.subscriptions[fakeUUID(args[2])]
+		if !ok {
+			return nil, nil
+		}
+		from := subscription.CurrentPeriodEnd
+		if f.now.After(from) {
+			from = f.now
+		}
+		subscription.Status = "active"
+		subscription.CurrentPeriodStart = f.now
+		subscription.CurrentPeriodEnd = f.periodEnd(args[0], args[1], from)
+		return fakeSubscriptionRows(f.save(subscription)), nil
+	})
+	f.handle("SetSubscriptionStatus", func(args []driver.Value) (*fakeRows, error) {
+		subscription, ok := f.subscriptions[fakeUUID(args[1])]
+		if !ok || subscription.Status == subscriptionExpired {
+			return nil, nil
+		}
+		subscription.Status = args[0].(string)
+		return fakeSubscriptionRows(f.save(subscription)), nil
+	})
+	f.handle("EndSubscription", func(args []driver.Value) (*fakeRows, error) {
+		subscription, ok := f.subscriptions[fakeUUID(args[0])]
+		if !ok {
+			return nil, nil
+		}
+		subscription.Status = subscriptionExpired
+		if f.now.Before(subscription.CurrentPeriodEnd) {
+			subscription.CurrentPeriodEnd = f.now
+		}
+		return fakeSubscriptionRows(f.save(subscription)), nil
+	})
+	f.handle("ExpireSubscriptions", func(args []driver.Value) (*fakeRows, error) {
+		expired := []database.Subscription{}
+		for _, subscription := range f.subscriptions {
+			if subscription.Status != subscriptionExpired && !subscription.CurrentPeriodEnd.After(f.now) {
+				subscription.Status = subscriptionExpired
+				expired = append(expired, f.save(subscription))
+			}
+		}
+		return fakeSubscriptionRows(expired...), nil
+	})
+	f.handle("RefreshChirpyRed", func(args []driver.Value) (*fakeRows, error) {
+		for _, userID := range fakeUUIDArray(args[0]) {
+			subscription, ok := f.subscriptions[userID]
+			f.chirpyRed[userID] = ok && subscription.Status != subscriptionExpired && subscription.CurrentPeriodEnd.After(f.now)
+		}
+		return nil, nil
+	})
+	return f
+}
+
+// periodEnd is the period_end argument when given, or from plus
+// period_seconds.
+func (f *fakeSubscriptions) periodEnd(periodEnd, periodSeconds driver.Value, from time.Time) time.Time {
+	if end, ok := periodEnd.(time.Time); ok {
+		return end
+	}
+	return from.Add(time.Duration(periodSeconds.(int64)) * time.Second)
+}
+
+func (f *fakeSubscriptions) save(subscription database.Subscription) database.Subscription {
+	subscription.UpdatedAt = f.now
+	f.subscriptions[subscription.UserID] = subscription
+	return subscription
+}
+
+func fakeSubscriptionRows(subscriptions ...database.Subscription) *fakeRows {
+	values := [][]driver.Value{}
+	for _, subscription := range subscriptions {
+		values = append(values, []driver.Value{
+			subscription.ID.String(),
+			subscription.CreatedAt,
+			subscription.UpdatedAt,
+			subscription.UserID.String(),
+			subscription.Plan,
+			subscription.Status,
+			subscription.CurrentPeriodStart,
+			subscription.CurrentPeriodEnd,
+		})
+	}
+	return fakeResult(values...)
+}
+
+func TestSubscriptionTransitions(t *testing.T) {
+	fake := newFakeSubscriptions()
+	cfg := fake.config()
+	ctx := context.Background()
+	userID := uuid.New()
+	start := fake.now
+
+	steps := []struct {
+		name       string
+		event      string
+		advance    time.Duration
+		wantErr    error
+		wantStatus string
+		wantEnd    time.Time
+		wantRed    bool
+	}{
+		{
+			name:       "upgraded",
+			event:      polkaUserUpgraded,
+			wantStatus: "active",
+			wantEnd:    start.Add(subscriptionPeriod),
+			wantRed:    true,
+		},
+		{
+			name:       "payment failed",
+			event:      polkaPaymentFailed,
+			advance:    24 * time.Hour,
+			wantStatus: subscriptionPastDue,
+			wantEnd:    start.Add(subscriptionPeriod),
+			wantRed:    true,
+		},
+		{
+			name:       "renewed",
+			event:      polkaSubscriptionRenewed,
+			wantStatus: "active",
+			wantEnd:    start.Add(2 * subscriptionPeriod),
+			wantRed:    true,
+		},
+		{
+			name:       "renewed again",
+			event:      polkaSubscriptionRenewed,
+			wantStatus: "active",
+			wantEnd:    start.Add(3 * subscriptionPeriod),
+			wantRed:    true,
+		},
+		{
+			name:       "canceled",
+			event:      polkaSubscriptionCanceled,
+			wantStatus: subscriptionCanceled,
+			wantEnd:    start.Add(3 * subscriptionPeriod),
+			wantRed:    true,
+		},
+		{
+			name:       "expired",
+			advance:    3 * subscriptionPeriod,
+			wantStatus: subscriptionExpired,
+			wantEnd:    start.Add(3 * subscriptionPeriod),
+		},
+		{
+			name:       "canceled after expiry",
+			event:      polkaSubscriptionCanceled,
+			wantErr:    errWebhookIgnored,
+			wantStatus: subscriptionExpired,
+			wantEnd:    start.Add(3 * subscriptionPeriod),
+		},
+		{
+			name:       "renewed after expiry",
+			event:      polkaSubscriptionRenewed,
+			wantStatus: "active",
+			wantEnd:    start.Add(4*subscriptionPeriod + 24*time.Hour),
+			wantRed:    true,
+		},
+		{
+			name:       "downgraded",
+			event:      polkaUserDowngraded,
+			advance:    time.Hour,
+			wantStatus: subscriptionExpired,
+			wantEnd:    start.Add(3*subscriptionPeriod + 25*time.Hour),
+		},
+	}
+
+	for _, step := range steps {
+		fake.now = fake.now.Add(step.advance)
+
+		var err error
+		if step.event == "" {
+			err = cfg.expireSubscriptions(ctx)
+		} else {
+			event := Event{Event: step.event, Data: Data{User_id: userID.String()}}
+			err = applySubscriptionEvent(ctx, cfg.dbQueries, userID, event)
+		}
+		if !errors.Is(err, step.wantErr) {
+			t.Fatalf("%s: expected error %v, got %v", step.name, step.wantErr, err)
+		}
+
+		subscription := fake.subscriptions[userID]
+		if subscription.Status != step.wantStatus {
+			t.Errorf("%s: expected status %s, got %s", step.name, step.wantStatus, subscription.Status)
+		}
+		if !subscription.CurrentPeriodEnd.Equal(step.wantEnd) {
+			t.Errorf("%s: expected period end %v, got %v", step.name, step.wantEnd, subscription.CurrentPeriodEnd)
+		}
+		if fake.chirpyRed[userID] != step.wantRed {
+			t.Errorf("%s: expected is_chirpy_red %v, got %v", step.name, step.wantRed, fake.chirpyRed[userID])
+		}
+	}
+}
+
+func TestSubscriptionRenewalStartsSubscription(t *testing.T) {
+	fake := newFakeSubscriptions()
+	cfg := fake.config()
+	userID := uuid.New()
+	periodEnd := fake.now.Add(10 * 24 * time.Hour)
+
+	event := Event{Event: polkaSubscriptionRenewed, Data: Data{User_id: userID.String(), PeriodEnd: &periodEnd}}
+	err := applySubscriptionEvent(context.Background(), cfg.dbQueries, userID, event)
+	if err != nil {
+		t.Fatalf("Renewal without a subscription was rejected: %v", err)
+	}
+
+	subscription := fake.subscriptions[userID]
+	if subscription.Status != "active" || !subscription.CurrentPeriodEnd.Equal(periodEnd) {
+		t.Errorf("Expected an active subscription until %v, got %s until %v",
+			periodEnd, subscription.Status, subscription.CurrentPeriodEnd)
+	}
+	if len(fake.events) != 1 || fake.events[0] != eventSubscriptionChanged {
+		t.Errorf("Expected a single subscription.changed event, got %v", fake.events)
+	}
+}
+
+func TestSubscriptionUnknownPlan(t *testing.T) {
+	fake := newFakeSubscriptions()
+	cfg := fake.config()
+	userID := uuid.New()
+
+	event := Event{Event: polkaUserUpgraded, Data: Data{User_id: userID.String(), Plan: "gold"}}
+	err := applySubscriptionEvent(context.Background(), cfg.dbQueries, userID, event)
+	if !errors.Is(err, errWebhookInvalidEvent) {
+		t.Errorf("Expected errWebhookInvalidEvent, got %v", err)
+	}
+	if _, ok := fake.subscriptions[userID]; ok {
+		t.Errorf("Subscription was started for an unknown plan")
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lighthoof/Chirpy/internal/database"
+	"github.com/lighthoof/Chirpy/internal/media"
+	"github.com/lighthoof/Chirpy/internal/oauth"
+)
+
+var (
+	errNotChirpAuthor  = errors.New("chirp belongs to another user")
+	errChirpNotDeleted = errors.New("chirp is not deleted")
+)
+
+// restoreChirpHandler brings back a deleted chirp of the user, as long as it
+// was deleted less than chirpRestoreWindow ago. Pins and bookmarks removed on
+// delete are not restored.
+func (cfg *apiConfig) restoreChirpHandler(w http.ResponseWriter, req *http.Request) {
+	userID, ok := cfg.authenticate(w, req, oauth.ScopeChirpsWrite)
+	if !ok {
+		return
+	}
+
+	chirpID, err := uuid.Parse(req.PathValue("chirpID"))
+	if err != nil {
+		log.Printf("Unable to parse chirpID: %s", req.PathValue("chirpID"))
+		respondWithError(w, http.StatusBadRequest, "")
+		return
+	}
+
+	chirpDb, err := cfg.dbQueries.GetChirpByIdWithDeleted(req.Context(), chirpID)
+	if err == sql.ErrNoRows {
+		log.Printf("Chirp not found")
+		respondWithError(w, http.StatusNotFound, "")
+		return
+	} else if err != nil {
+		log.Printf("Unable to retrieve chirp: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	err = checkRestore(chirpDb, userID)
+	if errors.Is(err, errNotChirpAuthor) {
+		log.Printf("Unable to validate user")
+		respondWithError(w, http.StatusForbidden, "")
+		return
+	} else if errors.Is(err, errChirpNotDeleted) {
+		respondWithError(w, http.StatusConflict, "Chirp is not deleted")
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(req.Context(), nil)
+	if err != nil {
+		log.Printf("Unable to begin transaction: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	// The window itself is checked by the query, against the database clock.
+	chirpDb, err = qtx.RestoreChirp(req.Context(), database.RestoreChirpParams{
+		ID:            chirpID,
+		WindowSeconds: durationSeconds(cfg.chirpRestoreWindow),
+	})
+	if err == sql.ErrNoRows {
+		respondWithError(w, http.StatusGone, "The restore window has passed")
+		return
+	} else if err != nil {
+		log.Printf("Unable to restore chirp: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respBody := chirpFromDb(chirpDb)
+	err = cfg.loadChirpMedia(req.Context(), qtx, []*Chirp{&respBody})
+	if err != nil {
+		log.Printf("Unable to retrieve chirp media: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	// A scheduled chirp is announced by chirp.created once it is published.
+	if chirpDb.PublishedAt.Valid {
+		err = recordEvent(req.Context(), qtx, eventChirpRestored, chirpDb.UserID, respBody)
+		if err != nil {
+			log.Printf("Unable to record event: %s %s [%s]", req.Method, req.URL.Path, err)
+			respondWithError(w, http.StatusInternalServerError, "")
+			return
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("Unable to commit transaction: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, respBody)
+}
+
+// checkRestore reports whether the user may restore the chirp, leaving the
+// restore window to the query.
+func checkRestore(chirpDb database.Chirp, userID uuid.UUID) error {
+	if chirpDb.UserID != userID {
+		return errNotChirpAuthor
+	}
+	if !chirpDb.DeletedAt.Valid {
+		return errChirpNotDeleted
+	}
+	return nil
+}
+
+// durationSeconds converts a window to the whole seconds the queries take.
+// Windows too long for an int32 are capped rather than wrapping around to a
+// negative window, which would purge every deleted chirp at once.
+func durationSeconds(d time.Duration) int32 {
+	seconds := d / time.Second
+	if seconds > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	if seconds < 0 {
+		return 0
+	}
+	return int32(seconds)
+}
+
+// getDeletedChirpsHandler lets moderators see deleted chirps, most recently
+// deleted first, until they are purged.
+func (cfg *apiConfig) getDeletedChirpsHandler(w http.ResponseWriter, req *http.Request) {
+	if !cfg.authenticateAdmin(w, req) {
+		return
+	}
+
+	limit, offset, err := parsePagination(req)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	chirpsDb, err := cfg.dbQueries.GetDeletedChirps(req.Context(),
+		database.GetDeletedChirpsParams{Limit: limit, Offset: offset})
+	if err != nil {
+		log.Printf("Unable to retrieve deleted chirps: %s %s [%s]", req.Method, req.URL.Path, err)
+		respondWithError(w, http.StatusInternalServerError, "")
+		return
+	}
+
+	respBody := []Chirp{}
+	for _, chirpDb := range chirpsDb {
+		respBody = append(respBody, chirpFromDb(chirpDb))
+	}
+
+	respondWithJSON(w, http.StatusOK, respBody)
+}
+
+// runChirpPurger hard-deletes chirps that were deleted more than
+// chirpRetention ago, together with their media blobs. NOW() is fixed for a
+// transaction, so both queries see the same set of chirps.
+func (cfg *apiConfig) runChirpPurger(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := cfg.purgeDeletedChirps(ctx)
+		if err != nil {
+			log.Printf("Unable to purge deleted chirps: %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (cfg *apiConfig) purgeDeletedChirps(ctx context.Context) error {
+	retentionSeconds := durationSeconds(cfg.chirpRetention)
+
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	mediaDb, err := qtx.GetPurgeableMedia(ctx, retentionSeconds)
+	if err != nil {
+		return err
+	}
+
+	purged, err := qtx.PurgeDeletedChirps(ctx, retentionSeconds)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	deleteMediaBlobs(ctx, cfg.blobStore, mediaDb)
+	if purged > 0 {
+		log.Printf("Purged %d deleted chirps", purged)
+	}
+	return nil
+}
+
+// deleteMediaBlobs removes the blobs of purged media. The rows are already
+// gone, so a failed delete is logged and the remaining blobs are still
+// removed.
+func deleteMediaBlobs(ctx context.Context, store media.BlobStore, mediaDb []database.Medium) {
+	for _, mediumDb := range mediaDb {
+		for _, key := range []string{mediumDb.BlobKey, mediumDb.ThumbnailKey} {
+			err := store.Delete(ctx, key)
+			if err != nil {
+				log.Printf("Unable to delete blob %s: %s", key, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lighthoof/Chirpy/internal/auth"
+	"github.com/lighthoof/Chirpy/internal/database"
+)
+
+func TestCheckRestore(t *testing.T) {
+	authorID := uuid.New()
+	deleted := sql.NullTime{Time: time.Now(), Valid: true}
+
+	cases := []struct {
+		name    string
+		chirpDb database.Chirp
+		userID  uuid.UUID
+		want    error
+	}{
+		{
+			name:    "deleted own chirp",
+			chirpDb: database.Chirp{UserID: authorID, DeletedAt: deleted},
+			userID:  authorID,
+		},
+		{
+			name:    "chirp of another user",
+			chirpDb: database.Chirp{UserID: authorID, DeletedAt: deleted},
+			userID:  uuid.New(),
+			want:    errNotChirpAuthor,
+		},
+		{
+			name:    "chirp not deleted",
+			chirpDb: database.Chirp{UserID: authorID},
+			userID:  authorID,
+			want:    errChirpNotDeleted,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := checkRestore(c.chirpDb, c.userID)
+			if !errors.Is(err, c.want) {
+				t.Errorf("Expected %v, got %v", c.want, err)
+			}
+		})
+	}
+}
+
+func TestDurationSeconds(t *testing.T) {
+	cases := []struct {
+		name     string
+		duration time.Duration
+		want     int32
+	}{
+		{name: "restore window", duration: 30 * 24 * time.Hour, want: 30 * 24 * 60 * 60},
+		{name: "partial second", duration: 1500 * time.Millisecond, want: 1},
+		{name: "zero", duration: 0, want: 0},
+		{name: "negative", duration: -time.Hour, want: 0},
+		{name: "too long", duration: 100 * 365 * 24 * time.Hour, want: math.MaxInt32},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := durationSeconds(c.duration)
+			if got != c.want {
+				t.Errorf("Expected %d seconds, got %d", c.want, got)
+			}
+		})
+	}
+}
+
+// recordingBlobStore remembers deleted keys and fails for the keys in fail.
+type recordingBlobStore struct {
+	deleted []string
+	fail    map[string]bool
+}
+
+func (s *recordingBlobStore) Put(ctx context.Context, key string, r io.Reader) error {
+	return nil
+}
+
+func (s *recordingBlobStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	return nil, errors.New("not stored")
+}
+
+func (s *recordingBlobStore) Delete(ctx context.Context, key string) error {
+	s.deleted = append(s.deleted, key)
+	if s.fail[key] {
+		return errors.New("delete failed")
+	}
+	return nil
+}
+
+func TestDeleteMediaBlobs(t *testing.T) {
+	store := &recordingBlobStore{fail: map[string]bool{"a.png": true}}
+	mediaDb := []database.Medium{
+		{BlobKey: "a.png", ThumbnailKey: "a_thumb.png"},
+		{BlobKey: "b.png", ThumbnailKey: "b_thumb.png"},
+	}
+
+	deleteMediaBlobs(context.Background(), store, mediaDb)
+
+	want := []string{"a.png", "a_thumb.png", "b.png", "b_thumb.png"}
+	if len(store.deleted) != len(want) {
+		t.Fatalf("Expected %d deleted blobs, got %v", len(want), store.deleted)
+	}
+	for i, key := range want {
+		if store.deleted[i] != key {
+			t.Errorf("Expected blob %s to be deleted, got %s", key, store.deleted[i])
+		}
+	}
+}
+
+func TestRestoreChirpRecordsEvent(t *testing.T) {
+	userID := uuid.New()
+	createdAt := time.Now().Add(-2 * time.Hour)
+	deletedAt := time.Now().Add(-time.Hour)
+
+	cases := []struct {
+		name      string
+		published bool
+		want      []string
+	}{
+		{name: "published", published: true, want: []string{eventChirpRestored}},
+		{name: "scheduled"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fake := newFakeDB()
+			cfg := fake.config()
+			cfg.chirpRestoreWindow = 24 * time.Hour
+
+			chirpID := uuid.New()
+			var publishedAt driver.Value
+			if c.published {
+				publishedAt = createdAt
+			}
+			chirpValues := func(deletedAt driver.Value) []driver.Value {
+				return []driver.Value{chirpID.String(), createdAt, createdAt, "hello", userID.String(), nil, publishedAt, deletedAt, nil}
+			}
+			fake.handle("GetChirpByIdWithDeleted", func(args []driver.Value) (*fakeRows, error) {
+				return fakeResult(chirpValues(deletedAt)), nil
+			})
+			fake.handle("RestoreChirp", func(args []driver.Value) (*fakeRows, error) {
+				return fakeResult(chirpValues(nil)), nil
+			})
+			fake.handle("GetMediaForChirps", func(args []driver.Value) (*fakeRows, error) {
+				return nil, nil
+			})
+
+			token, err := auth.MakeJWT(userID, cfg.secret, time.Hour)
+			if err != nil {
+				t.Fatalf("Unable to make token: %v", err)
+			}
+			req := httptest.NewRequest(http.MethodPost, "/api/chirps/"+chirpID.String()+"/restore", nil)
+			req.SetPathValue("chirpID", chirpID.String())
+			req.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+
+			cfg.restoreChirpHandler(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected %d, got %d", http.StatusOK, w.Code)
+			}
+			if !slices.Equal(fake.events, c.want) {
+				t.Errorf("Expected events %v, got %v", c.want, fake.events)
+			}
+		})
+	}
+}